@@ -0,0 +1,247 @@
+package wasmtime
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/rvigee/purego-wasmtime/api"
+)
+
+// GuestAllocator allocates buffers in guest memory through the allocator
+// the guest exports, to pass byte slices and strings as (ptr, len) pairs.
+//
+// NewGuestAllocator recognizes the export pairs emitted by common toolchains,
+// in this order:
+//
+//   - malloc(size) ptr and free(ptr), as in C, Emscripten and TinyGo
+//   - allocate(size) ptr and deallocate(ptr[, size]), as in AssemblyScript
+//     and hand-written Rust
+//   - cabi_realloc(old_ptr, old_size, align, new_size) ptr, as in Rust and
+//     other component model bindings
+//
+// cabi_realloc has no way to free: in the component model, the guest owns
+// the buffers it is given and releases them itself. Free is then a no-op,
+// leaving buffers to the guest, or leaking them if it does not release them.
+//
+// Pointers and sizes are i32, as in 32-bit memories.
+type GuestAllocator struct {
+	mem   api.Memory
+	alloc func(ctx context.Context, size uint32) (uint32, error)
+	free  func(ctx context.Context, ptr, size uint32) error
+}
+
+// NewGuestAllocator detects the allocator exported by mod, which must also
+// export its memory as "memory".
+func NewGuestAllocator(mod api.Module) (*GuestAllocator, error) {
+	mem := mod.ExportedMemory("memory")
+	if mem == nil {
+		return nil, fmt.Errorf("module %q does not export its memory", mod.Name())
+	}
+	a := &GuestAllocator{mem: mem}
+
+	i32 := api.ValueTypeI32
+	switch {
+	case hasSignature(mod, "malloc", []api.ValueType{i32}, []api.ValueType{i32}) &&
+		hasSignature(mod, "free", []api.ValueType{i32}, nil):
+		malloc, free := mod.ExportedFunction("malloc"), mod.ExportedFunction("free")
+		a.alloc = func(ctx context.Context, size uint32) (uint32, error) {
+			return callAlloc(ctx, malloc, EncodeU32(size))
+		}
+		a.free = func(ctx context.Context, ptr, size uint32) error {
+			_, err := free.Call(ctx, EncodeU32(ptr))
+			return err
+		}
+
+	case hasSignature(mod, "allocate", []api.ValueType{i32}, []api.ValueType{i32}) &&
+		(hasSignature(mod, "deallocate", []api.ValueType{i32, i32}, nil) ||
+			hasSignature(mod, "deallocate", []api.ValueType{i32}, nil)):
+		allocate, deallocate := mod.ExportedFunction("allocate"), mod.ExportedFunction("deallocate")
+		withSize := len(deallocate.Definition().ParamTypes()) == 2
+		a.alloc = func(ctx context.Context, size uint32) (uint32, error) {
+			return callAlloc(ctx, allocate, EncodeU32(size))
+		}
+		a.free = func(ctx context.Context, ptr, size uint32) error {
+			params := []uint64{EncodeU32(ptr)}
+			if withSize {
+				params = append(params, EncodeU32(size))
+			}
+			_, err := deallocate.Call(ctx, params...)
+			return err
+		}
+
+	case hasSignature(mod, "cabi_realloc", []api.ValueType{i32, i32, i32, i32}, []api.ValueType{i32}):
+		realloc := mod.ExportedFunction("cabi_realloc")
+		a.alloc = func(ctx context.Context, size uint32) (uint32, error) {
+			return callAlloc(ctx, realloc, 0, 0, EncodeU32(1), EncodeU32(size))
+		}
+		// Reallocating to zero bytes is not allowed, so buffers are left to
+		// the guest
+		a.free = func(ctx context.Context, ptr, size uint32) error {
+			return nil
+		}
+
+	default:
+		return nil, fmt.Errorf("module %q exports no known allocator (malloc/free, allocate/deallocate or cabi_realloc)", mod.Name())
+	}
+
+	return a, nil
+}
+
+// hasSignature reports whether mod exports a function name with the given
+// param and result types.
+func hasSignature(mod api.Module, name string, params, results []api.ValueType) bool {
+	fn := mod.ExportedFunction(name)
+	if fn == nil {
+		return false
+	}
+	def := fn.Definition()
+	return equalValueTypes(def.ParamTypes(), params) && equalValueTypes(def.ResultTypes(), results)
+}
+
+// callAlloc calls a guest allocation function, treating null as failure.
+func callAlloc(ctx context.Context, fn api.Function, params ...uint64) (uint32, error) {
+	results, err := fn.Call(ctx, params...)
+	if err != nil {
+		return 0, err
+	}
+	ptr := DecodeU32(results[0])
+	if ptr == 0 {
+		return 0, fmt.Errorf("guest allocation failed")
+	}
+	return ptr, nil
+}
+
+// Memory returns the guest memory buffers are allocated in.
+func (a *GuestAllocator) Memory() api.Memory {
+	return a.mem
+}
+
+// Malloc allocates size bytes of guest memory. Zero-sized requests
+// allocate one byte, so that every buffer has a distinct non-null pointer.
+func (a *GuestAllocator) Malloc(ctx context.Context, size uint32) (uint32, error) {
+	ptr, err := a.alloc(ctx, max(size, 1))
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate %d bytes: %w", size, err)
+	}
+	return ptr, nil
+}
+
+// Free releases a buffer of size bytes returned by Malloc. It does nothing
+// for cabi_realloc allocators, whose buffers are released by the guest.
+func (a *GuestAllocator) Free(ctx context.Context, ptr, size uint32) error {
+	if err := a.free(ctx, ptr, max(size, 1)); err != nil {
+		return fmt.Errorf("failed to free %d bytes at %#x: %w", size, ptr, err)
+	}
+	return nil
+}
+
+// PassBytes copies b into a newly allocated guest buffer and returns its
+// pointer and length. The caller owns the buffer and releases it with Free.
+func (a *GuestAllocator) PassBytes(ctx context.Context, b []byte) (ptr, length uint32, err error) {
+	length = uint32(len(b))
+	if ptr, err = a.Malloc(ctx, length); err != nil {
+		return 0, 0, err
+	}
+	if !a.mem.Write(ptr, b) {
+		a.Free(ctx, ptr, length)
+		return 0, 0, fmt.Errorf("guest buffer at %#x is out of range of memory", ptr)
+	}
+	return ptr, length, nil
+}
+
+// PassString is like PassBytes for a string. No NUL terminator is added.
+func (a *GuestAllocator) PassString(ctx context.Context, s string) (ptr, length uint32, err error) {
+	length = uint32(len(s))
+	if ptr, err = a.Malloc(ctx, length); err != nil {
+		return 0, 0, err
+	}
+	if !a.mem.WriteString(ptr, s) {
+		a.Free(ctx, ptr, length)
+		return 0, 0, fmt.Errorf("guest buffer at %#x is out of range of memory", ptr)
+	}
+	return ptr, length, nil
+}
+
+// ReadBytes returns a copy of length bytes of guest memory at ptr, or false
+// if they are out of range.
+func (a *GuestAllocator) ReadBytes(ptr, length uint32) ([]byte, bool) {
+	b, ok := a.mem.Read(ptr, length)
+	if !ok {
+		return nil, false
+	}
+	return slices.Clone(b), true
+}
+
+// ReadString returns a copy of length bytes of guest memory at ptr as a
+// string, or false if they are out of range.
+func (a *GuestAllocator) ReadString(ptr, length uint32) (string, bool) {
+	b, ok := a.mem.Read(ptr, length)
+	if !ok {
+		return "", false
+	}
+	return string(b), true
+}
+
+// Scope returns an AllocScope whose buffers are all freed together.
+func (a *GuestAllocator) Scope() *AllocScope {
+	return &AllocScope{alloc: a}
+}
+
+// AllocScope tracks guest buffers passed for a call so that they are freed
+// together when the scope ends:
+//
+//	scope := alloc.Scope()
+//	ptr, n, err := scope.PassString(ctx, "hello")
+//	results, err := scope.Call(ctx, greet, wasmtime.EncodeU32(ptr), wasmtime.EncodeU32(n))
+type AllocScope struct {
+	alloc   *GuestAllocator
+	buffers []allocation
+}
+
+type allocation struct {
+	ptr, size uint32
+}
+
+// PassBytes is like GuestAllocator.PassBytes, with the buffer freed when the
+// scope ends.
+func (s *AllocScope) PassBytes(ctx context.Context, b []byte) (ptr, length uint32, err error) {
+	ptr, length, err = s.alloc.PassBytes(ctx, b)
+	if err == nil {
+		s.buffers = append(s.buffers, allocation{ptr, length})
+	}
+	return ptr, length, err
+}
+
+// PassString is like GuestAllocator.PassString, with the buffer freed when
+// the scope ends.
+func (s *AllocScope) PassString(ctx context.Context, str string) (ptr, length uint32, err error) {
+	ptr, length, err = s.alloc.PassString(ctx, str)
+	if err == nil {
+		s.buffers = append(s.buffers, allocation{ptr, length})
+	}
+	return ptr, length, err
+}
+
+// Call calls fn with params and then ends the scope, whether or not the call
+// succeeded.
+func (s *AllocScope) Call(ctx context.Context, fn api.Function, params ...uint64) ([]uint64, error) {
+	results, err := fn.Call(ctx, params...)
+	if closeErr := s.Close(ctx); err == nil {
+		err = closeErr
+	}
+	return results, err
+}
+
+// Close ends the scope, freeing its buffers in reverse order of allocation.
+// The scope can be reused afterwards.
+func (s *AllocScope) Close(ctx context.Context) error {
+	var firstErr error
+	for _, b := range slices.Backward(s.buffers) {
+		if err := s.alloc.Free(ctx, b.ptr, b.size); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.buffers = s.buffers[:0]
+	return firstErr
+}
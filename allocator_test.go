@@ -0,0 +1,121 @@
+package wasmtime
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuestAllocator(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntime(ctx)
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	// Each flavor wraps the same bump allocator, counting live buffers, and
+	// sum adds up the bytes of a buffer.
+	const common = `
+		(memory (export "memory") 1)
+		(global $heap (mut i32) (i32.const 1024))
+		(global $live (export "live") (mut i32) (i32.const 0))
+		(func $alloc (param $size i32) (result i32)
+			(local $ptr i32)
+			(local.set $ptr (global.get $heap))
+			(global.set $heap (i32.add (global.get $heap) (local.get $size)))
+			(global.set $live (i32.add (global.get $live) (i32.const 1)))
+			(local.get $ptr))
+		(func $free
+			(global.set $live (i32.sub (global.get $live) (i32.const 1))))
+		(func (export "sum") (param $ptr i32) (param $len i32) (result i32)
+			(local $sum i32)
+			(block $done
+				(loop $next
+					(br_if $done (i32.eqz (local.get $len)))
+					(local.set $sum (i32.add (local.get $sum) (i32.load8_u (local.get $ptr))))
+					(local.set $ptr (i32.add (local.get $ptr) (i32.const 1)))
+					(local.set $len (i32.sub (local.get $len) (i32.const 1)))
+					(br $next)))
+			(local.get $sum))
+	`
+	// cabi_realloc traps when reallocating to zero bytes, like the
+	// debug assertion of wit-bindgen, and when reallocating at all.
+	const cabiRealloc = `
+		(func (export "cabi_realloc") (param i32 i32 i32 i32) (result i32)
+			(if (i32.or (local.get 0) (i32.eqz (local.get 3)))
+				(then unreachable))
+			(call $alloc (local.get 3)))`
+	flavors := map[string]struct {
+		exports string
+		frees   bool
+	}{
+		"malloc": {`
+			(func (export "malloc") (param i32) (result i32) (call $alloc (local.get 0)))
+			(func (export "free") (param i32) (call $free))`, true},
+		"allocate": {`
+			(func (export "allocate") (param i32) (result i32) (call $alloc (local.get 0)))
+			(func (export "deallocate") (param i32 i32) (call $free))` + cabiRealloc, true},
+		"cabi_realloc": {cabiRealloc, false},
+	}
+
+	for name, flavor := range flavors {
+		t.Run(name, func(t *testing.T) {
+			compiled, err := r.CompileModule(ctx, []byte(fmt.Sprintf("(module %s %s)", common, flavor.exports)))
+			require.NoError(t, err)
+			defer compiled.Close()
+
+			mod, err := r.Instantiate(ctx, compiled)
+			require.NoError(t, err)
+			defer mod.Close(ctx)
+
+			alloc, err := NewGuestAllocator(mod)
+			require.NoError(t, err)
+			live := mod.ExportedGlobal("live")
+			// freed returns the number of live buffers expected once n of
+			// the live ones were freed
+			freed := func(n uint64) uint64 {
+				if flavor.frees {
+					return live.Get(ctx) - n
+				}
+				return live.Get(ctx)
+			}
+
+			ptr, n, err := alloc.PassString(ctx, "hello")
+			require.NoError(t, err)
+			assert.Equal(t, uint32(5), n)
+			s, ok := alloc.ReadString(ptr, n)
+			require.True(t, ok)
+			assert.Equal(t, "hello", s)
+			want := freed(1)
+			require.NoError(t, alloc.Free(ctx, ptr, n))
+			assert.Equal(t, want, live.Get(ctx))
+
+			scope := alloc.Scope()
+			ptr, n, err = scope.PassBytes(ctx, []byte{1, 2, 3})
+			require.NoError(t, err)
+			_, _, err = scope.PassString(ctx, "unused")
+			require.NoError(t, err)
+			assert.Equal(t, want+2, live.Get(ctx))
+
+			want = freed(2)
+			results, err := scope.Call(ctx, mod.ExportedFunction("sum"), EncodeU32(ptr), EncodeU32(n))
+			require.NoError(t, err)
+			assert.Equal(t, uint32(6), DecodeU32(results[0]))
+			assert.Equal(t, want, live.Get(ctx), "the scope frees its buffers after the call")
+		})
+	}
+
+	t.Run("no_allocator", func(t *testing.T) {
+		compiled, err := r.CompileModule(ctx, []byte(`(module (memory (export "memory") 1))`))
+		require.NoError(t, err)
+		defer compiled.Close()
+
+		mod, err := r.Instantiate(ctx, compiled)
+		require.NoError(t, err)
+		defer mod.Close(ctx)
+
+		_, err = NewGuestAllocator(mod)
+		assert.Error(t, err)
+	})
+}
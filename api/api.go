@@ -37,6 +37,14 @@ type Function interface {
 	// Call invokes the function with the given parameters.
 	// Parameters and results are encoded as uint64 values.
 	Call(ctx context.Context, params ...uint64) ([]uint64, error)
+
+	// CallWithStack invokes the function with params read from the start of
+	// stack, and writes results back to the start of stack. stack must hold
+	// max(len(params), len(results)) values.
+	//
+	// Unlike Call, this reuses a caller-owned buffer, so calling it in a loop
+	// does not allocate. This matches wazero's api.Function.CallWithStack.
+	CallWithStack(ctx context.Context, stack []uint64) error
 }
 
 // Memory is an exported WebAssembly memory.
@@ -53,6 +61,73 @@ type Memory interface {
 	// Grow grows the memory by the given number of pages.
 	// Returns the previous size in pages, or false if failed.
 	Grow(ctx context.Context, delta uint64) (uint64, bool)
+
+	// Definition returns the type of the memory.
+	Definition() MemoryDefinition
+
+	// The following methods are compatible with wazero's api.Memory. They
+	// check the access against the current memory size and return false
+	// rather than fault when it is out of range. Multi-byte values are
+	// little-endian, as in WebAssembly.
+	//
+	// Like wazero's, their offsets are 32-bit, so they only reach the first
+	// 4GiB of memory64 memories. Past it, use Read64 and Write64 with
+	// encoding/binary.LittleEndian, or wasmtime.NewMemoryView64.
+
+	// ReadByte reads a single byte at offset.
+	ReadByte(offset uint32) (byte, bool)
+
+	// ReadUint16Le reads a uint16 at offset.
+	ReadUint16Le(offset uint32) (uint16, bool)
+
+	// ReadUint32Le reads a uint32 at offset.
+	ReadUint32Le(offset uint32) (uint32, bool)
+
+	// ReadFloat32Le reads a float32 at offset.
+	ReadFloat32Le(offset uint32) (float32, bool)
+
+	// ReadUint64Le reads a uint64 at offset.
+	ReadUint64Le(offset uint32) (uint64, bool)
+
+	// ReadFloat64Le reads a float64 at offset.
+	ReadFloat64Le(offset uint32) (float64, bool)
+
+	// Read returns a view of byteCount bytes at offset. The view aliases
+	// guest memory: writes to it are visible to the guest, and it must not
+	// be used after the memory grows, since growing may move the memory.
+	Read(offset, byteCount uint32) ([]byte, bool)
+
+	// WriteByte writes a single byte at offset.
+	WriteByte(offset uint32, v byte) bool
+
+	// WriteUint16Le writes a uint16 at offset.
+	WriteUint16Le(offset uint32, v uint16) bool
+
+	// WriteUint32Le writes a uint32 at offset.
+	WriteUint32Le(offset, v uint32) bool
+
+	// WriteFloat32Le writes a float32 at offset.
+	WriteFloat32Le(offset uint32, v float32) bool
+
+	// WriteUint64Le writes a uint64 at offset.
+	WriteUint64Le(offset uint32, v uint64) bool
+
+	// WriteFloat64Le writes a float64 at offset.
+	WriteFloat64Le(offset uint32, v float64) bool
+
+	// Write copies v to offset.
+	Write(offset uint32, v []byte) bool
+
+	// WriteString copies v to offset.
+	WriteString(offset uint32, v string) bool
+
+	// Read64 is like Read with a 64-bit offset, to reach past the first 4GiB
+	// of memory64 memories.
+	Read64(offset, byteCount uint64) ([]byte, bool)
+
+	// Write64 is like Write with a 64-bit offset, to reach past the first
+	// 4GiB of memory64 memories.
+	Write64(offset uint64, v []byte) bool
 }
 
 // FunctionDefinition describes a function's signature.
@@ -136,6 +211,19 @@ type Global interface {
 	Set(ctx context.Context, v uint64) error
 }
 
+// V128Global is implemented by globals able to hold a full 128-bit vector.
+// Get and Set of a v128 Global only carry its low 64 bits, so callers type
+// assert to V128Global to access both halves.
+type V128Global interface {
+	Global
+
+	// GetV128 returns both halves of the current value.
+	GetV128(ctx context.Context) (lo, hi uint64)
+
+	// SetV128 sets both halves of the value if the global is mutable.
+	SetV128(ctx context.Context, lo, hi uint64) error
+}
+
 // Table is an exported WebAssembly table.
 // This matches wazero's api.Table interface.
 type Table interface {
@@ -159,16 +247,24 @@ type Table interface {
 }
 
 // MemoryDefinition describes a memory's limits and characteristics.
-// This matches wazero's api.MemoryDefinition interface.
+// This follows wazero's api.MemoryDefinition interface, with 64-bit limits
+// to describe memory64 memories.
 type MemoryDefinition interface {
 	// Min returns the minimum memory size in pages (64KiB each).
-	Min() uint32
+	Min() uint64
 
 	// Max returns the maximum memory size in pages, or 0 if unbounded.
-	Max() uint32
+	Max() uint64
 
 	// IsMaxEncoded returns true if a maximum size was encoded in the binary.
 	IsMaxEncoded() bool
+
+	// Is64 returns true for memories indexed by i64, from the memory64
+	// proposal.
+	Is64() bool
+
+	// IsShared returns true for shared memories, from the threads proposal.
+	IsShared() bool
 }
 
 // ExportDefinition describes an exported item from a module.
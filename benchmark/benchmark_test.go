@@ -186,6 +186,76 @@ func BenchmarkPurego_Exec(b *testing.B) {
 	}
 }
 
+func BenchmarkPurego_ExecCallWithStack(b *testing.B) {
+	ctx := b.Context()
+	runtime, err := purego.NewRuntime(ctx)
+	require.NoError(b, err)
+	defer runtime.Close(ctx)
+
+	mod, err := runtime.CompileModule(ctx, fibWasm)
+	require.NoError(b, err)
+	defer mod.Close()
+
+	instance, err := runtime.Instantiate(ctx, mod)
+	require.NoError(b, err)
+
+	fib := instance.ExportedFunction("fib")
+	require.NotNil(b, fib)
+
+	stack := make([]uint64, 1)
+
+	b.ResetTimer()
+
+	for b.Loop() {
+		stack[0] = uint64(fibIterations)
+		err := fib.CallWithStack(ctx, stack)
+		require.NoError(b, err)
+		require.Equal(b, uint64(75025), stack[0])
+	}
+}
+
+// BenchmarkPurego_CallOverhead compares Call and CallWithStack on a call
+// that does almost no work, so the per-call overhead dominates. Both take
+// the unchecked path, as fib only has numeric types; BenchmarkCallWithStack
+// in the root package compares it with the checked one.
+func BenchmarkPurego_CallOverhead(b *testing.B) {
+	ctx := b.Context()
+	runtime, err := purego.NewRuntime(ctx)
+	require.NoError(b, err)
+	defer runtime.Close(ctx)
+
+	mod, err := runtime.CompileModule(ctx, fibWasm)
+	require.NoError(b, err)
+	defer mod.Close()
+
+	instance, err := runtime.Instantiate(ctx, mod)
+	require.NoError(b, err)
+
+	fib := instance.ExportedFunction("fib")
+	require.NotNil(b, fib)
+
+	b.Run("Call", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			res, err := fib.Call(ctx, 1)
+			if err != nil || res[0] != 1 {
+				b.Fatalf("fib(1) = %v, %v", res, err)
+			}
+		}
+	})
+
+	b.Run("CallWithStack", func(b *testing.B) {
+		b.ReportAllocs()
+		stack := make([]uint64, 1)
+		for b.Loop() {
+			stack[0] = 1
+			if err := fib.CallWithStack(ctx, stack); err != nil || stack[0] != 1 {
+				b.Fatalf("fib(1) = %v, %v", stack[0], err)
+			}
+		}
+	})
+}
+
 // --- Wasmtime Go (Cgo) ---
 
 func BenchmarkWasmtimeGo_Compile(b *testing.B) {
@@ -22,13 +22,19 @@ type libraryHandle struct {
 // bindings holds all purego function pointers for a wasmtime instance
 type bindings struct {
 	// Engine functions
-	wasm_engine_new    func() wasm_engine_t
-	wasm_engine_delete func(wasm_engine_t)
+	wasm_engine_new                   func() wasm_engine_t
+	wasm_engine_new_with_config       func(wasm_config_t) wasm_engine_t
+	wasm_engine_delete                func(wasm_engine_t)
+	wasm_config_new                   func() wasm_config_t
+	wasmtime_config_wasm_memory64_set func(wasm_config_t, bool)
+	wasmtime_config_wasm_threads_set  func(wasm_config_t, bool)
 
 	// Store functions
-	wasmtime_store_new     func(wasm_engine_t, uintptr, uintptr) wasmtime_store_t
-	wasmtime_store_delete  func(wasmtime_store_t)
-	wasmtime_store_context func(wasmtime_store_t) wasmtime_context_t
+	wasmtime_store_new        func(wasm_engine_t, uintptr, uintptr) wasmtime_store_t
+	wasmtime_store_delete     func(wasmtime_store_t)
+	wasmtime_store_context    func(wasmtime_store_t) wasmtime_context_t
+	wasmtime_context_get_data func(wasmtime_context_t) uintptr
+	wasmtime_context_set_data func(wasmtime_context_t, uintptr)
 
 	// WAT conversion
 	wasmtime_wat2wasm func(*byte, uintptr, *wasm_byte_vec_t) wasmtime_error_t
@@ -38,20 +44,31 @@ type bindings struct {
 	wasmtime_module_delete func(wasmtime_module_t)
 
 	// Instance functions
-	wasmtime_instance_new        func(wasmtime_context_t, wasmtime_module_t, *wasmtime_extern_t, uintptr, *wasmtime_instance_t, **wasm_trap_t) wasmtime_error_t
+	wasmtime_instance_new        func(wasmtime_context_t, wasmtime_module_t, *wasmtime_extern_t, uintptr, *wasmtime_instance_t, *wasm_trap_t) wasmtime_error_t
 	wasmtime_instance_export_get func(wasmtime_context_t, *wasmtime_instance_t, *byte, uintptr, *wasmtime_extern_t) bool
+	wasmtime_instance_export_nth func(wasmtime_context_t, *wasmtime_instance_t, uintptr, **byte, *uintptr, *wasmtime_extern_t) bool
+	wasmtime_extern_delete       func(*wasmtime_extern_t)
 
 	// Function calling
-	wasmtime_func_call func(wasmtime_context_t, *wasmtime_func_t, *wasmtime_val_t, uintptr, *wasmtime_val_t, uintptr, **wasm_trap_t) wasmtime_error_t
-	wasmtime_func_type func(wasmtime_context_t, *wasmtime_func_t) wasm_functype_t
+	wasmtime_func_call           func(wasmtime_context_t, *wasmtime_func_t, *wasmtime_val_t, uintptr, *wasmtime_val_t, uintptr, *wasm_trap_t) wasmtime_error_t
+	wasmtime_func_call_unchecked func(wasmtime_context_t, *wasmtime_func_t, *wasmtime_val_raw_t, uintptr, *wasm_trap_t) wasmtime_error_t
+	wasmtime_func_type           func(wasmtime_context_t, *wasmtime_func_t) wasm_functype_t
 
 	// Error handling
 	wasmtime_error_message     func(wasmtime_error_t, *wasm_byte_vec_t)
 	wasmtime_error_delete      func(wasmtime_error_t)
 	wasmtime_error_exit_status func(wasmtime_error_t, *int32) bool
-	wasm_trap_new              func(*byte, uintptr) wasm_trap_t
+	wasmtime_trap_new          func(*byte, uintptr) wasm_trap_t
 	wasm_trap_message          func(wasm_trap_t, *wasm_byte_vec_t)
 	wasm_trap_delete           func(wasm_trap_t)
+	wasmtime_trap_code         func(wasm_trap_t, *uint8) bool
+	wasm_trap_trace            func(wasm_trap_t, *wasm_frame_vec_t)
+	wasm_frame_vec_delete      func(*wasm_frame_vec_t)
+	wasm_frame_func_index      func(wasm_frame_t) uint32
+	wasm_frame_func_offset     func(wasm_frame_t) uintptr
+	wasm_frame_module_offset   func(wasm_frame_t) uintptr
+	wasmtime_frame_func_name   func(wasm_frame_t) *wasm_byte_vec_t
+	wasmtime_frame_module_name func(wasm_frame_t) *wasm_byte_vec_t
 
 	// Byte vectors
 	wasm_byte_vec_new_uninitialized func(*wasm_byte_vec_t, uintptr)
@@ -64,12 +81,15 @@ type bindings struct {
 	wasm_valtype_kind     func(wasm_valtype_t) wasm_valkind_t
 
 	// Linker functions
-	wasmtime_linker_new         func(wasm_engine_t) wasmtime_linker_t
-	wasmtime_linker_delete      func(wasmtime_linker_t)
-	wasmtime_linker_define_wasi func(wasmtime_linker_t) wasmtime_error_t
-	wasmtime_caller_export_get  func(uintptr, *byte, uintptr, *wasmtime_extern_t) bool
-	wasmtime_linker_instantiate func(wasmtime_linker_t, wasmtime_context_t, wasmtime_module_t, *wasmtime_instance_t, **wasm_trap_t) wasmtime_error_t
-	wasmtime_linker_define      func(wasmtime_linker_t, wasmtime_context_t, *byte, uintptr, *byte, uintptr, *wasmtime_extern_t) wasmtime_error_t
+	wasmtime_linker_new             func(wasm_engine_t) wasmtime_linker_t
+	wasmtime_linker_delete          func(wasmtime_linker_t)
+	wasmtime_linker_define_wasi     func(wasmtime_linker_t) wasmtime_error_t
+	wasmtime_caller_export_get      func(uintptr, *byte, uintptr, *wasmtime_extern_t) bool
+	wasmtime_caller_context         func(uintptr) wasmtime_context_t
+	wasmtime_linker_instantiate     func(wasmtime_linker_t, wasmtime_context_t, wasmtime_module_t, *wasmtime_instance_t, *wasm_trap_t) wasmtime_error_t
+	wasmtime_linker_define          func(wasmtime_linker_t, wasmtime_context_t, *byte, uintptr, *byte, uintptr, *wasmtime_extern_t) wasmtime_error_t
+	wasmtime_linker_define_func     func(wasmtime_linker_t, *byte, uintptr, *byte, uintptr, wasm_functype_t, uintptr, uintptr, uintptr) wasmtime_error_t
+	wasmtime_linker_allow_shadowing func(wasmtime_linker_t, bool)
 
 	// Memory functions
 	wasmtime_memory_data      func(wasmtime_context_t, *wasmtime_memory_t) unsafe.Pointer
@@ -78,14 +98,26 @@ type bindings struct {
 	wasmtime_memory_grow      func(wasmtime_context_t, *wasmtime_memory_t, uint64, *uint64) wasmtime_error_t
 
 	// Global functions
-	wasmtime_global_get func(wasmtime_context_t, *wasmtime_global_t, *wasmtime_val_t)
-	wasmtime_global_set func(wasmtime_context_t, *wasmtime_global_t, *wasmtime_val_t)
+	wasmtime_global_get  func(wasmtime_context_t, *wasmtime_global_t, *wasmtime_val_t)
+	wasmtime_global_set  func(wasmtime_context_t, *wasmtime_global_t, *wasmtime_val_t) wasmtime_error_t
+	wasmtime_global_type func(wasmtime_context_t, *wasmtime_global_t) wasm_globaltype_t
 
 	// Table functions
-	wasmtime_table_size func(wasmtime_context_t, *wasmtime_table_t) uint32
-	wasmtime_table_get  func(wasmtime_context_t, *wasmtime_table_t, uint32, *wasmtime_val_t) bool
-	wasmtime_table_set  func(wasmtime_context_t, *wasmtime_table_t, uint32, *wasmtime_val_t) bool
-	wasmtime_table_grow func(wasmtime_context_t, *wasmtime_table_t, uint32, *wasmtime_val_t, *uint32) wasmtime_error_t
+	wasmtime_table_size    func(wasmtime_context_t, *wasmtime_table_t) uint64
+	wasmtime_table_get     func(wasmtime_context_t, *wasmtime_table_t, uint64, *wasmtime_val_t) bool
+	wasmtime_table_set     func(wasmtime_context_t, *wasmtime_table_t, uint64, *wasmtime_val_t) wasmtime_error_t
+	wasmtime_table_grow    func(wasmtime_context_t, *wasmtime_table_t, uint64, *wasmtime_val_t, *uint64) wasmtime_error_t
+	wasmtime_table_type    func(wasmtime_context_t, *wasmtime_table_t) wasm_tabletype_t
+	wasm_tabletype_element func(wasm_tabletype_t) wasm_valtype_t
+
+	// Funcref support
+	wasmtime_func_to_raw   func(wasmtime_context_t, *wasmtime_func_t) uintptr
+	wasmtime_func_from_raw func(wasmtime_context_t, uintptr, *wasmtime_func_t)
+
+	// Externref support
+	wasmtime_externref_new  func(wasmtime_context_t, uintptr, uintptr, *wasmtime_externref_t) bool
+	wasmtime_externref_data func(wasmtime_context_t, *wasmtime_externref_t) uintptr
+	wasmtime_val_unroot     func(*wasmtime_val_t)
 
 	// Host function support
 	wasmtime_func_new                  func(wasmtime_context_t, wasm_functype_t, uintptr, uintptr, uintptr, *wasmtime_func_t)
@@ -96,18 +128,51 @@ type bindings struct {
 	wasm_valtype_vec_new_uninitialized func(*wasm_valtype_vec_t, uintptr)
 	wasm_valtype_vec_delete            func(*wasm_valtype_vec_t)
 
+	// Host global, memory and table support
+	wasm_globaltype_new          func(wasm_valtype_t, uint8) wasm_globaltype_t
+	wasm_globaltype_delete       func(wasm_globaltype_t)
+	wasm_globaltype_content      func(wasm_globaltype_t) wasm_valtype_t
+	wasm_globaltype_mutability   func(wasm_globaltype_t) uint8
+	wasmtime_global_new          func(wasmtime_context_t, wasm_globaltype_t, *wasmtime_val_t, *wasmtime_global_t) wasmtime_error_t
+	wasm_memorytype_new          func(*wasm_limits_t) wasm_memorytype_t
+	wasm_memorytype_delete       func(wasm_memorytype_t)
+	wasmtime_memorytype_new      func(uint64, bool, uint64, bool, bool) wasm_memorytype_t
+	wasmtime_memorytype_minimum  func(wasm_memorytype_t) uint64
+	wasmtime_memorytype_maximum  func(wasm_memorytype_t, *uint64) bool
+	wasmtime_memorytype_is64     func(wasm_memorytype_t) bool
+	wasmtime_memorytype_isshared func(wasm_memorytype_t) bool
+	wasmtime_memory_type         func(wasmtime_context_t, *wasmtime_memory_t) wasm_memorytype_t
+	wasmtime_memory_new          func(wasmtime_context_t, wasm_memorytype_t, *wasmtime_memory_t) wasmtime_error_t
+	wasm_tabletype_new           func(wasm_valtype_t, *wasm_limits_t) wasm_tabletype_t
+	wasm_tabletype_delete        func(wasm_tabletype_t)
+	wasmtime_table_new           func(wasmtime_context_t, wasm_tabletype_t, *wasmtime_val_t, *wasmtime_table_t) wasmtime_error_t
+
+	// Shared memory functions
+	wasmtime_sharedmemory_new       func(wasm_engine_t, wasm_memorytype_t, *wasmtime_sharedmemory_t) wasmtime_error_t
+	wasmtime_sharedmemory_delete    func(wasmtime_sharedmemory_t)
+	wasmtime_sharedmemory_clone     func(wasmtime_sharedmemory_t) wasmtime_sharedmemory_t
+	wasmtime_sharedmemory_type      func(wasmtime_sharedmemory_t) wasm_memorytype_t
+	wasmtime_sharedmemory_data      func(wasmtime_sharedmemory_t) unsafe.Pointer
+	wasmtime_sharedmemory_data_size func(wasmtime_sharedmemory_t) uintptr
+	wasmtime_sharedmemory_size      func(wasmtime_sharedmemory_t) uint64
+	wasmtime_sharedmemory_grow      func(wasmtime_sharedmemory_t, uint64, *uint64) wasmtime_error_t
+
 	// WASI bindings
-	wasi_config_new            func() wasi_config_t
-	wasi_config_delete         func(wasi_config_t)
-	wasi_config_inherit_argv   func(wasi_config_t)
-	wasi_config_inherit_env    func(wasi_config_t)
-	wasi_config_set_argv       func(wasi_config_t, int32, **byte)
-	wasi_config_set_env        func(wasi_config_t, int32, **byte, **byte)
-	wasi_config_preopen_dir    func(wasi_config_t, *byte, *byte) bool
-	wasi_config_inherit_stdin  func(wasi_config_t)
-	wasi_config_inherit_stdout func(wasi_config_t)
-	wasi_config_inherit_stderr func(wasi_config_t)
-	wasmtime_context_set_wasi  func(wasmtime_context_t, wasi_config_t) wasmtime_error_t
+	wasi_config_new             func() wasi_config_t
+	wasi_config_delete          func(wasi_config_t)
+	wasi_config_inherit_argv    func(wasi_config_t)
+	wasi_config_inherit_env     func(wasi_config_t)
+	wasi_config_set_argv        func(wasi_config_t, int32, **byte)
+	wasi_config_set_env         func(wasi_config_t, int32, **byte, **byte)
+	wasi_config_preopen_dir     func(wasi_config_t, *byte, *byte, uintptr, uintptr) bool
+	wasi_config_inherit_stdin   func(wasi_config_t)
+	wasi_config_inherit_stdout  func(wasi_config_t)
+	wasi_config_inherit_stderr  func(wasi_config_t)
+	wasi_config_set_stdin_file  func(wasi_config_t, *byte) bool
+	wasi_config_set_stdin_bytes func(wasi_config_t, *wasm_byte_vec_t)
+	wasi_config_set_stdout_file func(wasi_config_t, *byte) bool
+	wasi_config_set_stderr_file func(wasi_config_t, *byte) bool
+	wasmtime_context_set_wasi   func(wasmtime_context_t, wasi_config_t) wasmtime_error_t
 }
 
 // loadLibrary loads the wasmtime library with memoization and reference counting
@@ -160,12 +225,18 @@ func newBindings(libHandle uintptr) (*bindings, error) {
 
 	// Engine functions
 	purego.RegisterLibFunc(&b.wasm_engine_new, libHandle, "wasm_engine_new")
+	purego.RegisterLibFunc(&b.wasm_engine_new_with_config, libHandle, "wasm_engine_new_with_config")
 	purego.RegisterLibFunc(&b.wasm_engine_delete, libHandle, "wasm_engine_delete")
+	purego.RegisterLibFunc(&b.wasm_config_new, libHandle, "wasm_config_new")
+	purego.RegisterLibFunc(&b.wasmtime_config_wasm_memory64_set, libHandle, "wasmtime_config_wasm_memory64_set")
+	purego.RegisterLibFunc(&b.wasmtime_config_wasm_threads_set, libHandle, "wasmtime_config_wasm_threads_set")
 
 	// Store functions
 	purego.RegisterLibFunc(&b.wasmtime_store_new, libHandle, "wasmtime_store_new")
 	purego.RegisterLibFunc(&b.wasmtime_store_delete, libHandle, "wasmtime_store_delete")
 	purego.RegisterLibFunc(&b.wasmtime_store_context, libHandle, "wasmtime_store_context")
+	purego.RegisterLibFunc(&b.wasmtime_context_get_data, libHandle, "wasmtime_context_get_data")
+	purego.RegisterLibFunc(&b.wasmtime_context_set_data, libHandle, "wasmtime_context_set_data")
 
 	// WAT conversion
 	purego.RegisterLibFunc(&b.wasmtime_wat2wasm, libHandle, "wasmtime_wat2wasm")
@@ -177,9 +248,12 @@ func newBindings(libHandle uintptr) (*bindings, error) {
 	// Instance functions
 	purego.RegisterLibFunc(&b.wasmtime_instance_new, libHandle, "wasmtime_instance_new")
 	purego.RegisterLibFunc(&b.wasmtime_instance_export_get, libHandle, "wasmtime_instance_export_get")
+	purego.RegisterLibFunc(&b.wasmtime_instance_export_nth, libHandle, "wasmtime_instance_export_nth")
+	purego.RegisterLibFunc(&b.wasmtime_extern_delete, libHandle, "wasmtime_extern_delete")
 
 	// Function calling
 	purego.RegisterLibFunc(&b.wasmtime_func_call, libHandle, "wasmtime_func_call")
+	purego.RegisterLibFunc(&b.wasmtime_func_call_unchecked, libHandle, "wasmtime_func_call_unchecked")
 	purego.RegisterLibFunc(&b.wasmtime_func_type, libHandle, "wasmtime_func_type")
 
 	// Function type introspection
@@ -192,9 +266,17 @@ func newBindings(libHandle uintptr) (*bindings, error) {
 	purego.RegisterLibFunc(&b.wasmtime_error_message, libHandle, "wasmtime_error_message")
 	purego.RegisterLibFunc(&b.wasmtime_error_delete, libHandle, "wasmtime_error_delete")
 	purego.RegisterLibFunc(&b.wasmtime_error_exit_status, libHandle, "wasmtime_error_exit_status")
-	purego.RegisterLibFunc(&b.wasm_trap_new, libHandle, "wasm_trap_new")
+	purego.RegisterLibFunc(&b.wasmtime_trap_new, libHandle, "wasmtime_trap_new")
 	purego.RegisterLibFunc(&b.wasm_trap_message, libHandle, "wasm_trap_message")
 	purego.RegisterLibFunc(&b.wasm_trap_delete, libHandle, "wasm_trap_delete")
+	purego.RegisterLibFunc(&b.wasmtime_trap_code, libHandle, "wasmtime_trap_code")
+	purego.RegisterLibFunc(&b.wasm_trap_trace, libHandle, "wasm_trap_trace")
+	purego.RegisterLibFunc(&b.wasm_frame_vec_delete, libHandle, "wasm_frame_vec_delete")
+	purego.RegisterLibFunc(&b.wasm_frame_func_index, libHandle, "wasm_frame_func_index")
+	purego.RegisterLibFunc(&b.wasm_frame_func_offset, libHandle, "wasm_frame_func_offset")
+	purego.RegisterLibFunc(&b.wasm_frame_module_offset, libHandle, "wasm_frame_module_offset")
+	purego.RegisterLibFunc(&b.wasmtime_frame_func_name, libHandle, "wasmtime_frame_func_name")
+	purego.RegisterLibFunc(&b.wasmtime_frame_module_name, libHandle, "wasmtime_frame_module_name")
 
 	// Byte vectors
 	purego.RegisterLibFunc(&b.wasm_byte_vec_new_uninitialized, libHandle, "wasm_byte_vec_new_uninitialized")
@@ -206,6 +288,8 @@ func newBindings(libHandle uintptr) (*bindings, error) {
 	purego.RegisterLibFunc(&b.wasmtime_linker_define_wasi, libHandle, "wasmtime_linker_define_wasi")
 	purego.RegisterLibFunc(&b.wasmtime_linker_instantiate, libHandle, "wasmtime_linker_instantiate")
 	purego.RegisterLibFunc(&b.wasmtime_linker_define, libHandle, "wasmtime_linker_define")
+	purego.RegisterLibFunc(&b.wasmtime_linker_define_func, libHandle, "wasmtime_linker_define_func")
+	purego.RegisterLibFunc(&b.wasmtime_linker_allow_shadowing, libHandle, "wasmtime_linker_allow_shadowing")
 
 	// Memory functions
 	purego.RegisterLibFunc(&b.wasmtime_memory_data, libHandle, "wasmtime_memory_data")
@@ -216,16 +300,29 @@ func newBindings(libHandle uintptr) (*bindings, error) {
 	// Global functions
 	purego.RegisterLibFunc(&b.wasmtime_global_get, libHandle, "wasmtime_global_get")
 	purego.RegisterLibFunc(&b.wasmtime_global_set, libHandle, "wasmtime_global_set")
+	purego.RegisterLibFunc(&b.wasmtime_global_type, libHandle, "wasmtime_global_type")
 
 	// Table functions
 	purego.RegisterLibFunc(&b.wasmtime_table_size, libHandle, "wasmtime_table_size")
 	purego.RegisterLibFunc(&b.wasmtime_table_get, libHandle, "wasmtime_table_get")
 	purego.RegisterLibFunc(&b.wasmtime_table_set, libHandle, "wasmtime_table_set")
 	purego.RegisterLibFunc(&b.wasmtime_table_grow, libHandle, "wasmtime_table_grow")
+	purego.RegisterLibFunc(&b.wasmtime_table_type, libHandle, "wasmtime_table_type")
+	purego.RegisterLibFunc(&b.wasm_tabletype_element, libHandle, "wasm_tabletype_element")
+
+	// Funcref support
+	purego.RegisterLibFunc(&b.wasmtime_func_to_raw, libHandle, "wasmtime_func_to_raw")
+	purego.RegisterLibFunc(&b.wasmtime_func_from_raw, libHandle, "wasmtime_func_from_raw")
+
+	// Externref support
+	purego.RegisterLibFunc(&b.wasmtime_externref_new, libHandle, "wasmtime_externref_new")
+	purego.RegisterLibFunc(&b.wasmtime_externref_data, libHandle, "wasmtime_externref_data")
+	purego.RegisterLibFunc(&b.wasmtime_val_unroot, libHandle, "wasmtime_val_unroot")
 
 	// Host function support
 	purego.RegisterLibFunc(&b.wasmtime_func_new, libHandle, "wasmtime_func_new")
 	purego.RegisterLibFunc(&b.wasmtime_caller_export_get, libHandle, "wasmtime_caller_export_get")
+	purego.RegisterLibFunc(&b.wasmtime_caller_context, libHandle, "wasmtime_caller_context")
 	purego.RegisterLibFunc(&b.wasm_functype_new, libHandle, "wasm_functype_new")
 	purego.RegisterLibFunc(&b.wasm_functype_delete, libHandle, "wasm_functype_delete")
 	purego.RegisterLibFunc(&b.wasm_valtype_new, libHandle, "wasm_valtype_new")
@@ -234,6 +331,35 @@ func newBindings(libHandle uintptr) (*bindings, error) {
 	purego.RegisterLibFunc(&b.wasm_valtype_vec_new_uninitialized, libHandle, "wasm_valtype_vec_new_uninitialized")
 	purego.RegisterLibFunc(&b.wasm_valtype_vec_delete, libHandle, "wasm_valtype_vec_delete")
 
+	// Host global, memory and table support
+	purego.RegisterLibFunc(&b.wasm_globaltype_new, libHandle, "wasm_globaltype_new")
+	purego.RegisterLibFunc(&b.wasm_globaltype_delete, libHandle, "wasm_globaltype_delete")
+	purego.RegisterLibFunc(&b.wasm_globaltype_content, libHandle, "wasm_globaltype_content")
+	purego.RegisterLibFunc(&b.wasm_globaltype_mutability, libHandle, "wasm_globaltype_mutability")
+	purego.RegisterLibFunc(&b.wasmtime_global_new, libHandle, "wasmtime_global_new")
+	purego.RegisterLibFunc(&b.wasm_memorytype_new, libHandle, "wasm_memorytype_new")
+	purego.RegisterLibFunc(&b.wasm_memorytype_delete, libHandle, "wasm_memorytype_delete")
+	purego.RegisterLibFunc(&b.wasmtime_memorytype_new, libHandle, "wasmtime_memorytype_new")
+	purego.RegisterLibFunc(&b.wasmtime_memorytype_minimum, libHandle, "wasmtime_memorytype_minimum")
+	purego.RegisterLibFunc(&b.wasmtime_memorytype_maximum, libHandle, "wasmtime_memorytype_maximum")
+	purego.RegisterLibFunc(&b.wasmtime_memorytype_is64, libHandle, "wasmtime_memorytype_is64")
+	purego.RegisterLibFunc(&b.wasmtime_memorytype_isshared, libHandle, "wasmtime_memorytype_isshared")
+	purego.RegisterLibFunc(&b.wasmtime_memory_type, libHandle, "wasmtime_memory_type")
+	purego.RegisterLibFunc(&b.wasmtime_memory_new, libHandle, "wasmtime_memory_new")
+	purego.RegisterLibFunc(&b.wasm_tabletype_new, libHandle, "wasm_tabletype_new")
+	purego.RegisterLibFunc(&b.wasm_tabletype_delete, libHandle, "wasm_tabletype_delete")
+	purego.RegisterLibFunc(&b.wasmtime_table_new, libHandle, "wasmtime_table_new")
+
+	// Shared memory functions
+	purego.RegisterLibFunc(&b.wasmtime_sharedmemory_new, libHandle, "wasmtime_sharedmemory_new")
+	purego.RegisterLibFunc(&b.wasmtime_sharedmemory_delete, libHandle, "wasmtime_sharedmemory_delete")
+	purego.RegisterLibFunc(&b.wasmtime_sharedmemory_clone, libHandle, "wasmtime_sharedmemory_clone")
+	purego.RegisterLibFunc(&b.wasmtime_sharedmemory_type, libHandle, "wasmtime_sharedmemory_type")
+	purego.RegisterLibFunc(&b.wasmtime_sharedmemory_data, libHandle, "wasmtime_sharedmemory_data")
+	purego.RegisterLibFunc(&b.wasmtime_sharedmemory_data_size, libHandle, "wasmtime_sharedmemory_data_size")
+	purego.RegisterLibFunc(&b.wasmtime_sharedmemory_size, libHandle, "wasmtime_sharedmemory_size")
+	purego.RegisterLibFunc(&b.wasmtime_sharedmemory_grow, libHandle, "wasmtime_sharedmemory_grow")
+
 	// WASI bindings
 	purego.RegisterLibFunc(&b.wasi_config_new, libHandle, "wasi_config_new")
 	purego.RegisterLibFunc(&b.wasi_config_delete, libHandle, "wasi_config_delete")
@@ -245,13 +371,18 @@ func newBindings(libHandle uintptr) (*bindings, error) {
 	purego.RegisterLibFunc(&b.wasi_config_inherit_stdin, libHandle, "wasi_config_inherit_stdin")
 	purego.RegisterLibFunc(&b.wasi_config_inherit_stdout, libHandle, "wasi_config_inherit_stdout")
 	purego.RegisterLibFunc(&b.wasi_config_inherit_stderr, libHandle, "wasi_config_inherit_stderr")
+	purego.RegisterLibFunc(&b.wasi_config_set_stdin_file, libHandle, "wasi_config_set_stdin_file")
+	purego.RegisterLibFunc(&b.wasi_config_set_stdin_bytes, libHandle, "wasi_config_set_stdin_bytes")
+	purego.RegisterLibFunc(&b.wasi_config_set_stdout_file, libHandle, "wasi_config_set_stdout_file")
+	purego.RegisterLibFunc(&b.wasi_config_set_stderr_file, libHandle, "wasi_config_set_stderr_file")
 	purego.RegisterLibFunc(&b.wasmtime_context_set_wasi, libHandle, "wasmtime_context_set_wasi")
 
 	return b, nil
 }
 
 // getErrorMessage extracts error message from wasmtime_error_t or wasm_trap_t
-// Also detects WASI exits and returns WASIExitError for proper handling
+// Also detects WASI exits and returns WASIExitError for proper handling,
+// and returns a *TrapError for traps
 func (b *bindings) getErrorMessage(err wasmtime_error_t, trap wasm_trap_t) error {
 	if err == 0 && trap == 0 {
 		return fmt.Errorf("unknown error")
@@ -268,15 +399,15 @@ func (b *bindings) getErrorMessage(err wasmtime_error_t, trap wasm_trap_t) error
 		}
 	}
 
+	// Traps carry a code and a backtrace
+	if err == 0 {
+		return b.newTrapError(trap)
+	}
+
 	// Not a WASI exit, return regular error message
 	var msg wasm_byte_vec_t
-	if err != 0 {
-		b.wasmtime_error_message(err, &msg)
-		b.wasmtime_error_delete(err)
-	} else if trap != 0 {
-		b.wasm_trap_message(trap, &msg)
-		b.wasm_trap_delete(trap)
-	}
+	b.wasmtime_error_message(err, &msg)
+	b.wasmtime_error_delete(err)
 
 	result := string(msg.toGoBytes())
 	b.wasm_byte_vec_delete(&msg)
@@ -0,0 +1,147 @@
+package wasmtime
+
+import (
+	"math"
+	"testing"
+
+	"github.com/rvigee/purego-wasmtime/api"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCallWithStack tests calls through a caller-owned stack on both the
+// checked and unchecked paths
+func TestCallWithStack(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntime(ctx)
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	wat := `
+		(module
+			(func (export "swap") (param i32 i64 f32 f64) (result f64 f32 i64 i32)
+				local.get 3
+				local.get 2
+				local.get 1
+				local.get 0
+			)
+			(func (export "neg") (param i32) (result i32)
+				i32.const 0
+				local.get 0
+				i32.sub
+			)
+			(func (export "seven") (result i32 i32 i32)
+				i32.const 7
+				i32.const 7
+				i32.const 7
+			)
+			(func (export "div") (param i32 i32) (result i32)
+				local.get 0
+				local.get 1
+				i32.div_s
+			)
+		)
+	`
+
+	compiled, err := r.CompileModule(ctx, []byte(wat))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.Instantiate(ctx, compiled)
+	require.NoError(t, err)
+	defer mod.Close(ctx)
+
+	for _, unchecked := range []bool{true, false} {
+		name := "checked"
+		if unchecked {
+			name = "unchecked"
+		}
+		lookup := func(t *testing.T, export string) *function {
+			fn := mod.ExportedFunction(export).(*function)
+			require.True(t, fn.unchecked, "numeric signatures use the unchecked path")
+			fn.unchecked = unchecked
+			return fn
+		}
+
+		t.Run(name, func(t *testing.T) {
+			swap := lookup(t, "swap")
+			stack := []uint64{EncodeI32(-3), EncodeI64(math.MaxInt64), EncodeF32(1.5), EncodeF64(-2.25)}
+			require.NoError(t, swap.CallWithStack(ctx, stack))
+			require.Equal(t, -2.25, DecodeF64(stack[0]))
+			require.Equal(t, float32(1.5), DecodeF32(stack[1]))
+			require.Equal(t, int64(math.MaxInt64), DecodeI64(stack[2]))
+			require.Equal(t, int32(-3), DecodeI32(stack[3]))
+
+			// i32 results are zero-extended like EncodeI32
+			neg := lookup(t, "neg")
+			stack = []uint64{EncodeI32(5)}
+			require.NoError(t, neg.CallWithStack(ctx, stack))
+			require.Equal(t, EncodeI32(-5), stack[0])
+
+			// The stack must fit the results even without params
+			seven := lookup(t, "seven")
+			require.Error(t, seven.CallWithStack(ctx, make([]uint64, 2)))
+			stack = make([]uint64, 3)
+			require.NoError(t, seven.CallWithStack(ctx, stack))
+			require.Equal(t, []uint64{7, 7, 7}, stack)
+
+			div := lookup(t, "div")
+			require.Error(t, div.CallWithStack(ctx, []uint64{EncodeI32(1), 0}))
+			stack = []uint64{EncodeI32(9), EncodeI32(3)}
+			require.NoError(t, div.CallWithStack(ctx, stack))
+			require.Equal(t, int32(3), DecodeI32(stack[0]))
+		})
+	}
+}
+
+func TestIsRawSignature(t *testing.T) {
+	require.True(t, isRawSignature())
+	require.True(t, isRawSignature(
+		[]api.ValueType{api.ValueTypeI32, api.ValueTypeI64},
+		[]api.ValueType{api.ValueTypeF32, api.ValueTypeF64},
+	))
+	require.False(t, isRawSignature([]api.ValueType{api.ValueTypeI32}, []api.ValueType{api.ValueTypeExternref}))
+	require.True(t, isRawSignature([]api.ValueType{api.ValueTypeV128}))
+	require.True(t, isRawSignature(nil, []api.ValueType{api.ValueTypeFuncref}))
+}
+
+// BenchmarkCallWithStack compares wasmtime_func_call with
+// wasmtime_func_call_unchecked for the same function. Numeric signatures
+// always take the unchecked path, so the checked one is forced here.
+func BenchmarkCallWithStack(b *testing.B) {
+	ctx := b.Context()
+	r, err := NewRuntime(ctx)
+	require.NoError(b, err)
+	defer r.Close(ctx)
+
+	compiled, err := r.CompileModule(ctx, []byte(`(module
+		(func (export "add") (param i32 i32) (result i32)
+			local.get 0
+			local.get 1
+			i32.add))`))
+	require.NoError(b, err)
+	defer compiled.Close()
+
+	mod, err := r.Instantiate(ctx, compiled)
+	require.NoError(b, err)
+	defer mod.Close(ctx)
+
+	add := mod.ExportedFunction("add").(*function)
+	require.True(b, add.unchecked)
+	for _, unchecked := range []bool{false, true} {
+		name := "wasmtime_func_call"
+		if unchecked {
+			name = "wasmtime_func_call_unchecked"
+		}
+		b.Run(name, func(b *testing.B) {
+			add.unchecked = unchecked
+			b.ReportAllocs()
+			stack := make([]uint64, 2)
+			for b.Loop() {
+				stack[0], stack[1] = 1, 2
+				if err := add.CallWithStack(ctx, stack); err != nil || stack[0] != 3 {
+					b.Fatalf("add(1, 2) = %v, %v", stack[0], err)
+				}
+			}
+		})
+	}
+}
@@ -12,14 +12,31 @@ type callerModule struct {
 	caller   uintptr
 	store    wasmtime_context_t
 	bindings *bindings
+	runtime  *wasmRuntime
 }
 
 func (cm *callerModule) Name() string {
 	return "caller"
 }
 
+// ExportedFunction returns a function exported by the calling module.
+// The function runs in the caller's store, so it can be called from within
+// the host function to re-enter the guest, for example to allocate memory
+// before writing a result. It remains valid for as long as that store lives.
 func (cm *callerModule) ExportedFunction(name string) api.Function {
-	return nil
+	nameBytes := []byte(name + "\x00")
+	var ext wasmtime_extern_t
+
+	found := cm.bindings.wasmtime_caller_export_get(cm.caller, &nameBytes[0], uintptr(len(name)), &ext)
+	if !found {
+		return nil
+	}
+	if ext.kind != WASMTIME_EXTERN_FUNC {
+		cm.bindings.wasmtime_extern_delete(&ext)
+		return nil
+	}
+
+	return newFunction(name, *ext.AsFunc(), 0, cm.store, cm.bindings.stateFromContext(cm.store), cm.bindings)
 }
 
 func (cm *callerModule) ExportedFunctionDefinitions() map[string]api.FunctionDefinition {
@@ -31,16 +48,19 @@ func (cm *callerModule) ExportedMemory(name string) api.Memory {
 	var ext wasmtime_extern_t
 
 	found := cm.bindings.wasmtime_caller_export_get(cm.caller, &nameBytes[0], uintptr(len(name)), &ext)
-	if !found || ext.kind != WASMTIME_EXTERN_MEMORY {
+	if !found {
 		return nil
 	}
 
-	mem := ext.AsMemory()
-	return &memory{
-		val:      *mem,
-		store:    0,
-		storeCtx: cm.store,
-		bindings: cm.bindings,
+	switch ext.kind {
+	case WASMTIME_EXTERN_MEMORY:
+		return newMemory(*ext.AsMemory(), 0, cm.store, cm.bindings)
+	case WASMTIME_EXTERN_SHAREDMEMORY:
+		// The shared memory takes ownership of the handle
+		return newSharedMemory(*ext.AsSharedMemory(), cm.runtime, cm.bindings)
+	default:
+		cm.bindings.wasmtime_extern_delete(&ext)
+		return nil
 	}
 }
 
@@ -49,17 +69,15 @@ func (cm *callerModule) ExportedGlobal(name string) api.Global {
 	var ext wasmtime_extern_t
 
 	found := cm.bindings.wasmtime_caller_export_get(cm.caller, &nameBytes[0], uintptr(len(name)), &ext)
-	if !found || ext.kind != WASMTIME_EXTERN_GLOBAL {
+	if !found {
 		return nil
 	}
-
-	glob := ext.AsGlobal()
-	return &global{
-		val:      *glob,
-		store:    0,
-		storeCtx: cm.store,
-		bindings: cm.bindings,
+	if ext.kind != WASMTIME_EXTERN_GLOBAL {
+		cm.bindings.wasmtime_extern_delete(&ext)
+		return nil
 	}
+
+	return newGlobal(*ext.AsGlobal(), 0, cm.store, cm.bindings)
 }
 
 func (cm *callerModule) ExportedTable(name string) api.Table {
@@ -67,17 +85,15 @@ func (cm *callerModule) ExportedTable(name string) api.Table {
 	var ext wasmtime_extern_t
 
 	found := cm.bindings.wasmtime_caller_export_get(cm.caller, &nameBytes[0], uintptr(len(name)), &ext)
-	if !found || ext.kind != WASMTIME_EXTERN_TABLE {
+	if !found {
 		return nil
 	}
-
-	tbl := ext.AsTable()
-	return &table{
-		val:      *tbl,
-		store:    0,
-		storeCtx: cm.store,
-		bindings: cm.bindings,
+	if ext.kind != WASMTIME_EXTERN_TABLE {
+		cm.bindings.wasmtime_extern_delete(&ext)
+		return nil
 	}
+
+	return newTable(*ext.AsTable(), 0, cm.store, cm.bindings)
 }
 
 func (cm *callerModule) Close(ctx context.Context) error {
@@ -0,0 +1,77 @@
+package wasmtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rvigee/purego-wasmtime/api"
+)
+
+// WASI distinguishes two kinds of modules: commands export _start, which runs
+// the program to completion, while reactors export _initialize, which must be
+// called once before any other export is used.
+const (
+	commandStartFunction = "_start"
+	reactorInitFunction  = "_initialize"
+)
+
+// isReactor reports whether mod follows the WASI reactor model.
+func isReactor(mod api.Module) bool {
+	return mod.ExportedFunction(reactorInitFunction) != nil && mod.ExportedFunction(commandStartFunction) == nil
+}
+
+// initializeReactor calls the _initialize export of reactor modules.
+func initializeReactor(ctx context.Context, mod api.Module) error {
+	if !isReactor(mod) {
+		return nil
+	}
+	if _, err := mod.ExportedFunction(reactorInitFunction).Call(ctx); err != nil {
+		return fmt.Errorf("failed to initialize reactor: %w", err)
+	}
+	return nil
+}
+
+// startFunctionsFor returns the start functions to run for mod: the
+// configured ones, or else _initialize for reactors and _start otherwise.
+func (mc *moduleConfig) startFunctionsFor(mod api.Module) []string {
+	if len(mc.startFunctions) > 0 {
+		return mc.startFunctions
+	}
+	if isReactor(mod) {
+		return []string{reactorInitFunction}
+	}
+	return []string{commandStartFunction}
+}
+
+func (r *wasmRuntime) RunCommand(ctx context.Context, compiled CompiledModule, config ModuleConfig) (int, error) {
+	mod, mc, err := r.instantiateModule(ctx, compiled, config)
+	if err != nil {
+		return -1, err
+	}
+	defer mod.Close(ctx)
+
+	startFunctions := mc.startFunctions
+	if len(startFunctions) == 0 {
+		startFunctions = []string{commandStartFunction}
+	}
+
+	// Unlike InstantiateModule, a command must export what it is asked to run
+	for _, name := range startFunctions {
+		fn := mod.ExportedFunction(name)
+		if fn == nil {
+			return -1, fmt.Errorf("module %q does not export start function %s", mc.name, name)
+		}
+		// exit(0) ends the program too, so it must not be taken for a
+		// normal return before the next start function
+		if _, err := fn.(*function).call(ctx); err != nil {
+			var exitErr *WASIExitError
+			if errors.As(err, &exitErr) {
+				return int(exitErr.ExitCode), nil
+			}
+			return -1, fmt.Errorf("module %q: start function %s failed: %w", mc.name, name, err)
+		}
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,127 @@
+package wasmtime
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCommand(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntime(ctx)
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	// _start exits with argc-1, except that it returns normally instead of
+	// exiting with 1, and "crash" traps.
+	wat := `
+	(module
+		(import "wasi_snapshot_preview1" "args_sizes_get"
+			(func $args_sizes_get (param i32 i32) (result i32)))
+		(import "wasi_snapshot_preview1" "proc_exit"
+			(func $proc_exit (param i32)))
+		(memory (export "memory") 1)
+		(func (export "_start")
+			(local $status i32)
+			(drop (call $args_sizes_get (i32.const 0) (i32.const 4)))
+			(local.set $status (i32.sub (i32.load (i32.const 0)) (i32.const 1)))
+			(if (i32.ne (local.get $status) (i32.const 1))
+				(then (call $proc_exit (local.get $status))))
+		)
+		(func (export "crash") unreachable)
+	)`
+
+	compiled, err := r.CompileModule(ctx, []byte(wat))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	t.Run("exit_codes", func(t *testing.T) {
+		code, err := r.RunCommand(ctx, compiled, NewModuleConfig().WithArgs("prog"))
+		require.NoError(t, err)
+		assert.Equal(t, 0, code)
+
+		code, err = r.RunCommand(ctx, compiled, NewModuleConfig().WithArgs("prog", "a"))
+		require.NoError(t, err)
+		assert.Equal(t, 0, code, "returning from _start exits with 0")
+
+		code, err = r.RunCommand(ctx, compiled, NewModuleConfig().WithArgs("prog", "a", "b", "c"))
+		require.NoError(t, err)
+		assert.Equal(t, 3, code)
+	})
+
+	t.Run("trap", func(t *testing.T) {
+		code, err := r.RunCommand(ctx, compiled, NewModuleConfig().WithStartFunctions("crash"))
+		var trapErr *TrapError
+		require.ErrorAs(t, err, &trapErr)
+		assert.Equal(t, TrapCodeUnreachable, trapErr.Code)
+		assert.Equal(t, -1, code)
+	})
+
+	t.Run("exit_ends_the_program", func(t *testing.T) {
+		// _start exits with 0, so crash must not run afterwards
+		config := NewModuleConfig().WithArgs("prog").WithStartFunctions("_start", "crash")
+		code, err := r.RunCommand(ctx, compiled, config)
+		require.NoError(t, err)
+		assert.Equal(t, 0, code)
+
+		mod, err := r.InstantiateModule(ctx, compiled, config)
+		require.NoError(t, err)
+		require.NoError(t, mod.Close(ctx))
+	})
+
+	t.Run("missing_start_function", func(t *testing.T) {
+		code, err := r.RunCommand(ctx, compiled, NewModuleConfig().WithStartFunctions("main"))
+		assert.Error(t, err)
+		assert.Equal(t, -1, code)
+	})
+}
+
+func TestReactorInitialize(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntime(ctx)
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	reactor := `
+	(module
+		(global $ready (export "ready") (mut i32) (i32.const 0))
+		(func (export "_initialize")
+			(global.set $ready (i32.add (global.get $ready) (i32.const 1))))
+	)`
+	compiled, err := r.CompileModule(ctx, []byte(reactor))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	t.Run("instantiate", func(t *testing.T) {
+		mod, err := r.Instantiate(ctx, compiled)
+		require.NoError(t, err)
+		defer mod.Close(ctx)
+		assert.Equal(t, uint64(1), mod.ExportedGlobal("ready").Get(ctx))
+	})
+
+	t.Run("instantiate_module", func(t *testing.T) {
+		mod, err := r.InstantiateModule(ctx, compiled, NewModuleConfig())
+		require.NoError(t, err)
+		defer mod.Close(ctx)
+		assert.Equal(t, uint64(1), mod.ExportedGlobal("ready").Get(ctx))
+	})
+
+	t.Run("command_is_not_initialized", func(t *testing.T) {
+		command := `
+		(module
+			(global $ready (export "ready") (mut i32) (i32.const 0))
+			(func (export "_initialize")
+				(global.set $ready (i32.const 1)))
+			(func (export "_start"))
+		)`
+		compiled, err := r.CompileModule(ctx, []byte(command))
+		require.NoError(t, err)
+		defer compiled.Close()
+
+		mod, err := r.Instantiate(ctx, compiled)
+		require.NoError(t, err)
+		defer mod.Close(ctx)
+		assert.Equal(t, uint64(0), mod.ExportedGlobal("ready").Get(ctx))
+	})
+}
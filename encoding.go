@@ -1,6 +1,7 @@
 package wasmtime
 
 import (
+	"encoding/binary"
 	"math"
 )
 
@@ -62,16 +63,33 @@ func DecodeF64(v uint64) float64 {
 	return math.Float64frombits(v)
 }
 
-// EncodeExternref encodes a uintptr (pointer) value as an externref for passing to WebAssembly.
+// EncodeV128 encodes a 128-bit vector, in its little-endian byte order, as
+// the two uint64 stack slots it occupies, low half first.
+func EncodeV128(v [16]byte) (lo, hi uint64) {
+	return binary.LittleEndian.Uint64(v[:8]), binary.LittleEndian.Uint64(v[8:])
+}
+
+// DecodeV128 decodes the two uint64 stack slots of a v128 value.
+func DecodeV128(lo, hi uint64) [16]byte {
+	var v [16]byte
+	binary.LittleEndian.PutUint64(v[:8], lo)
+	binary.LittleEndian.PutUint64(v[8:], hi)
+	return v
+}
+
+// EncodeExternref encodes a uintptr value as an externref for passing to WebAssembly.
 // This matches wazero's api.EncodeExternref function.
-// Externrefs are opaque host references that can be passed between the host and WebAssembly.
+// The value must be a handle returned by NewExternRef; other values are
+// passed to the guest as the null externref. Use NewExternRef to give guests
+// a Go value, rather than encoding its address.
 func EncodeExternref(v uintptr) uint64 {
 	return uint64(v)
 }
 
 // DecodeExternref decodes a uint64 value from WebAssembly to uintptr (externref).
 // This matches wazero's api.DecodeExternref function.
-// The returned value is an opaque host reference.
+// The returned value is an opaque handle; ExternRefValue returns the Go value
+// behind it.
 func DecodeExternref(v uint64) uintptr {
 	return uintptr(v)
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log"
@@ -90,4 +91,12 @@ func main() {
 	}
 
 	fmt.Println("\n✓ WASI program executed successfully!")
+
+	// Run the program again in a store of its own, capturing its output
+	var stdout bytes.Buffer
+	exitCode, err := r.RunCommand(ctx, compiled, wasmtime.NewModuleConfig().WithStdout(&stdout))
+	if err != nil {
+		log.Fatalf("Failed to run command: %v", err)
+	}
+	fmt.Printf("✓ Captured output %q (exit code %d)\n", stdout.String(), exitCode)
 }
@@ -0,0 +1,135 @@
+package wasmtime
+
+import (
+	"sync"
+
+	"github.com/ebitengine/purego"
+)
+
+// Externref values are passed through the uint64 ABI as handles into a
+// process-wide table of Go values, with 0 meaning null. Guests only ever see
+// opaque wasmtime externrefs wrapping a handle, never a Go pointer.
+//
+// Each handle is reference counted: NewExternRef takes one reference for the
+// Go caller, released by ReleaseExternRef, and every externref handed to
+// wasmtime takes another, released by wasmtime's finalizer once the guest
+// drops it and it is collected. The Go value is freed when both are gone.
+
+// NewExternRef returns a handle to v, to be passed to guests as an externref
+// value. v stays reachable until ReleaseExternRef is called and no guest
+// holds a reference to it any more.
+func NewExternRef(v any) uint64 {
+	return globalExternRefs.add(v)
+}
+
+// ExternRefValue returns the Go value behind an externref handle, such as one
+// received from a guest, or nil for the null externref or a freed handle.
+func ExternRefValue(ref uint64) any {
+	return globalExternRefs.value(ref)
+}
+
+// ReleaseExternRef releases the reference taken by NewExternRef. Guests
+// still holding the externref keep the value alive until they drop it.
+func ReleaseExternRef(ref uint64) {
+	globalExternRefs.release(ref)
+}
+
+// externRefTable holds the Go values behind externref handles.
+type externRefTable struct {
+	mu      sync.Mutex
+	entries map[uint64]*externRefEntry
+	nextID  uint64
+}
+
+type externRefEntry struct {
+	value any
+	refs  int
+}
+
+var globalExternRefs = &externRefTable{
+	entries: make(map[uint64]*externRefEntry),
+	nextID:  1,
+}
+
+// externRefFinalizer is the finalizer given to wasmtime_externref_new, whose
+// data is the handle. Like hostCallbackTrampoline, it is created once since
+// purego callbacks are never freed.
+var externRefFinalizer = sync.OnceValue(func() uintptr {
+	return purego.NewCallback(func(data uintptr) {
+		globalExternRefs.release(uint64(data))
+	})
+})
+
+func (t *externRefTable) add(v any) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ref := t.nextID
+	t.nextID++
+	t.entries[ref] = &externRefEntry{value: v, refs: 1}
+	return ref
+}
+
+func (t *externRefTable) value(ref uint64) any {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.entries[ref]; ok {
+		return e.value
+	}
+	return nil
+}
+
+// retain takes a reference to ref, reporting whether it is still live.
+func (t *externRefTable) retain(ref uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[ref]
+	if ok {
+		e.refs++
+	}
+	return ok
+}
+
+func (t *externRefTable) release(ref uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.entries[ref]; ok {
+		e.refs--
+		if e.refs <= 0 {
+			delete(t.entries, ref)
+		}
+	}
+}
+
+// encodeExternref returns the handle wrapped by a wasmtime externref.
+// A zero store id marks the null externref.
+func encodeExternref(b *bindings, storeCtx wasmtime_context_t, val *wasmtime_externref_t) uint64 {
+	if val.store_id == 0 {
+		return 0
+	}
+	return uint64(b.wasmtime_externref_data(storeCtx, val))
+}
+
+// decodeExternref creates a rooted wasmtime externref wrapping ref, owned by
+// the caller. Null and freed handles become the null externref.
+func decodeExternref(b *bindings, storeCtx wasmtime_context_t, ref uint64, val *wasmtime_externref_t) {
+	*val = wasmtime_externref_t{}
+	if ref == 0 || !globalExternRefs.retain(ref) {
+		return
+	}
+	if !b.wasmtime_externref_new(storeCtx, uintptr(ref), externRefFinalizer(), val) {
+		*val = wasmtime_externref_t{}
+		globalExternRefs.release(ref)
+	}
+}
+
+// unrootValue releases the root held by a wasmtime_val_t we own. Only
+// externrefs are rooted among the value types we support.
+func unrootValue(b *bindings, val *wasmtime_val_t) {
+	if val.kind == WASM_EXTERNREF && val.AsExternRef().store_id != 0 {
+		b.wasmtime_val_unroot(val)
+	}
+}
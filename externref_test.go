@@ -0,0 +1,137 @@
+package wasmtime
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rvigee/purego-wasmtime/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExternRefTable(t *testing.T) {
+	type payload struct{ name string }
+	p := &payload{name: "go value"}
+
+	ref := NewExternRef(p)
+	require.NotZero(t, ref)
+	require.Same(t, p, ExternRefValue(ref))
+	require.NotEqual(t, ref, NewExternRef(p), "each call gets its own handle")
+
+	// A guest reference keeps the value alive past the Go release
+	require.True(t, globalExternRefs.retain(ref))
+	ReleaseExternRef(ref)
+	require.Same(t, p, ExternRefValue(ref))
+
+	globalExternRefs.release(ref)
+	require.Nil(t, ExternRefValue(ref))
+	require.False(t, globalExternRefs.retain(ref), "freed handles cannot be revived")
+
+	require.Nil(t, ExternRefValue(0))
+	ReleaseExternRef(0)
+}
+
+// TestExternRef tests that Go values round-trip through guests as externrefs
+func TestExternRef(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntime(ctx)
+	require.NoError(t, err)
+
+	type payload struct{ n int }
+
+	// Host function receiving and returning externrefs
+	host := r.NewHostModuleBuilder("env")
+	host.NewFunctionBuilder("bump",
+		[]api.ValueType{api.ValueTypeExternref},
+		[]api.ValueType{api.ValueTypeExternref},
+	).WithGoFunc(func(ctx context.Context, stack []uint64) {
+		p := ExternRefValue(stack[0]).(*payload)
+		p.n++
+		stack[1] = stack[0]
+	}).Export("bump")
+	require.NoError(t, host.Instantiate(ctx))
+	defer host.Close(ctx)
+
+	wat := `
+		(module
+			(import "env" "bump" (func $bump (param externref) (result externref)))
+			(global $held (export "held") (mut externref) (ref.null extern))
+			(table (export "refs") 2 externref)
+			(func (export "id") (param externref) (result externref)
+				local.get 0
+			)
+			(func (export "bump_twice") (param externref) (result externref)
+				local.get 0
+				call $bump
+				call $bump
+			)
+			(func (export "hold") (param externref)
+				local.get 0
+				global.set $held
+			)
+			(func (export "is_null") (param externref) (result i32)
+				local.get 0
+				ref.is_null
+			)
+		)
+	`
+
+	compiled, err := r.CompileModule(ctx, []byte(wat))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.Instantiate(ctx, compiled)
+	require.NoError(t, err)
+	defer mod.Close(ctx)
+
+	p := &payload{}
+	ref := NewExternRef(p)
+
+	t.Run("calls", func(t *testing.T) {
+		results, err := mod.ExportedFunction("id").Call(ctx, ref)
+		require.NoError(t, err)
+		require.Same(t, p, ExternRefValue(results[0]))
+
+		results, err = mod.ExportedFunction("bump_twice").Call(ctx, ref)
+		require.NoError(t, err)
+		require.Same(t, p, ExternRefValue(results[0]))
+		require.Equal(t, 2, p.n)
+
+		isNull := mod.ExportedFunction("is_null")
+		results, err = isNull.Call(ctx, 0)
+		require.NoError(t, err)
+		require.Equal(t, int32(1), DecodeI32(results[0]))
+		results, err = isNull.Call(ctx, ref)
+		require.NoError(t, err)
+		require.Equal(t, int32(0), DecodeI32(results[0]))
+	})
+
+	t.Run("globals and tables", func(t *testing.T) {
+		held := mod.ExportedGlobal("held")
+		require.Equal(t, api.ValueTypeExternref, held.Type())
+		require.Zero(t, held.Get(ctx))
+		require.NoError(t, held.Set(ctx, ref))
+		require.Same(t, p, ExternRefValue(held.Get(ctx)))
+
+		refs := mod.ExportedTable("refs")
+		require.Equal(t, api.ValueTypeExternref, refs.Type(ctx))
+		require.NoError(t, refs.Set(ctx, 1, ref))
+		require.Same(t, p, ExternRefValue(refs.Get(ctx, 1)))
+		require.Zero(t, refs.Get(ctx, 0))
+	})
+
+	t.Run("guest keeps value alive", func(t *testing.T) {
+		other := &payload{n: 100}
+		otherRef := NewExternRef(other)
+		_, err := mod.ExportedFunction("hold").Call(ctx, otherRef)
+		require.NoError(t, err)
+
+		ReleaseExternRef(otherRef)
+		require.Same(t, other, ExternRefValue(mod.ExportedGlobal("held").Get(ctx)))
+	})
+
+	ReleaseExternRef(ref)
+
+	// Dropping the store releases the references it held
+	require.NoError(t, r.Close(ctx))
+	require.Nil(t, ExternRefValue(ref))
+}
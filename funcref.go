@@ -0,0 +1,67 @@
+package wasmtime
+
+import (
+	"github.com/rvigee/purego-wasmtime/api"
+)
+
+// Funcref values are passed through the uint64 ABI as wasmtime's raw funcref
+// pointer, with 0 meaning null. Raw funcrefs are only meaningful within the
+// store they were obtained from.
+
+// EncodeFuncref encodes fn as a funcref value, to be passed as a funcref
+// param, returned from a host function or stored in a table. fn may come from
+// any export or from a funcref received from the guest, but must belong to the
+// store it is passed into. A nil fn encodes the null funcref.
+func EncodeFuncref(fn api.Function) uint64 {
+	f, ok := fn.(*function)
+	if !ok || f == nil {
+		return 0
+	}
+	return encodeFuncref(f.bindings, f.storeCtx, &f.val)
+}
+
+// DecodeFuncref returns the function a funcref value refers to, callable
+// through mod, or nil for the null funcref. mod must be the module the value
+// was obtained from, or another module sharing its store, such as the
+// api.Module given to a host function.
+func DecodeFuncref(mod api.Module, ref uint64) api.Function {
+	if ref == 0 {
+		return nil
+	}
+
+	var store wasmtime_store_t
+	var storeCtx wasmtime_context_t
+	var state *storeState
+	var b *bindings
+	switch m := mod.(type) {
+	case *module:
+		store, b, state = m.store, m.bindings, m.storeState
+		storeCtx = b.wasmtime_store_context(store)
+	case *callerModule:
+		storeCtx, b = m.store, m.bindings
+		state = b.stateFromContext(storeCtx)
+	default:
+		return nil
+	}
+
+	var val wasmtime_func_t
+	b.wasmtime_func_from_raw(storeCtx, uintptr(ref), &val)
+	return newFunction("", val, store, storeCtx, state, b)
+}
+
+// encodeFuncref converts a funcref to its raw form. A zero store id marks the
+// null funcref.
+func encodeFuncref(b *bindings, storeCtx wasmtime_context_t, val *wasmtime_func_t) uint64 {
+	if val.store_id == 0 {
+		return 0
+	}
+	return uint64(b.wasmtime_func_to_raw(storeCtx, val))
+}
+
+// decodeFuncref is the inverse of encodeFuncref.
+func decodeFuncref(b *bindings, storeCtx wasmtime_context_t, ref uint64, val *wasmtime_func_t) {
+	*val = wasmtime_func_t{}
+	if ref != 0 {
+		b.wasmtime_func_from_raw(storeCtx, uintptr(ref), val)
+	}
+}
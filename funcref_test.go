@@ -0,0 +1,160 @@
+package wasmtime
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rvigee/purego-wasmtime/api"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFuncref tests that funcrefs round-trip between Go and the guest as
+// callable functions
+func TestFuncref(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntime(ctx)
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	// The guest registers a callback, which Go calls back into
+	var callback uint64
+	var callbackResult int32
+	host := r.NewHostModuleBuilder("env")
+	host.NewFunctionBuilder("register",
+		[]api.ValueType{api.ValueTypeFuncref},
+		[]api.ValueType{},
+	).WithGoModuleFunc(func(ctx context.Context, mod api.Module, stack []uint64) {
+		callback = stack[0]
+		results, err := DecodeFuncref(mod, callback).Call(ctx, EncodeI32(4))
+		require.NoError(t, err)
+		callbackResult = DecodeI32(results[0])
+	}).Export("register")
+	host.NewFunctionBuilder("pick",
+		[]api.ValueType{},
+		[]api.ValueType{api.ValueTypeFuncref},
+	).WithGoModuleFunc(func(ctx context.Context, mod api.Module, stack []uint64) {
+		stack[0] = EncodeFuncref(mod.ExportedFunction("triple"))
+	}).Export("pick")
+	require.NoError(t, host.Instantiate(ctx))
+	defer host.Close(ctx)
+
+	wat := `
+		(module
+			(import "env" "register" (func $register (param funcref)))
+			(import "env" "pick" (func $pick (result funcref)))
+			(type $i2i (func (param i32) (result i32)))
+			(table $t (export "tbl") 4 funcref)
+			(elem declare func $double)
+			(func $double (export "double") (param i32) (result i32)
+				local.get 0
+				i32.const 2
+				i32.mul
+			)
+			(func (export "triple") (param i32) (result i32)
+				local.get 0
+				i32.const 3
+				i32.mul
+			)
+			(func (export "get_double") (result funcref)
+				ref.func $double
+			)
+			(func (export "apply") (param funcref i32) (result i32)
+				i32.const 0
+				local.get 0
+				table.set $t
+				local.get 1
+				i32.const 0
+				call_indirect $t (type $i2i)
+			)
+			(func (export "is_null") (param funcref) (result i32)
+				local.get 0
+				ref.is_null
+			)
+			(func (export "register_double")
+				ref.func $double
+				call $register
+			)
+			(func (export "apply_picked") (param i32) (result i32)
+				i32.const 1
+				call $pick
+				table.set $t
+				local.get 0
+				i32.const 1
+				call_indirect $t (type $i2i)
+			)
+		)
+	`
+
+	compiled, err := r.CompileModule(ctx, []byte(wat))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.Instantiate(ctx, compiled)
+	require.NoError(t, err)
+	defer mod.Close(ctx)
+
+	t.Run("results", func(t *testing.T) {
+		results, err := mod.ExportedFunction("get_double").Call(ctx)
+		require.NoError(t, err)
+		require.NotZero(t, results[0])
+
+		double := DecodeFuncref(mod, results[0])
+		require.NotNil(t, double)
+		results, err = double.Call(ctx, EncodeI32(21))
+		require.NoError(t, err)
+		require.Equal(t, int32(42), DecodeI32(results[0]))
+	})
+
+	t.Run("params", func(t *testing.T) {
+		apply := mod.ExportedFunction("apply").(*function)
+		triple := EncodeFuncref(mod.ExportedFunction("triple"))
+		for _, unchecked := range []bool{true, false} {
+			apply.unchecked = unchecked
+			results, err := apply.Call(ctx, triple, EncodeI32(5))
+			require.NoError(t, err)
+			require.Equal(t, int32(15), DecodeI32(results[0]))
+		}
+
+		isNull := mod.ExportedFunction("is_null")
+		results, err := isNull.Call(ctx, 0)
+		require.NoError(t, err)
+		require.Equal(t, int32(1), DecodeI32(results[0]))
+		results, err = isNull.Call(ctx, triple)
+		require.NoError(t, err)
+		require.Equal(t, int32(0), DecodeI32(results[0]))
+
+		require.Nil(t, DecodeFuncref(mod, 0))
+		require.Zero(t, EncodeFuncref(nil))
+	})
+
+	t.Run("host functions", func(t *testing.T) {
+		_, err := mod.ExportedFunction("register_double").Call(ctx)
+		require.NoError(t, err)
+		require.NotZero(t, callback)
+		require.Equal(t, int32(8), callbackResult)
+
+		// The registered callback stays callable after the guest returns
+		results, err := DecodeFuncref(mod, callback).Call(ctx, EncodeI32(7))
+		require.NoError(t, err)
+		require.Equal(t, int32(14), DecodeI32(results[0]))
+
+		results, err = mod.ExportedFunction("apply_picked").Call(ctx, EncodeI32(3))
+		require.NoError(t, err)
+		require.Equal(t, int32(9), DecodeI32(results[0]))
+	})
+
+	t.Run("tables", func(t *testing.T) {
+		tbl := mod.ExportedTable("tbl")
+		require.Equal(t, api.ValueTypeFuncref, tbl.Type(ctx))
+		require.Zero(t, tbl.Get(ctx, 3))
+
+		require.NoError(t, tbl.Set(ctx, 3, EncodeFuncref(mod.ExportedFunction("double"))))
+		results, err := DecodeFuncref(mod, tbl.Get(ctx, 3)).Call(ctx, EncodeI32(10))
+		require.NoError(t, err)
+		require.Equal(t, int32(20), DecodeI32(results[0]))
+
+		require.NoError(t, tbl.Set(ctx, 3, 0))
+		require.Zero(t, tbl.Get(ctx, 3))
+		require.Error(t, tbl.Set(ctx, 100, 0))
+	})
+}
@@ -3,11 +3,12 @@ package wasmtime
 import (
 	"context"
 	"fmt"
+	"unsafe"
 
 	"github.com/rvigee/purego-wasmtime/api"
 )
 
-// global implements api.Global for a WebAssembly global variable.
+// global implements api.Global and api.V128Global for a WebAssembly global variable.
 type global struct {
 	val      wasmtime_global_t
 	store    wasmtime_store_t
@@ -17,10 +18,33 @@ type global struct {
 	bindings *bindings
 }
 
+// newGlobal wraps a wasmtime global living in the store behind storeCtx,
+// reading its type and mutability once.
+func newGlobal(val wasmtime_global_t, store wasmtime_store_t, storeCtx wasmtime_context_t, bindings *bindings) *global {
+	g := &global{
+		val:      val,
+		store:    store,
+		storeCtx: storeCtx,
+		bindings: bindings,
+	}
+
+	globalType := bindings.wasmtime_global_type(storeCtx, &g.val)
+	if globalType != 0 {
+		defer bindings.wasm_globaltype_delete(globalType)
+		// The content is owned by the global type
+		g.valType = wasmValueTypeToAPI(bindings.wasm_valtype_kind(bindings.wasm_globaltype_content(globalType)))
+		g.mutable = bindings.wasm_globaltype_mutability(globalType) == WASM_VAR
+	}
+
+	return g
+}
+
 func (g *global) Type() api.ValueType {
 	return g.valType
 }
 
+// Get returns the current value. For a v128 global this is its low half;
+// use GetV128 for both.
 func (g *global) Get(ctx context.Context) uint64 {
 	var val wasmtime_val_t
 	g.bindings.wasmtime_global_get(g.storeCtx, &g.val, &val)
@@ -35,37 +59,72 @@ func (g *global) Get(ctx context.Context) uint64 {
 		return uint64(EncodeF32(val.GetF32()))
 	case WASM_F64:
 		return EncodeF64(val.GetF64())
-	case WASM_EXTERNREF:
-		return uint64(val.GetExternRef())
+	case WASM_V128:
+		return *(*uint64)(unsafe.Pointer(&val.of.data[0]))
+	case WASM_FUNCREF, WASM_EXTERNREF:
+		var v [1]uint64
+		readStackValue(g.bindings, g.storeCtx, v[:], &val)
+		unrootValue(g.bindings, &val)
+		return v[0]
 	default:
 		return 0
 	}
 }
 
+// Set sets the value. For a v128 global it sets the low half and zeroes the
+// high one; use SetV128 to set both.
 func (g *global) Set(ctx context.Context, v uint64) error {
-	if !g.mutable {
-		return fmt.Errorf("global is immutable")
+	if g.valType == api.ValueTypeV128 {
+		return g.SetV128(ctx, v, 0)
 	}
 
 	var val wasmtime_val_t
-	val.kind = uint8(g.valType)
 
 	// Convert uint64 to wasmtime_val_t based on type
 	switch g.valType {
 	case api.ValueTypeI32:
-		val.SetI32(int32(uint32(v)))
+		val.SetI32(DecodeI32(v))
 	case api.ValueTypeI64:
-		val.SetI64(int64(v))
+		val.SetI64(DecodeI64(v))
 	case api.ValueTypeF32:
-		val.SetF32(float32(v))
+		val.SetF32(DecodeF32(v))
 	case api.ValueTypeF64:
-		val.SetF64(float64(v))
-	case api.ValueTypeExternref:
-		val.SetExternRef(uintptr(v))
+		val.SetF64(DecodeF64(v))
+	case api.ValueTypeFuncref, api.ValueTypeExternref:
+		writeStackValue(g.bindings, g.storeCtx, []uint64{v}, g.valType, &val)
+		defer unrootValue(g.bindings, &val)
 	default:
 		return fmt.Errorf("unsupported global type: %v", g.valType)
 	}
 
-	g.bindings.wasmtime_global_set(g.storeCtx, &g.val, &val)
+	return g.set(&val)
+}
+
+func (g *global) GetV128(ctx context.Context) (lo, hi uint64) {
+	var val wasmtime_val_t
+	g.bindings.wasmtime_global_get(g.storeCtx, &g.val, &val)
+	if val.kind != WASM_V128 {
+		return g.Get(ctx), 0
+	}
+	return EncodeV128(val.GetV128())
+}
+
+func (g *global) SetV128(ctx context.Context, lo, hi uint64) error {
+	if g.valType != api.ValueTypeV128 {
+		return fmt.Errorf("global is %v, not v128", g.valType)
+	}
+
+	var val wasmtime_val_t
+	val.SetV128(DecodeV128(lo, hi))
+	return g.set(&val)
+}
+
+func (g *global) set(val *wasmtime_val_t) error {
+	if !g.mutable {
+		return fmt.Errorf("global is immutable")
+	}
+	if err := g.bindings.wasmtime_global_set(g.storeCtx, &g.val, val); err != 0 {
+		return g.bindings.getErrorMessage(err, 0)
+	}
 	return nil
 }
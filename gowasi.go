@@ -0,0 +1,567 @@
+package wasmtime
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/rvigee/purego-wasmtime/api"
+)
+
+// wasiModuleName is the import module of WASI preview1 functions.
+const wasiModuleName = "wasi_snapshot_preview1"
+
+// WritableFS is an fs.FS that the Go WASI implementation can also modify.
+// Without it, guests can only read the files of a filesystem, and the
+// calls modifying it fail with EROFS.
+type WritableFS interface {
+	fs.FS
+
+	// OpenFile opens name with the flags of os.OpenFile, such as os.O_RDWR,
+	// os.O_CREATE or os.O_TRUNC. Files opened for writing must implement
+	// io.Writer, and may implement io.WriterAt and Truncate(int64) error.
+	OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error)
+
+	// Mkdir creates the directory name.
+	Mkdir(name string, perm fs.FileMode) error
+
+	// Remove removes the file or empty directory name.
+	Remove(name string) error
+
+	// Rename moves oldname to newname, replacing newname if it is a file.
+	Rename(oldname, newname string) error
+}
+
+// NewDirFS returns a WritableFS of the host directory dir. Like the
+// directories wasmtime preopens, it cannot be escaped through "..", absolute
+// paths or symbolic links.
+func NewDirFS(dir string) (WritableFS, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &dirFS{FS: root.FS(), root: root}, nil
+}
+
+// dirFS is a WritableFS of a host directory.
+type dirFS struct {
+	fs.FS
+	root *os.Root
+}
+
+func (d *dirFS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	return d.root.OpenFile(name, flag, perm)
+}
+
+func (d *dirFS) Mkdir(name string, perm fs.FileMode) error {
+	return d.root.Mkdir(name, perm)
+}
+
+func (d *dirFS) Remove(name string) error {
+	return d.root.Remove(name)
+}
+
+func (d *dirFS) Rename(oldname, newname string) error {
+	return d.root.Rename(oldname, newname)
+}
+
+// InstantiateGoWASI defines wasi_snapshot_preview1 in r with functions
+// written in Go, in place of wasmtime's WASI. Unlike wasmtime's WASI, which
+// only preopens host directories, it serves guests files from any fs.FS,
+// such as an embed.FS or an fstest.MapFS, given with ModuleConfig.WithFS
+// and preopened as "/". Filesystems implementing WritableFS can also be
+// modified, and host directories given with ModuleConfig.WithDirPreopen are
+// preopened through NewDirFS. Stdio goes directly to the readers and
+// writers of the ModuleConfig, without goroutines or pipes.
+//
+// It must be called before any module using WASI is instantiated, as WASI
+// is then defined in the linker. Instances from InstantiateModule and
+// RunCommand get a WASI context from their own ModuleConfig. Those from
+// InstantiateWithWASI each get a fresh one configured by config, as
+// WASIConfig does not apply to the Go implementation.
+//
+// The implementation covers args, environ, clocks, random, proc_exit and the
+// fd and path functions guests use to work with files. Sockets, symbolic
+// links, file times and signals are not supported and fail with ENOTSUP or
+// ENOSYS.
+func InstantiateGoWASI(ctx context.Context, r Runtime, config ModuleConfig) error {
+	wr, ok := r.(*wasmRuntime)
+	if !ok {
+		return fmt.Errorf("invalid runtime type")
+	}
+	mc, ok := config.(*moduleConfig)
+	if !ok {
+		return fmt.Errorf("invalid module config type")
+	}
+
+	wr.wasiMu.Lock()
+	defer wr.wasiMu.Unlock()
+	if wr.wasiDefined {
+		return fmt.Errorf("WASI is already defined in the runtime")
+	}
+
+	w, err := newGoWASI(mc)
+	if err != nil {
+		return fmt.Errorf("failed to configure Go WASI: %w", err)
+	}
+
+	host := wr.NewHostModuleBuilder(wasiModuleName)
+	for _, f := range goWASIFuncs {
+		host.NewFunctionBuilder(f.name, wasiParamTypes(f.params), []api.ValueType{api.ValueTypeI32}).
+			WithGoModuleFunc(f.goModuleFunc()).
+			Export(f.name)
+	}
+	// proc_exit unwinds the guest, which only a trap can do
+	host.NewFunctionBuilder("proc_exit", []api.ValueType{api.ValueTypeI32}, nil).
+		WithFunc(func(code uint32) error {
+			return &WASIExitError{ExitCode: int32(code)}
+		}).
+		Export("proc_exit")
+	if err := host.Instantiate(ctx); err != nil {
+		host.Close(ctx)
+		return err
+	}
+
+	wr.storeState.goWASI = w
+	wr.wasiDefined = true
+	wr.goWASIConfig = mc
+	return nil
+}
+
+// WASI preview1 errno values.
+type wasiErrno uint32
+
+const (
+	errnoSuccess    wasiErrno = 0
+	errnoAcces      wasiErrno = 2
+	errnoBadf       wasiErrno = 8
+	errnoExist      wasiErrno = 20
+	errnoFault      wasiErrno = 21
+	errnoInval      wasiErrno = 28
+	errnoIO         wasiErrno = 29
+	errnoIsdir      wasiErrno = 31
+	errnoNoent      wasiErrno = 44
+	errnoNosys      wasiErrno = 52
+	errnoNotdir     wasiErrno = 54
+	errnoNotempty   wasiErrno = 55
+	errnoNotsup     wasiErrno = 58
+	errnoRofs       wasiErrno = 69
+	errnoSpipe      wasiErrno = 70
+	errnoXdev       wasiErrno = 75
+	errnoNotcapable wasiErrno = 76
+)
+
+// WASI preview1 clock ids.
+const (
+	clockRealtime = iota
+	clockMonotonic
+	clockProcessCPUTime
+	clockThreadCPUTime
+)
+
+// goWASI is the WASI context of a store using the Go implementation.
+type goWASI struct {
+	args   []string
+	env    []string // KEY=value pairs
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	start  time.Time // Origin of the monotonic clock
+
+	fds    map[uint32]*wasiFD
+	nextFD uint32
+}
+
+// newGoWASI creates a WASI context from the settings of mc.
+func newGoWASI(mc *moduleConfig) (*goWASI, error) {
+	w := &goWASI{
+		args:   mc.args,
+		stdin:  mc.stdin,
+		stdout: mc.stdout,
+		stderr: mc.stderr,
+		start:  time.Now(),
+		fds:    make(map[uint32]*wasiFD),
+	}
+	for _, key := range slices.Sorted(maps.Keys(mc.env)) {
+		w.env = append(w.env, key+"="+mc.env[key])
+	}
+	if w.stdin == nil {
+		w.stdin = eofReader{}
+	}
+	if w.stdout == nil {
+		w.stdout = io.Discard
+	}
+	if w.stderr == nil {
+		w.stderr = io.Discard
+	}
+
+	w.fds[0] = &wasiFD{reader: w.stdin}
+	w.fds[1] = &wasiFD{writer: w.stdout}
+	w.fds[2] = &wasiFD{writer: w.stderr}
+	w.nextFD = 3
+
+	// Preopens are found by guests probing descriptors from 3 onwards
+	mount := 0
+	if mc.filesystem != nil {
+		w.preopen(mc.filesystem, mount, "/")
+		mount++
+	}
+	for _, guestPath := range slices.Sorted(maps.Keys(mc.preopens)) {
+		fsys, err := NewDirFS(mc.preopens[guestPath])
+		if err != nil {
+			return nil, fmt.Errorf("failed to preopen %s: %w", guestPath, err)
+		}
+		w.preopen(fsys, mount, guestPath)
+		mount++
+	}
+	return w, nil
+}
+
+// preopen makes the root of fsys available to the guest as guestPath.
+func (w *goWASI) preopen(fsys fs.FS, mount int, guestPath string) {
+	w.add(&wasiFD{fsys: fsys, mount: mount, name: ".", isDir: true, preopen: guestPath})
+}
+
+// add allocates a descriptor for f.
+func (w *goWASI) add(f *wasiFD) uint32 {
+	fd := w.nextFD
+	w.nextFD++
+	w.fds[fd] = f
+	return fd
+}
+
+// close closes the files the guest left open.
+func (w *goWASI) close() {
+	if w == nil {
+		return
+	}
+	for fd, f := range w.fds {
+		f.close()
+		delete(w.fds, fd)
+	}
+}
+
+// wasiFD is an open WASI descriptor: a stdio stream, a file or a directory
+// of a filesystem.
+type wasiFD struct {
+	// reader and writer are set for stdio streams
+	reader io.Reader
+	writer io.Writer
+
+	fsys    fs.FS
+	mount   int    // Index of the preopen fsys belongs to
+	name    string // Path within fsys, "." for its root
+	file    fs.File
+	isDir   bool
+	append  bool   // Whether writes go to the end of the file
+	preopen string // Guest path of preopened directories
+
+	// dirents are the entries listed by the last fd_readdir starting over
+	dirents []fs.DirEntry
+}
+
+func (f *wasiFD) isStdio() bool {
+	return f.fsys == nil
+}
+
+func (f *wasiFD) close() error {
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+// eofReader is the stdin of guests not given one.
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+// goWASIFunc is a WASI function taking the given params, i for i32 and I for
+// i64, and returning an errno.
+type goWASIFunc struct {
+	name   string
+	params string
+	fn     func(w *goWASI, ctx context.Context, mem api.Memory, p []uint64) wasiErrno
+}
+
+// goModuleFunc adapts f to the host function calling convention, finding
+// the WASI context of the calling store.
+func (f goWASIFunc) goModuleFunc() GoModuleFunc {
+	return func(ctx context.Context, mod api.Module, stack []uint64) {
+		var w *goWASI
+		if cm, ok := mod.(*callerModule); ok {
+			if state := cm.bindings.stateFromContext(cm.store); state != nil {
+				w = state.goWASI
+			}
+		}
+		if w == nil {
+			stack[0] = uint64(errnoNotcapable)
+			return
+		}
+		mem := mod.ExportedMemory("memory")
+		if mem == nil {
+			stack[0] = uint64(errnoFault)
+			return
+		}
+		stack[0] = uint64(f.fn(w, ctx, mem, stack[:len(f.params)]))
+	}
+}
+
+func wasiParamTypes(params string) []api.ValueType {
+	types := make([]api.ValueType, len(params))
+	for i, p := range params {
+		if p == 'I' {
+			types[i] = api.ValueTypeI64
+		} else {
+			types[i] = api.ValueTypeI32
+		}
+	}
+	return types
+}
+
+// goWASIFuncs are the functions of wasi_snapshot_preview1 besides proc_exit.
+var goWASIFuncs = []goWASIFunc{
+	{"args_get", "ii", func(w *goWASI, ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+		return writeStrings(mem, w.args, uint32(p[0]), uint32(p[1]))
+	}},
+	{"args_sizes_get", "ii", func(w *goWASI, ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+		return writeStringSizes(mem, w.args, uint32(p[0]), uint32(p[1]))
+	}},
+	{"environ_get", "ii", func(w *goWASI, ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+		return writeStrings(mem, w.env, uint32(p[0]), uint32(p[1]))
+	}},
+	{"environ_sizes_get", "ii", func(w *goWASI, ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+		return writeStringSizes(mem, w.env, uint32(p[0]), uint32(p[1]))
+	}},
+	{"clock_res_get", "ii", func(w *goWASI, ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+		if uint32(p[0]) > clockThreadCPUTime {
+			return errnoInval
+		}
+		return writeUint64(mem, uint32(p[1]), 1)
+	}},
+	{"clock_time_get", "iIi", func(w *goWASI, ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+		now, ok := w.now(uint32(p[0]))
+		if !ok {
+			return errnoInval
+		}
+		return writeUint64(mem, uint32(p[2]), now)
+	}},
+	{"random_get", "ii", func(w *goWASI, ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+		buf, ok := mem.Read(uint32(p[0]), uint32(p[1]))
+		if !ok {
+			return errnoFault
+		}
+		rand.Read(buf)
+		return errnoSuccess
+	}},
+	{"poll_oneoff", "iiii", (*goWASI).pollOneoff},
+	{"proc_raise", "i", unsupported(errnoNosys)},
+	{"sched_yield", "", func(w *goWASI, ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+		runtime.Gosched()
+		return errnoSuccess
+	}},
+
+	{"fd_advise", "iIIi", (*goWASI).fdNoop},
+	{"fd_allocate", "iII", unsupported(errnoNotsup)},
+	{"fd_close", "i", (*goWASI).fdClose},
+	{"fd_datasync", "i", (*goWASI).fdSync},
+	{"fd_fdstat_get", "ii", (*goWASI).fdFdstatGet},
+	{"fd_fdstat_set_flags", "ii", unsupported(errnoNotsup)},
+	{"fd_fdstat_set_rights", "iII", (*goWASI).fdNoop},
+	{"fd_filestat_get", "ii", (*goWASI).fdFilestatGet},
+	{"fd_filestat_set_size", "iI", (*goWASI).fdFilestatSetSize},
+	{"fd_filestat_set_times", "iIIi", unsupported(errnoNotsup)},
+	{"fd_pread", "iiiIi", (*goWASI).fdPread},
+	{"fd_prestat_get", "ii", (*goWASI).fdPrestatGet},
+	{"fd_prestat_dir_name", "iii", (*goWASI).fdPrestatDirName},
+	{"fd_pwrite", "iiiIi", (*goWASI).fdPwrite},
+	{"fd_read", "iiii", (*goWASI).fdRead},
+	{"fd_readdir", "iiiIi", (*goWASI).fdReaddir},
+	{"fd_renumber", "ii", (*goWASI).fdRenumber},
+	{"fd_seek", "iIii", (*goWASI).fdSeek},
+	{"fd_sync", "i", (*goWASI).fdSync},
+	{"fd_tell", "ii", (*goWASI).fdTell},
+	{"fd_write", "iiii", (*goWASI).fdWrite},
+
+	{"path_create_directory", "iii", (*goWASI).pathCreateDirectory},
+	{"path_filestat_get", "iiiii", (*goWASI).pathFilestatGet},
+	{"path_filestat_set_times", "iiiiIIi", unsupported(errnoNotsup)},
+	{"path_link", "iiiiiii", unsupported(errnoNotsup)},
+	{"path_open", "iiiiiIIii", (*goWASI).pathOpen},
+	{"path_readlink", "iiiiii", unsupported(errnoNotsup)},
+	{"path_remove_directory", "iii", (*goWASI).pathRemoveDirectory},
+	{"path_rename", "iiiiii", (*goWASI).pathRename},
+	{"path_symlink", "iiiii", unsupported(errnoNotsup)},
+	{"path_unlink_file", "iii", (*goWASI).pathUnlinkFile},
+
+	{"sock_accept", "iii", unsupported(errnoNotsup)},
+	{"sock_recv", "iiiiii", unsupported(errnoNotsup)},
+	{"sock_send", "iiiii", unsupported(errnoNotsup)},
+	{"sock_shutdown", "ii", unsupported(errnoNotsup)},
+}
+
+// unsupported returns a WASI function failing with errno.
+func unsupported(errno wasiErrno) func(*goWASI, context.Context, api.Memory, []uint64) wasiErrno {
+	return func(*goWASI, context.Context, api.Memory, []uint64) wasiErrno {
+		return errno
+	}
+}
+
+// now reads the clock id in nanoseconds.
+func (w *goWASI) now(id uint32) (uint64, bool) {
+	switch id {
+	case clockRealtime:
+		return uint64(time.Now().UnixNano()), true
+	case clockMonotonic, clockProcessCPUTime, clockThreadCPUTime:
+		// CPU time is not tracked, so the monotonic clock stands in for it
+		return uint64(time.Since(w.start)), true
+	default:
+		return 0, false
+	}
+}
+
+// WASI preview1 subscription and event layouts used by poll_oneoff.
+const (
+	subscriptionSize = 48
+	eventSize        = 32
+
+	eventTypeClock   = 0
+	eventTypeFdRead  = 1
+	eventTypeFdWrite = 2
+
+	subclockAbstime = 1
+)
+
+// pollOneoff waits for clock subscriptions. Descriptors are always ready, so
+// when the guest also waits on some, they are reported right away.
+func (w *goWASI) pollOneoff(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	in, out, nsubs, neventsPtr := uint32(p[0]), uint32(p[1]), uint32(p[2]), uint32(p[3])
+	if nsubs == 0 {
+		return errnoInval
+	}
+	// Sized in 64 bits, as a huge nsubs would wrap around in 32
+	size := uint64(nsubs) * subscriptionSize
+	if size > uint64(mem.DataSize(ctx)) {
+		return errnoInval
+	}
+	subs, ok := mem.Read(in, uint32(size))
+	if !ok {
+		return errnoFault
+	}
+
+	type clockSub struct {
+		userdata []byte
+		deadline uint64 // Monotonic nanoseconds
+	}
+	var events [][]byte
+	var clocks []clockSub
+	for i := range nsubs {
+		sub := subs[i*subscriptionSize:][:subscriptionSize]
+		event := make([]byte, eventSize)
+		copy(event, sub[:8])
+		event[10] = sub[8]
+		switch sub[8] {
+		case eventTypeClock:
+			id := binary.LittleEndian.Uint32(sub[16:])
+			timeout := binary.LittleEndian.Uint64(sub[24:])
+			now, ok := w.now(id)
+			if !ok {
+				binary.LittleEndian.PutUint16(event[8:], uint16(errnoInval))
+				events = append(events, event)
+				continue
+			}
+			if binary.LittleEndian.Uint16(sub[40:])&subclockAbstime != 0 {
+				timeout = max(timeout, now) - now
+			}
+			monotonic, _ := w.now(clockMonotonic)
+			clocks = append(clocks, clockSub{userdata: sub[:8], deadline: monotonic + timeout})
+		case eventTypeFdRead, eventTypeFdWrite:
+			if _, ok := w.fds[binary.LittleEndian.Uint32(sub[16:])]; !ok {
+				binary.LittleEndian.PutUint16(event[8:], uint16(errnoBadf))
+			}
+			events = append(events, event)
+		default:
+			return errnoInval
+		}
+	}
+
+	// Only sleep when nothing else is ready, until the first deadline
+	if len(events) == 0 {
+		first := slices.MinFunc(clocks, func(a, b clockSub) int {
+			return cmp.Compare(a.deadline, b.deadline)
+		})
+		now, _ := w.now(clockMonotonic)
+		if first.deadline > now {
+			timer := time.NewTimer(time.Duration(first.deadline - now))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+			}
+		}
+		now, _ = w.now(clockMonotonic)
+		for _, c := range clocks {
+			if c.deadline <= now || c.deadline == first.deadline {
+				event := make([]byte, eventSize)
+				copy(event, c.userdata)
+				event[10] = eventTypeClock
+				events = append(events, event)
+			}
+		}
+	}
+
+	if !mem.Write(out, slices.Concat(events...)) {
+		return errnoFault
+	}
+	return writeUint32(mem, neventsPtr, uint32(len(events)))
+}
+
+// writeStrings writes strs as NUL-terminated strings to buf, and pointers to
+// them to ptrs, as args_get and environ_get do.
+func writeStrings(mem api.Memory, strs []string, ptrs, buf uint32) wasiErrno {
+	for i, s := range strs {
+		if !mem.WriteUint32Le(ptrs+uint32(i)*4, buf) || !mem.WriteString(buf, s) || !mem.WriteByte(buf+uint32(len(s)), 0) {
+			return errnoFault
+		}
+		buf += uint32(len(s)) + 1
+	}
+	return errnoSuccess
+}
+
+// writeStringSizes writes the count of strs and the size of their
+// NUL-terminated strings, as args_sizes_get and environ_sizes_get do.
+func writeStringSizes(mem api.Memory, strs []string, countPtr, sizePtr uint32) wasiErrno {
+	size := 0
+	for _, s := range strs {
+		size += len(s) + 1
+	}
+	if errno := writeUint32(mem, countPtr, uint32(len(strs))); errno != errnoSuccess {
+		return errno
+	}
+	return writeUint32(mem, sizePtr, uint32(size))
+}
+
+func writeUint32(mem api.Memory, offset, v uint32) wasiErrno {
+	if !mem.WriteUint32Le(offset, v) {
+		return errnoFault
+	}
+	return errnoSuccess
+}
+
+func writeUint64(mem api.Memory, offset uint32, v uint64) wasiErrno {
+	if !mem.WriteUint64Le(offset, v) {
+		return errnoFault
+	}
+	return errnoSuccess
+}
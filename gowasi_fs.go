@@ -0,0 +1,695 @@
+package wasmtime
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/rvigee/purego-wasmtime/api"
+)
+
+// WASI preview1 file types.
+const (
+	filetypeUnknown         = 0
+	filetypeBlockDevice     = 1
+	filetypeCharacterDevice = 2
+	filetypeDirectory       = 3
+	filetypeRegularFile     = 4
+	filetypeSocketStream    = 6
+	filetypeSymbolicLink    = 7
+)
+
+// WASI preview1 flags and rights used by fd and path functions.
+const (
+	fdflagAppend = 1
+
+	oflagCreat     = 1
+	oflagDirectory = 2
+	oflagExcl      = 4
+	oflagTrunc     = 8
+
+	rightFdWrite = 1 << 6
+	// rightsAll grants every right. Descriptors are limited by what their
+	// filesystem allows rather than by rights.
+	rightsAll = 1<<29 - 1
+
+	direntSize = 24
+)
+
+// errnoFor maps a filesystem error to an errno.
+func errnoFor(err error) wasiErrno {
+	switch {
+	case err == nil:
+		return errnoSuccess
+	case errors.Is(err, syscall.ENOTEMPTY):
+		// Checked first, as it also counts as fs.ErrExist
+		return errnoNotempty
+	case errors.Is(err, fs.ErrNotExist):
+		return errnoNoent
+	case errors.Is(err, fs.ErrExist):
+		return errnoExist
+	case errors.Is(err, fs.ErrPermission):
+		return errnoAcces
+	case errors.Is(err, syscall.ENOTDIR):
+		return errnoNotdir
+	case errors.Is(err, syscall.EISDIR):
+		return errnoIsdir
+	case errors.Is(err, syscall.EXDEV):
+		return errnoXdev
+	case errors.Is(err, fs.ErrInvalid), errors.Is(err, syscall.EINVAL):
+		return errnoInval
+	case errors.Is(err, errors.ErrUnsupported):
+		return errnoNotsup
+	default:
+		return errnoIO
+	}
+}
+
+// fd returns the descriptor fd.
+func (w *goWASI) fd(fd uint32) (*wasiFD, wasiErrno) {
+	f, ok := w.fds[fd]
+	if !ok {
+		return nil, errnoBadf
+	}
+	return f, errnoSuccess
+}
+
+// dir returns the directory descriptor fd.
+func (w *goWASI) dir(fd uint32) (*wasiFD, wasiErrno) {
+	f, errno := w.fd(fd)
+	if errno != errnoSuccess {
+		return nil, errno
+	}
+	if !f.isDir {
+		return nil, errnoNotdir
+	}
+	return f, errnoSuccess
+}
+
+// resolve returns the directory fd and the path within its filesystem of the
+// guest path at ptr, relative to the directory. Paths escaping the
+// filesystem are refused, as guests may only reach what was preopened.
+func (w *goWASI) resolve(mem api.Memory, fd, ptr, n uint32) (*wasiFD, string, wasiErrno) {
+	d, errno := w.dir(fd)
+	if errno != errnoSuccess {
+		return nil, "", errno
+	}
+	b, ok := mem.Read(ptr, n)
+	if !ok {
+		return nil, "", errnoFault
+	}
+	guestPath := string(b)
+	if path.IsAbs(guestPath) {
+		return nil, "", errnoNotcapable
+	}
+	name := path.Join(d.name, guestPath)
+	if !fs.ValidPath(name) {
+		return nil, "", errnoNotcapable
+	}
+	return d, name, errnoSuccess
+}
+
+// writable returns the filesystem of d if it can be modified.
+func writable(d *wasiFD) (WritableFS, wasiErrno) {
+	wfs, ok := d.fsys.(WritableFS)
+	if !ok {
+		return nil, errnoRofs
+	}
+	return wfs, errnoSuccess
+}
+
+// iovecs returns the buffers of the n iovecs at iovs. The iovec array is
+// read as a whole first, so that n is bounded by the memory before anything
+// is allocated for it.
+func iovecs(mem api.Memory, iovs, n uint32) ([][]byte, wasiErrno) {
+	size := uint64(n) * 8
+	if size > math.MaxUint32 {
+		return nil, errnoFault
+	}
+	raw, ok := mem.Read(iovs, uint32(size))
+	if !ok {
+		return nil, errnoFault
+	}
+	bufs := make([][]byte, len(raw)/8)
+	for i := range bufs {
+		ptr := binary.LittleEndian.Uint32(raw[i*8:])
+		length := binary.LittleEndian.Uint32(raw[i*8+4:])
+		buf, ok := mem.Read(ptr, length)
+		if !ok {
+			return nil, errnoFault
+		}
+		bufs[i] = buf
+	}
+	return bufs, errnoSuccess
+}
+
+func (w *goWASI) fdNoop(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	_, errno := w.fd(uint32(p[0]))
+	return errno
+}
+
+func (w *goWASI) fdClose(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	fd := uint32(p[0])
+	f, errno := w.fd(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	delete(w.fds, fd)
+	return errnoFor(f.close())
+}
+
+func (w *goWASI) fdSync(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	f, errno := w.fd(uint32(p[0]))
+	if errno != errnoSuccess {
+		return errno
+	}
+	if s, ok := f.file.(interface{ Sync() error }); ok {
+		return errnoFor(s.Sync())
+	}
+	return errnoSuccess
+}
+
+func (w *goWASI) fdFdstatGet(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	fd, ptr := uint32(p[0]), uint32(p[1])
+	f, errno := w.fd(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	var stat [24]byte
+	switch {
+	case f.isStdio():
+		stat[0] = filetypeCharacterDevice
+	case f.isDir:
+		stat[0] = filetypeDirectory
+	default:
+		stat[0] = filetypeRegularFile
+	}
+	if f.append {
+		binary.LittleEndian.PutUint16(stat[2:], fdflagAppend)
+	}
+	binary.LittleEndian.PutUint64(stat[8:], rightsAll)
+	binary.LittleEndian.PutUint64(stat[16:], rightsAll)
+	if !mem.Write(ptr, stat[:]) {
+		return errnoFault
+	}
+	return errnoSuccess
+}
+
+// stat returns information about the file of f.
+func (f *wasiFD) stat() (fs.FileInfo, error) {
+	if f.file != nil {
+		return f.file.Stat()
+	}
+	return fs.Stat(f.fsys, f.name)
+}
+
+// writeFilestat writes info to ptr as a WASI filestat. A nil info describes
+// a character device, such as stdio.
+func writeFilestat(mem api.Memory, ptr uint32, info fs.FileInfo) wasiErrno {
+	var stat [64]byte
+	if info == nil {
+		stat[16] = filetypeCharacterDevice
+	} else {
+		stat[16] = filetypeOf(info.Mode())
+		binary.LittleEndian.PutUint64(stat[24:], 1) // nlink
+		binary.LittleEndian.PutUint64(stat[32:], uint64(info.Size()))
+		mtime := uint64(0)
+		if !info.ModTime().IsZero() {
+			mtime = uint64(info.ModTime().UnixNano())
+		}
+		// Only the modification time is known, so it stands for all three
+		binary.LittleEndian.PutUint64(stat[40:], mtime)
+		binary.LittleEndian.PutUint64(stat[48:], mtime)
+		binary.LittleEndian.PutUint64(stat[56:], mtime)
+	}
+	if !mem.Write(ptr, stat[:]) {
+		return errnoFault
+	}
+	return errnoSuccess
+}
+
+func filetypeOf(mode fs.FileMode) uint8 {
+	switch {
+	case mode.IsDir():
+		return filetypeDirectory
+	case mode.IsRegular():
+		return filetypeRegularFile
+	case mode&fs.ModeSymlink != 0:
+		return filetypeSymbolicLink
+	case mode&fs.ModeCharDevice != 0:
+		return filetypeCharacterDevice
+	case mode&fs.ModeDevice != 0:
+		return filetypeBlockDevice
+	case mode&fs.ModeSocket != 0:
+		return filetypeSocketStream
+	default:
+		return filetypeUnknown
+	}
+}
+
+func (w *goWASI) fdFilestatGet(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	fd, ptr := uint32(p[0]), uint32(p[1])
+	f, errno := w.fd(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if f.isStdio() {
+		return writeFilestat(mem, ptr, nil)
+	}
+	info, err := f.stat()
+	if err != nil {
+		return errnoFor(err)
+	}
+	return writeFilestat(mem, ptr, info)
+}
+
+func (w *goWASI) fdFilestatSetSize(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	f, errno := w.fd(uint32(p[0]))
+	if errno != errnoSuccess {
+		return errno
+	}
+	t, ok := f.file.(interface{ Truncate(int64) error })
+	if !ok {
+		return errnoBadf
+	}
+	return errnoFor(t.Truncate(int64(p[1])))
+}
+
+func (w *goWASI) fdPrestatGet(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	fd, ptr := uint32(p[0]), uint32(p[1])
+	f, errno := w.fd(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if f.preopen == "" {
+		return errnoBadf
+	}
+	// The tag 0 is for directories, the only kind of preopen
+	var prestat [8]byte
+	binary.LittleEndian.PutUint32(prestat[4:], uint32(len(f.preopen)))
+	if !mem.Write(ptr, prestat[:]) {
+		return errnoFault
+	}
+	return errnoSuccess
+}
+
+func (w *goWASI) fdPrestatDirName(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	fd, ptr, n := uint32(p[0]), uint32(p[1]), uint32(p[2])
+	f, errno := w.fd(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if f.preopen == "" {
+		return errnoBadf
+	}
+	if n < uint32(len(f.preopen)) {
+		return errnoInval
+	}
+	if !mem.WriteString(ptr, f.preopen) {
+		return errnoFault
+	}
+	return errnoSuccess
+}
+
+func (w *goWASI) fdRead(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	fd, iovs, iovsLen, nreadPtr := uint32(p[0]), uint32(p[1]), uint32(p[2]), uint32(p[3])
+	f, errno := w.fd(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	r := f.reader
+	if r == nil && f.file != nil {
+		r = f.file
+	}
+	if r == nil {
+		return errnoBadf
+	}
+	bufs, errno := iovecs(mem, iovs, iovsLen)
+	if errno != errnoSuccess {
+		return errno
+	}
+
+	// Stop at the first short read, as waiting for more could block on
+	// streams such as stdin
+	total := 0
+	for _, buf := range bufs {
+		n, err := r.Read(buf)
+		total += n
+		if err != nil && err != io.EOF {
+			if total == 0 {
+				return errnoFor(err)
+			}
+			break
+		}
+		if n < len(buf) {
+			break
+		}
+	}
+	return writeUint32(mem, nreadPtr, uint32(total))
+}
+
+func (w *goWASI) fdPread(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	fd, iovs, iovsLen, offset, nreadPtr := uint32(p[0]), uint32(p[1]), uint32(p[2]), int64(p[3]), uint32(p[4])
+	f, errno := w.fd(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if f.isStdio() {
+		return errnoSpipe
+	}
+	ra, ok := f.file.(io.ReaderAt)
+	if !ok {
+		return errnoBadf
+	}
+	bufs, errno := iovecs(mem, iovs, iovsLen)
+	if errno != errnoSuccess {
+		return errno
+	}
+
+	total := 0
+	for _, buf := range bufs {
+		n, err := ra.ReadAt(buf, offset+int64(total))
+		total += n
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errnoFor(err)
+		}
+	}
+	return writeUint32(mem, nreadPtr, uint32(total))
+}
+
+func (w *goWASI) fdWrite(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	fd, iovs, iovsLen, nwrittenPtr := uint32(p[0]), uint32(p[1]), uint32(p[2]), uint32(p[3])
+	f, errno := w.fd(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	wr := f.writer
+	if wr == nil {
+		wr, _ = f.file.(io.Writer)
+	}
+	if wr == nil {
+		return errnoBadf
+	}
+	bufs, errno := iovecs(mem, iovs, iovsLen)
+	if errno != errnoSuccess {
+		return errno
+	}
+
+	total := 0
+	for _, buf := range bufs {
+		n, err := wr.Write(buf)
+		total += n
+		if err != nil {
+			return errnoFor(err)
+		}
+	}
+	return writeUint32(mem, nwrittenPtr, uint32(total))
+}
+
+func (w *goWASI) fdPwrite(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	fd, iovs, iovsLen, offset, nwrittenPtr := uint32(p[0]), uint32(p[1]), uint32(p[2]), int64(p[3]), uint32(p[4])
+	f, errno := w.fd(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if f.isStdio() {
+		return errnoSpipe
+	}
+	wa, ok := f.file.(io.WriterAt)
+	if !ok {
+		return errnoBadf
+	}
+	bufs, errno := iovecs(mem, iovs, iovsLen)
+	if errno != errnoSuccess {
+		return errno
+	}
+
+	total := 0
+	for _, buf := range bufs {
+		n, err := wa.WriteAt(buf, offset+int64(total))
+		total += n
+		if err != nil {
+			return errnoFor(err)
+		}
+	}
+	return writeUint32(mem, nwrittenPtr, uint32(total))
+}
+
+// seek moves the offset of f, as io.Seeker does.
+func (f *wasiFD) seek(offset int64, whence int) (int64, wasiErrno) {
+	if f.isStdio() {
+		return 0, errnoSpipe
+	}
+	if f.isDir {
+		return 0, errnoIsdir
+	}
+	s, ok := f.file.(io.Seeker)
+	if !ok {
+		return 0, errnoSpipe
+	}
+	pos, err := s.Seek(offset, whence)
+	if err != nil {
+		return 0, errnoFor(err)
+	}
+	return pos, errnoSuccess
+}
+
+func (w *goWASI) fdSeek(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	fd, offset, whence, newOffsetPtr := uint32(p[0]), int64(p[1]), uint32(p[2]), uint32(p[3])
+	f, errno := w.fd(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	// The WASI whence values are those of io.Seeker
+	if whence > io.SeekEnd {
+		return errnoInval
+	}
+	pos, errno := f.seek(offset, int(whence))
+	if errno != errnoSuccess {
+		return errno
+	}
+	return writeUint64(mem, newOffsetPtr, uint64(pos))
+}
+
+func (w *goWASI) fdTell(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	fd, offsetPtr := uint32(p[0]), uint32(p[1])
+	f, errno := w.fd(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	pos, errno := f.seek(0, io.SeekCurrent)
+	if errno != errnoSuccess {
+		return errno
+	}
+	return writeUint64(mem, offsetPtr, uint64(pos))
+}
+
+func (w *goWASI) fdRenumber(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	from, to := uint32(p[0]), uint32(p[1])
+	f, errno := w.fd(from)
+	if errno != errnoSuccess {
+		return errno
+	}
+	old, errno := w.fd(to)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if from != to {
+		old.close()
+		w.fds[to] = f
+		delete(w.fds, from)
+	}
+	return errnoSuccess
+}
+
+// fdReaddir lists a directory. The cookie of an entry is its index, and the
+// entries are listed again whenever the guest starts over from cookie 0.
+func (w *goWASI) fdReaddir(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	fd, buf, bufLen, cookie, bufusedPtr := uint32(p[0]), uint32(p[1]), uint32(p[2]), p[3], uint32(p[4])
+	d, errno := w.dir(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if cookie == 0 || d.dirents == nil {
+		entries, err := fs.ReadDir(d.fsys, d.name)
+		if err != nil {
+			return errnoFor(err)
+		}
+		d.dirents = entries
+	}
+
+	// Entries that do not fit are truncated, which tells the guest to call
+	// again with a larger buffer or from a later cookie
+	var out []byte
+	for i := cookie; i < uint64(len(d.dirents)) && uint32(len(out)) < bufLen; i++ {
+		entry := d.dirents[i]
+		var dirent [direntSize]byte
+		binary.LittleEndian.PutUint64(dirent[0:], i+1)
+		binary.LittleEndian.PutUint32(dirent[16:], uint32(len(entry.Name())))
+		dirent[20] = filetypeOf(entry.Type())
+		out = append(out, dirent[:]...)
+		out = append(out, entry.Name()...)
+	}
+	if uint32(len(out)) > bufLen {
+		out = out[:bufLen]
+	}
+	if !mem.Write(buf, out) {
+		return errnoFault
+	}
+	return writeUint32(mem, bufusedPtr, uint32(len(out)))
+}
+
+func (w *goWASI) pathOpen(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	fd, pathPtr, pathLen := uint32(p[0]), uint32(p[2]), uint32(p[3])
+	oflags, rights, fdflags, resultPtr := uint32(p[4]), p[5], uint32(p[7]), uint32(p[8])
+	d, name, errno := w.resolve(mem, fd, pathPtr, pathLen)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if oflags&oflagDirectory != 0 && oflags&(oflagCreat|oflagTrunc) != 0 {
+		return errnoInval
+	}
+
+	opened := &wasiFD{fsys: d.fsys, mount: d.mount, name: name, append: fdflags&fdflagAppend != 0}
+	if oflags&(oflagCreat|oflagExcl|oflagTrunc) != 0 || rights&rightFdWrite != 0 || opened.append {
+		wfs, errno := writable(d)
+		if errno != errnoSuccess {
+			return errno
+		}
+		flag := os.O_RDWR
+		if oflags&oflagCreat != 0 {
+			flag |= os.O_CREATE
+		}
+		if oflags&oflagExcl != 0 {
+			flag |= os.O_EXCL
+		}
+		if oflags&oflagTrunc != 0 {
+			flag |= os.O_TRUNC
+		}
+		if opened.append {
+			flag |= os.O_APPEND
+		}
+		file, err := wfs.OpenFile(name, flag, 0o644)
+		if err != nil {
+			return errnoFor(err)
+		}
+		opened.file = file
+	} else {
+		file, err := d.fsys.Open(name)
+		if err != nil {
+			return errnoFor(err)
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return errnoFor(err)
+		}
+		if info.IsDir() {
+			// Directories are listed by name, so they need no open file
+			file.Close()
+			opened.isDir = true
+		} else {
+			opened.file = file
+		}
+	}
+	if oflags&oflagDirectory != 0 && !opened.isDir {
+		opened.close()
+		return errnoNotdir
+	}
+
+	newFD := w.add(opened)
+	if errno := writeUint32(mem, resultPtr, newFD); errno != errnoSuccess {
+		delete(w.fds, newFD)
+		opened.close()
+		return errno
+	}
+	return errnoSuccess
+}
+
+func (w *goWASI) pathFilestatGet(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	fd, pathPtr, pathLen, ptr := uint32(p[0]), uint32(p[2]), uint32(p[3]), uint32(p[4])
+	d, name, errno := w.resolve(mem, fd, pathPtr, pathLen)
+	if errno != errnoSuccess {
+		return errno
+	}
+	info, err := fs.Stat(d.fsys, name)
+	if err != nil {
+		return errnoFor(err)
+	}
+	return writeFilestat(mem, ptr, info)
+}
+
+func (w *goWASI) pathCreateDirectory(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	d, name, errno := w.resolve(mem, uint32(p[0]), uint32(p[1]), uint32(p[2]))
+	if errno != errnoSuccess {
+		return errno
+	}
+	wfs, errno := writable(d)
+	if errno != errnoSuccess {
+		return errno
+	}
+	return errnoFor(wfs.Mkdir(name, 0o755))
+}
+
+func (w *goWASI) pathRemoveDirectory(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	return w.remove(mem, p, true)
+}
+
+func (w *goWASI) pathUnlinkFile(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	return w.remove(mem, p, false)
+}
+
+// remove removes a directory or a file, refusing the other kind as
+// rmdir and unlink do.
+func (w *goWASI) remove(mem api.Memory, p []uint64, dir bool) wasiErrno {
+	d, name, errno := w.resolve(mem, uint32(p[0]), uint32(p[1]), uint32(p[2]))
+	if errno != errnoSuccess {
+		return errno
+	}
+	wfs, errno := writable(d)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if name == "." {
+		return errnoBadf
+	}
+	info, err := fs.Stat(wfs, name)
+	if err != nil {
+		return errnoFor(err)
+	}
+	switch {
+	case dir && !info.IsDir():
+		return errnoNotdir
+	case !dir && info.IsDir():
+		return errnoIsdir
+	}
+	return errnoFor(wfs.Remove(name))
+}
+
+func (w *goWASI) pathRename(ctx context.Context, mem api.Memory, p []uint64) wasiErrno {
+	oldDir, oldName, errno := w.resolve(mem, uint32(p[0]), uint32(p[1]), uint32(p[2]))
+	if errno != errnoSuccess {
+		return errno
+	}
+	newDir, newName, errno := w.resolve(mem, uint32(p[3]), uint32(p[4]), uint32(p[5]))
+	if errno != errnoSuccess {
+		return errno
+	}
+	if oldDir.mount != newDir.mount {
+		return errnoXdev
+	}
+	wfs, errno := writable(oldDir)
+	if errno != errnoSuccess {
+		return errno
+	}
+	return errnoFor(wfs.Rename(oldName, newName))
+}
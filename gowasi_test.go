@@ -0,0 +1,400 @@
+package wasmtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rvigee/purego-wasmtime/api"
+)
+
+// goWASIWAT is a WASI program driven by its exports. $check exits with
+// 100+errno when a call fails, so that tests see the errno as exit status.
+const goWASIWAT = `
+(module
+	(import "wasi_snapshot_preview1" "path_open"
+		(func $path_open (param i32 i32 i32 i32 i32 i64 i64 i32 i32) (result i32)))
+	(import "wasi_snapshot_preview1" "path_create_directory"
+		(func $path_create_directory (param i32 i32 i32) (result i32)))
+	(import "wasi_snapshot_preview1" "fd_read"
+		(func $fd_read (param i32 i32 i32 i32) (result i32)))
+	(import "wasi_snapshot_preview1" "fd_write"
+		(func $fd_write (param i32 i32 i32 i32) (result i32)))
+	(import "wasi_snapshot_preview1" "fd_close"
+		(func $fd_close (param i32) (result i32)))
+	(import "wasi_snapshot_preview1" "args_sizes_get"
+		(func $args_sizes_get (param i32 i32) (result i32)))
+	(import "wasi_snapshot_preview1" "args_get"
+		(func $args_get (param i32 i32) (result i32)))
+	(import "wasi_snapshot_preview1" "environ_sizes_get"
+		(func $environ_sizes_get (param i32 i32) (result i32)))
+	(import "wasi_snapshot_preview1" "environ_get"
+		(func $environ_get (param i32 i32) (result i32)))
+	(import "wasi_snapshot_preview1" "proc_exit"
+		(func $proc_exit (param i32)))
+	(memory (export "memory") 1)
+	(data (i32.const 0) "dir/hello.txt")
+	(data (i32.const 16) "sub")
+	(data (i32.const 24) "sub/out.txt")
+	(data (i32.const 40) "written")
+
+	(func $check (param i32)
+		(if (local.get 0)
+			(then (call $proc_exit (i32.add (i32.const 100) (local.get 0))))))
+
+	;; stdout writes n bytes at buf to stdout
+	(func $stdout (param $buf i32) (param $n i32)
+		(i32.store (i32.const 200) (local.get $buf))
+		(i32.store (i32.const 204) (local.get $n))
+		(call $check (call $fd_write (i32.const 1) (i32.const 200) (i32.const 1) (i32.const 208))))
+
+	;; cat copies dir/hello.txt of the first preopen to stdout
+	(func (export "cat")
+		(call $check (call $path_open (i32.const 3) (i32.const 0) (i32.const 0) (i32.const 13)
+			(i32.const 0) (i64.const 2) (i64.const 0) (i32.const 0) (i32.const 100)))
+		(i32.store (i32.const 200) (i32.const 1024))
+		(i32.store (i32.const 204) (i32.const 1024))
+		(call $check (call $fd_read (i32.load (i32.const 100)) (i32.const 200) (i32.const 1) (i32.const 208)))
+		(call $check (call $fd_close (i32.load (i32.const 100))))
+		(call $stdout (i32.const 1024) (i32.load (i32.const 208))))
+
+	;; write creates sub/out.txt in the first preopen
+	(func (export "write")
+		(call $check (call $path_create_directory (i32.const 3) (i32.const 16) (i32.const 3)))
+		(call $check (call $path_open (i32.const 3) (i32.const 0) (i32.const 24) (i32.const 11)
+			(i32.const 9) (i64.const 64) (i64.const 0) (i32.const 0) (i32.const 100)))
+		(i32.store (i32.const 200) (i32.const 40))
+		(i32.store (i32.const 204) (i32.const 7))
+		(call $check (call $fd_write (i32.load (i32.const 100)) (i32.const 200) (i32.const 1) (i32.const 208)))
+		(call $check (call $fd_close (i32.load (i32.const 100)))))
+
+	;; args and environ print their NUL-separated strings
+	(func (export "args")
+		(call $check (call $args_sizes_get (i32.const 300) (i32.const 304)))
+		(call $check (call $args_get (i32.const 400) (i32.const 1024)))
+		(call $stdout (i32.const 1024) (i32.load (i32.const 304))))
+	(func (export "environ")
+		(call $check (call $environ_sizes_get (i32.const 300) (i32.const 304)))
+		(call $check (call $environ_get (i32.const 400) (i32.const 1024)))
+		(call $stdout (i32.const 1024) (i32.load (i32.const 304))))
+
+	;; echo copies one read of stdin to stdout
+	(func (export "echo")
+		(i32.store (i32.const 200) (i32.const 1024))
+		(i32.store (i32.const 204) (i32.const 1024))
+		(call $check (call $fd_read (i32.const 0) (i32.const 200) (i32.const 1) (i32.const 208)))
+		(call $stdout (i32.const 1024) (i32.load (i32.const 208))))
+
+	(func (export "exit") (param i32)
+		(call $proc_exit (local.get 0)))
+)`
+
+func TestGoWASI(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntime(ctx)
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	var shared bytes.Buffer
+	require.NoError(t, InstantiateGoWASI(ctx, r, NewModuleConfig().WithArgs("shared").WithStdout(&shared)))
+
+	compiled, err := r.CompileModule(ctx, []byte(goWASIWAT))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	files := fstest.MapFS{"dir/hello.txt": {Data: []byte("hello from fs.FS\n")}}
+	run := func(t *testing.T, fn string, config ModuleConfig) (int, string) {
+		var stdout bytes.Buffer
+		exitCode, err := r.RunCommand(ctx, compiled, config.WithStdout(&stdout).WithStartFunctions(fn))
+		require.NoError(t, err)
+		return exitCode, stdout.String()
+	}
+
+	t.Run("read fs.FS", func(t *testing.T) {
+		exitCode, stdout := run(t, "cat", NewModuleConfig().WithFS(files))
+		assert.Zero(t, exitCode)
+		assert.Equal(t, "hello from fs.FS\n", stdout)
+
+		exitCode, _ = run(t, "cat", NewModuleConfig().WithFS(fstest.MapFS{}))
+		assert.Equal(t, 100+int(errnoNoent), exitCode)
+	})
+
+	t.Run("write", func(t *testing.T) {
+		dir := t.TempDir()
+		exitCode, _ := run(t, "write", NewModuleConfig().WithDirPreopen(dir, "/data"))
+		assert.Zero(t, exitCode)
+		data, err := os.ReadFile(filepath.Join(dir, "sub", "out.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "written", string(data))
+
+		exitCode, _ = run(t, "write", NewModuleConfig().WithFS(files))
+		assert.Equal(t, 100+int(errnoRofs), exitCode, "fstest.MapFS is read-only")
+	})
+
+	t.Run("args and env", func(t *testing.T) {
+		config := NewModuleConfig().WithArgs("prog", "-v").WithEnv("B", "2").WithEnv("A", "1")
+		_, stdout := run(t, "args", config)
+		assert.Equal(t, "prog\x00-v\x00", stdout)
+		_, stdout = run(t, "environ", config)
+		assert.Equal(t, "A=1\x00B=2\x00", stdout)
+	})
+
+	t.Run("stdin", func(t *testing.T) {
+		_, stdout := run(t, "echo", NewModuleConfig().WithStdin(strings.NewReader("ping")))
+		assert.Equal(t, "ping", stdout)
+	})
+
+	t.Run("exit", func(t *testing.T) {
+		mod, err := r.InstantiateModule(ctx, compiled, NewModuleConfig().WithStartFunctions())
+		require.NoError(t, err)
+		defer mod.Close(ctx)
+
+		_, err = mod.ExportedFunction("exit").Call(ctx, 0)
+		assert.NoError(t, err, "exit(0) is a normal return")
+		_, err = mod.ExportedFunction("exit").Call(ctx, 3)
+		var exitErr *WASIExitError
+		require.ErrorAs(t, err, &exitErr)
+		assert.Equal(t, int32(3), exitErr.ExitCode)
+	})
+
+	t.Run("InstantiateWithWASI", func(t *testing.T) {
+		mod, err := r.InstantiateWithWASI(ctx, compiled)
+		require.NoError(t, err)
+		defer mod.Close(ctx)
+		_, err = mod.ExportedFunction("args").Call(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "shared\x00", shared.String())
+
+		_, err = r.InstantiateWithWASIConfig(ctx, compiled, NewWASIConfig())
+		assert.Error(t, err, "WASIConfig is for wasmtime's WASI")
+	})
+}
+
+func TestGoWASIAfterWasmtimeWASI(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntime(ctx)
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	compiled, err := r.CompileModule(ctx, []byte(`(module)`))
+	require.NoError(t, err)
+	defer compiled.Close()
+	mod, err := r.InstantiateModule(ctx, compiled, NewModuleConfig())
+	require.NoError(t, err)
+	mod.Close(ctx)
+
+	assert.Error(t, InstantiateGoWASI(ctx, r, NewModuleConfig()), "wasmtime's WASI is already defined")
+}
+
+// sliceMemory is an api.Memory over a byte slice, to call the Go WASI
+// functions without a runtime.
+type sliceMemory struct {
+	memoryAccessors
+	buf []byte
+}
+
+func newSliceMemory(size int) *sliceMemory {
+	m := &sliceMemory{buf: make([]byte, size)}
+	m.memoryAccessors = memoryAccessors{at: func(offset, n uint64) ([]byte, bool) {
+		if offset > uint64(len(m.buf)) || n > uint64(len(m.buf))-offset {
+			return nil, false
+		}
+		return m.buf[offset : offset+n], true
+	}}
+	return m
+}
+
+func (m *sliceMemory) Data(ctx context.Context) unsafe.Pointer { return unsafe.Pointer(&m.buf[0]) }
+func (m *sliceMemory) DataSize(ctx context.Context) uintptr    { return uintptr(len(m.buf)) }
+func (m *sliceMemory) Size(ctx context.Context) uint64         { return uint64(len(m.buf)) / 65536 }
+func (m *sliceMemory) Grow(ctx context.Context, delta uint64) (uint64, bool) {
+	return 0, false
+}
+func (m *sliceMemory) Definition() api.MemoryDefinition { return nil }
+
+// callGoWASI calls the Go WASI function name.
+func callGoWASI(t *testing.T, w *goWASI, mem api.Memory, name string, params ...uint64) wasiErrno {
+	for _, f := range goWASIFuncs {
+		if f.name == name {
+			require.Len(t, params, len(f.params), name)
+			return f.fn(w, t.Context(), mem, params)
+		}
+	}
+	t.Fatalf("no WASI function %s", name)
+	return 0
+}
+
+func TestGoWASIFuncs(t *testing.T) {
+	files := fstest.MapFS{
+		"a.txt":     {Data: []byte("0123456789")},
+		"dir/b.txt": {Data: []byte("b")},
+		"dir/c.txt": {Data: []byte("c")},
+	}
+	w, err := newGoWASI(NewModuleConfig().WithFS(files).(*moduleConfig))
+	require.NoError(t, err)
+	defer w.close()
+	mem := newSliceMemory(65536)
+
+	// open writes the path at 0 and opens it relative to fd 3
+	open := func(path string, oflags uint32, rights uint64) (uint32, wasiErrno) {
+		copy(mem.buf, path)
+		errno := callGoWASI(t, w, mem, "path_open", 3, 0, 0, uint64(len(path)), uint64(oflags), rights, 0, 0, 500)
+		return binary.LittleEndian.Uint32(mem.buf[500:]), errno
+	}
+
+	t.Run("prestat", func(t *testing.T) {
+		require.Equal(t, errnoSuccess, callGoWASI(t, w, mem, "fd_prestat_get", 3, 100))
+		assert.Equal(t, uint32(1), binary.LittleEndian.Uint32(mem.buf[104:]))
+		require.Equal(t, errnoSuccess, callGoWASI(t, w, mem, "fd_prestat_dir_name", 3, 200, 1))
+		assert.Equal(t, byte('/'), mem.buf[200])
+		assert.Equal(t, errnoBadf, callGoWASI(t, w, mem, "fd_prestat_get", 4, 100), "the end of preopens")
+	})
+
+	t.Run("read and seek", func(t *testing.T) {
+		fd, errno := open("a.txt", 0, 2)
+		require.Equal(t, errnoSuccess, errno)
+		defer callGoWASI(t, w, mem, "fd_close", uint64(fd))
+
+		require.Equal(t, errnoSuccess, callGoWASI(t, w, mem, "fd_seek", uint64(fd), 4, 0, 100))
+		assert.Equal(t, uint64(4), binary.LittleEndian.Uint64(mem.buf[100:]))
+
+		// Two iovecs of 3 bytes at 1000 and 2000
+		binary.LittleEndian.PutUint32(mem.buf[200:], 1000)
+		binary.LittleEndian.PutUint32(mem.buf[204:], 3)
+		binary.LittleEndian.PutUint32(mem.buf[208:], 2000)
+		binary.LittleEndian.PutUint32(mem.buf[212:], 3)
+		require.Equal(t, errnoSuccess, callGoWASI(t, w, mem, "fd_read", uint64(fd), 200, 2, 300))
+		assert.Equal(t, uint32(6), binary.LittleEndian.Uint32(mem.buf[300:]))
+		assert.Equal(t, "456", string(mem.buf[1000:1003]))
+		assert.Equal(t, "789", string(mem.buf[2000:2003]))
+
+		require.Equal(t, errnoSuccess, callGoWASI(t, w, mem, "fd_pread", uint64(fd), 200, 1, 1, 300))
+		assert.Equal(t, "123", string(mem.buf[1000:1003]))
+
+		require.Equal(t, errnoSuccess, callGoWASI(t, w, mem, "fd_filestat_get", uint64(fd), 400))
+		assert.Equal(t, byte(filetypeRegularFile), mem.buf[416])
+		assert.Equal(t, uint64(10), binary.LittleEndian.Uint64(mem.buf[432:]))
+	})
+
+	t.Run("readdir", func(t *testing.T) {
+		fd, errno := open("dir", oflagDirectory, 0)
+		require.Equal(t, errnoSuccess, errno)
+		defer callGoWASI(t, w, mem, "fd_close", uint64(fd))
+
+		require.Equal(t, errnoSuccess, callGoWASI(t, w, mem, "fd_readdir", uint64(fd), 1000, 1000, 0, 300))
+		assert.Equal(t, uint32(2*(direntSize+5)), binary.LittleEndian.Uint32(mem.buf[300:]))
+		assert.Equal(t, "b.txt", string(mem.buf[1000+direntSize:][:5]))
+		assert.Equal(t, byte(filetypeRegularFile), mem.buf[1020])
+
+		// Resuming from the cookie of the first entry lists the second
+		require.Equal(t, errnoSuccess, callGoWASI(t, w, mem, "fd_readdir", uint64(fd), 1000, 1000, 1, 300))
+		assert.Equal(t, uint32(direntSize+5), binary.LittleEndian.Uint32(mem.buf[300:]))
+		assert.Equal(t, "c.txt", string(mem.buf[1000+direntSize:][:5]))
+
+		// A short buffer gets a truncated entry
+		require.Equal(t, errnoSuccess, callGoWASI(t, w, mem, "fd_readdir", uint64(fd), 1000, 10, 0, 300))
+		assert.Equal(t, uint32(10), binary.LittleEndian.Uint32(mem.buf[300:]))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, errno := open("missing", 0, 2)
+		assert.Equal(t, errnoNoent, errno)
+		_, errno = open("../a.txt", 0, 2)
+		assert.Equal(t, errnoNotcapable, errno)
+		_, errno = open("a.txt", oflagDirectory, 0)
+		assert.Equal(t, errnoNotdir, errno)
+		_, errno = open("new.txt", oflagCreat, 64)
+		assert.Equal(t, errnoRofs, errno)
+		assert.Equal(t, errnoBadf, callGoWASI(t, w, mem, "fd_close", 99))
+		assert.Equal(t, errnoSpipe, callGoWASI(t, w, mem, "fd_seek", 1, 0, 0, 100))
+	})
+
+	t.Run("clocks and random", func(t *testing.T) {
+		require.Equal(t, errnoSuccess, callGoWASI(t, w, mem, "clock_time_get", clockRealtime, 1, 100))
+		assert.NotZero(t, binary.LittleEndian.Uint64(mem.buf[100:]))
+		assert.Equal(t, errnoInval, callGoWASI(t, w, mem, "clock_time_get", 9, 1, 100))
+		assert.Equal(t, errnoSuccess, callGoWASI(t, w, mem, "random_get", 100, 32))
+		assert.Equal(t, errnoFault, callGoWASI(t, w, mem, "random_get", 65530, 32))
+	})
+
+	t.Run("poll clock", func(t *testing.T) {
+		sub := mem.buf[1000:][:subscriptionSize]
+		clear(sub)
+		binary.LittleEndian.PutUint64(sub[0:], 42)
+		binary.LittleEndian.PutUint32(sub[16:], clockMonotonic)
+		binary.LittleEndian.PutUint64(sub[24:], 1000) // 1µs
+		require.Equal(t, errnoSuccess, callGoWASI(t, w, mem, "poll_oneoff", 1000, 2000, 1, 300))
+		assert.Equal(t, uint32(1), binary.LittleEndian.Uint32(mem.buf[300:]))
+		assert.Equal(t, uint64(42), binary.LittleEndian.Uint64(mem.buf[2000:]))
+
+		// 0x05555556 subscriptions take 32 bytes once wrapped around in 32 bits
+		assert.Equal(t, errnoInval, callGoWASI(t, w, mem, "poll_oneoff", 1000, 2000, 0x05555556, 300))
+	})
+
+	t.Run("huge iovec counts", func(t *testing.T) {
+		for fd, name := range []string{"fd_read", "fd_write"} {
+			assert.Equal(t, errnoFault, callGoWASI(t, w, mem, name, uint64(fd), 100, 0xffffffff, 300), name)
+			assert.Equal(t, errnoFault, callGoWASI(t, w, mem, name, uint64(fd), 100, 0x20000002, 300), name)
+		}
+		fd, errno := open("a.txt", 0, 0)
+		require.Equal(t, errnoSuccess, errno)
+		defer callGoWASI(t, w, mem, "fd_close", uint64(fd))
+		assert.Equal(t, errnoFault, callGoWASI(t, w, mem, "fd_pread", uint64(fd), 100, 0xffffffff, 0, 300))
+	})
+}
+
+func TestGoWASIWritableFS(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "old.txt"), []byte("x"), 0o644))
+	w, err := newGoWASI(NewModuleConfig().WithDirPreopen(dir, "/data").(*moduleConfig))
+	require.NoError(t, err)
+	defer w.close()
+	mem := newSliceMemory(65536)
+
+	// withPaths writes paths at 0 and 100 for the path functions
+	withPaths := func(a, b string) {
+		copy(mem.buf, a)
+		copy(mem.buf[100:], b)
+	}
+
+	withPaths("sub", "")
+	require.Equal(t, errnoSuccess, callGoWASI(t, w, mem, "path_create_directory", 3, 0, 3))
+	withPaths("old.txt", "sub/new.txt")
+	require.Equal(t, errnoSuccess, callGoWASI(t, w, mem, "path_rename", 3, 0, 7, 3, 100, 11))
+	_, err = os.Stat(filepath.Join(dir, "sub", "new.txt"))
+	require.NoError(t, err)
+
+	withPaths("sub", "")
+	assert.Equal(t, errnoIsdir, callGoWASI(t, w, mem, "path_unlink_file", 3, 0, 3), "unlink refuses directories")
+	assert.Equal(t, errnoNotempty, callGoWASI(t, w, mem, "path_remove_directory", 3, 0, 3))
+	withPaths("sub/new.txt", "")
+	require.Equal(t, errnoSuccess, callGoWASI(t, w, mem, "path_unlink_file", 3, 0, 11))
+	withPaths("sub", "")
+	require.Equal(t, errnoSuccess, callGoWASI(t, w, mem, "path_remove_directory", 3, 0, 3))
+	_, err = os.Stat(filepath.Join(dir, "sub"))
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	withPaths("out.txt", "")
+	require.Equal(t, errnoSuccess, callGoWASI(t, w, mem, "path_open", 3, 0, 0, 7, oflagCreat, rightFdWrite, 0, 0, 200))
+	fd := uint64(binary.LittleEndian.Uint32(mem.buf[200:]))
+	assert.Equal(t, errnoFault, callGoWASI(t, w, mem, "fd_pwrite", fd, 300, 0xffffffff, 0, 400), "huge iovec count")
+	require.Equal(t, errnoSuccess, callGoWASI(t, w, mem, "fd_close", fd))
+}
+
+func TestErrnoFor(t *testing.T) {
+	assert.Equal(t, errnoSuccess, errnoFor(nil))
+	assert.Equal(t, errnoNoent, errnoFor(&fs.PathError{Op: "open", Path: "x", Err: fs.ErrNotExist}))
+	assert.Equal(t, errnoExist, errnoFor(fs.ErrExist))
+	assert.Equal(t, errnoIO, errnoFor(errors.New("other")))
+}
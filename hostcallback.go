@@ -2,7 +2,7 @@ package wasmtime
 
 import (
 	"context"
-	"runtime"
+	"fmt"
 	"sync"
 	"unsafe"
 
@@ -10,55 +10,103 @@ import (
 	"github.com/rvigee/purego-wasmtime/api"
 )
 
-// hostFunctionRegistry manages Go host functions and their C callbacks
+// hostFunctionRegistry manages Go host functions called through the shared trampoline.
+// Each registered function is identified by the env pointer given to wasmtime.
 type hostFunctionRegistry struct {
 	mu        sync.RWMutex
 	functions map[uintptr]*registeredFunction
 	nextID    uintptr
+
+	// released keeps the bindings of unregistered ids, to build the trap of
+	// a late call with the library of the store receiving it. Wasmtime
+	// forgets the ids through hostFuncFinalizer once no linker or store can
+	// call them anymore.
+	released map[uintptr]*bindings
+
+	// fallback is the bindings of the most recent registration, for ids
+	// wasmtime should not know about
+	fallback *bindings
 }
 
 type registeredFunction struct {
 	id       uintptr
 	builder  *hostFunctionBuilder
-	callback uintptr // The C-callable function pointer from purego.NewCallback
-	storeCtx wasmtime_context_t
-	module   api.Module      // For GoModuleFunc
-	ctx      context.Context // Context for host function execution
+	ctx      context.Context // Fallback context when no call is in progress
 	bindings *bindings       // Bindings for C function calls
 }
 
 var globalRegistry = &hostFunctionRegistry{
 	functions: make(map[uintptr]*registeredFunction),
+	released:  make(map[uintptr]*bindings),
 	nextID:    1,
 }
 
+// hostCallbackTrampoline is the single C-callable pointer shared by all host
+// functions. purego can only create a limited number of callbacks per process
+// and never frees them, so it is created once and dispatches on env.
+var hostCallbackTrampoline = sync.OnceValue(func() uintptr {
+	return purego.NewCallback(hostCallbackWrapper)
+})
+
+// hostFuncFinalizer is given to wasmtime alongside each host function and
+// called with its env once wasmtime dropped it, so that the id is forgotten.
+var hostFuncFinalizer = sync.OnceValue(func() uintptr {
+	return purego.NewCallback(func(env uintptr) {
+		globalRegistry.forget(env)
+	})
+})
+
 // hostCallbackWrapper is the actual Go function that purego.NewCallback will wrap
 // It must match the C signature exactly
 func hostCallbackWrapper(env uintptr, caller uintptr, args *wasmtime_val_t, nargs uintptr, results *wasmtime_val_t, nresults uintptr) uintptr {
 	// Look up the registered function
 	globalRegistry.mu.RLock()
 	regFunc, ok := globalRegistry.functions[env]
+	b, released := globalRegistry.released[env]
+	if !released {
+		b = globalRegistry.fallback
+	}
 	globalRegistry.mu.RUnlock()
 
 	if !ok {
-		// Function not found - this shouldn't happen
-		return 0
+		// The host module or runtime was closed while an instance still
+		// imports this function: trap rather than return garbage results.
+		if b == nil {
+			// No library was ever registered, so no trap can be built
+			return 0
+		}
+		errMsg := fmt.Sprintf("host function %d was called after its host module was closed", env)
+		errBytes := []byte(errMsg + "\x00")
+		return uintptr(b.wasmtime_trap_new(&errBytes[0], uintptr(len(errMsg))))
 	}
 
+	callerCtx := regFunc.bindings.wasmtime_caller_context(caller)
+
 	// Convert C args to Go uint64 stack
-	stack := make([]uint64, nargs+nresults)
+	paramSlots := stackSlots(regFunc.builder.paramTypes)
+	stack := make([]uint64, paramSlots+stackSlots(regFunc.builder.resultTypes))
 
 	// Copy args to stack
+	slot := 0
 	for i := uintptr(0); i < nargs; i++ {
 		argPtr := (*wasmtime_val_t)(unsafe.Pointer(uintptr(unsafe.Pointer(args)) + i*unsafe.Sizeof(wasmtime_val_t{})))
-		stack[i] = convertWasmValueToUint64(argPtr)
+		slot += readStackValue(regFunc.bindings, callerCtx, stack[slot:], argPtr)
 	}
 
-	// Use the stored context from registration
+	// Prefer the context of the function.Call that entered the store,
+	// falling back to the one given at registration.
 	ctx := regFunc.ctx
+	state := regFunc.bindings.stateFromContext(callerCtx)
+	if state != nil && state.ctx != nil {
+		ctx = state.ctx
+	}
 	if ctx == nil {
 		ctx = context.Background()
 	}
+	// Report the memories the guest grew before the host function sees them
+	if state != nil && state.observer != nil {
+		state.checkMemoryGrowth(ctx, regFunc.bindings, callerCtx)
+	}
 
 	// Call the appropriate Go function and handle errors
 	var callErr error
@@ -66,43 +114,41 @@ func hostCallbackWrapper(env uintptr, caller uintptr, args *wasmtime_val_t, narg
 		regFunc.builder.goFunc(ctx, stack)
 	} else if regFunc.builder.goModuleFunc != nil {
 		// For GoModuleFunc, create a wrapper module that accesses exports from the caller
-		wrapperMod := &callerModule{caller: caller, store: regFunc.storeCtx, bindings: regFunc.bindings}
+		wrapperMod := &callerModule{caller: caller, store: callerCtx, bindings: regFunc.bindings, runtime: regFunc.builder.parent.runtime}
 		regFunc.builder.goModuleFunc(ctx, wrapperMod, stack)
 	} else if regFunc.builder.goFunction != nil {
-		paramSlice := stack[:nargs]
+		paramSlice := stack[:paramSlots]
 		resultSlice, err := regFunc.builder.goFunction.Call(ctx, paramSlice)
 		if err != nil {
 			callErr = err
 		} else {
-			for i, val := range resultSlice {
-				stack[nargs+uintptr(i)] = val
-			}
+			copy(stack[paramSlots:], resultSlice)
 		}
+	} else if regFunc.builder.reflectFunc != nil {
+		wrapperMod := &callerModule{caller: caller, store: callerCtx, bindings: regFunc.bindings, runtime: regFunc.builder.parent.runtime}
+		callErr = regFunc.builder.reflectFunc.call(ctx, wrapperMod, stack)
 	}
 
-	// If there was an error, create a trap
+	// If there was an error, return a trap carrying its message and keep
+	// the error itself for the TrapError of the call receiving the trap.
+	// Wasmtime takes ownership of the returned trap.
 	if callErr != nil {
-		// Create a trap with the error message
+		if state != nil {
+			state.hostErr = callErr
+		}
 		errMsg := callErr.Error()
 		errBytes := []byte(errMsg + "\x00")
-		trap := regFunc.bindings.wasm_trap_new(&errBytes[0], uintptr(len(errMsg)))
-		// Store trap pointer somewhere the C API can access it
-		// Since we can't modify the callback signature, we return a non-zero value
-		// However, this won't work properly with the current wasmtime callback API
-		// The proper way would be to use wasmtime_trap_t** parameter
-		// For now, we'll just return an error indicator
-		_ = trap // Keep the trap alive
-		return 1 // Return non-zero to indicate error occurred
+		return uintptr(regFunc.bindings.wasmtime_trap_new(&errBytes[0], uintptr(len(errMsg))))
 	}
 
 	// Copy results back
+	slot = paramSlots
 	for i := uintptr(0); i < nresults; i++ {
 		resultPtr := (*wasmtime_val_t)(unsafe.Pointer(uintptr(unsafe.Pointer(results)) + i*unsafe.Sizeof(wasmtime_val_t{})))
-		resultValue := stack[nargs+i]
 
 		// Determine the type from builder
 		valueType := regFunc.builder.resultTypes[i]
-		convertUint64ToWasmValue(resultValue, valueType, resultPtr)
+		slot += writeStackValue(regFunc.bindings, callerCtx, stack[slot:], valueType, resultPtr)
 	}
 
 	return 0 // No trap
@@ -119,13 +165,52 @@ func convertWasmValueToUint64(val *wasmtime_val_t) uint64 {
 		return uint64(EncodeF32(val.GetF32()))
 	case WASM_F64:
 		return EncodeF64(val.GetF64())
-	case WASM_EXTERNREF:
-		return uint64(val.GetExternRef())
 	default:
 		return 0
 	}
 }
 
+// readStackValue stores val, which lives in the store behind storeCtx, at
+// the start of stack and returns the number of slots it took: two for a
+// v128, low half first, and one otherwise.
+func readStackValue(b *bindings, storeCtx wasmtime_context_t, stack []uint64, val *wasmtime_val_t) int {
+	switch val.kind {
+	case WASM_V128:
+		stack[0] = *(*uint64)(unsafe.Pointer(&val.of.data[0]))
+		stack[1] = *(*uint64)(unsafe.Pointer(&val.of.data[8]))
+		return 2
+	case WASM_FUNCREF:
+		stack[0] = encodeFuncref(b, storeCtx, (*wasmtime_func_t)(unsafe.Pointer(&val.of.data[0])))
+	case WASM_EXTERNREF:
+		stack[0] = encodeExternref(b, storeCtx, val.AsExternRef())
+	default:
+		stack[0] = convertWasmValueToUint64(val)
+	}
+	return 1
+}
+
+// writeStackValue is the inverse of readStackValue. An externref written to
+// val is rooted and owned by the caller, who must either hand it over to
+// wasmtime or unroot it with unrootValue.
+func writeStackValue(b *bindings, storeCtx wasmtime_context_t, stack []uint64, valueType api.ValueType, val *wasmtime_val_t) int {
+	switch valueType {
+	case api.ValueTypeV128:
+		val.kind = WASM_V128
+		*(*uint64)(unsafe.Pointer(&val.of.data[0])) = stack[0]
+		*(*uint64)(unsafe.Pointer(&val.of.data[8])) = stack[1]
+		return 2
+	case api.ValueTypeFuncref:
+		val.kind = WASM_FUNCREF
+		decodeFuncref(b, storeCtx, stack[0], (*wasmtime_func_t)(unsafe.Pointer(&val.of.data[0])))
+	case api.ValueTypeExternref:
+		val.kind = WASM_EXTERNREF
+		decodeExternref(b, storeCtx, stack[0], val.AsExternRef())
+	default:
+		convertUint64ToWasmValue(stack[0], valueType, val)
+	}
+	return 1
+}
+
 // Helper to convert uint64 back to wasmtime_val_t
 func convertUint64ToWasmValue(value uint64, valueType api.ValueType, val *wasmtime_val_t) {
 	switch valueType {
@@ -141,9 +226,6 @@ func convertUint64ToWasmValue(value uint64, valueType api.ValueType, val *wasmti
 	case api.ValueTypeF64:
 		val.kind = WASM_F64
 		val.SetF64(DecodeF64(value))
-	case api.ValueTypeExternref:
-		val.kind = WASM_EXTERNREF
-		val.SetExternRef(DecodeExternref(value))
 	}
 }
 
@@ -186,8 +268,8 @@ func createFuncType(bindings *bindings, paramTypes, resultTypes []api.ValueType)
 	return funcType, cleanup
 }
 
-// apiValueTypeToWasm converts api.ValueType to wasm kind
-func apiValueTypeToWasm(vt api.ValueType) uint8 {
+// apiValueTypeToWasm converts api.ValueType to the kind of a wasm_valtype_t
+func apiValueTypeToWasm(vt api.ValueType) wasm_valkind_t {
 	switch vt {
 	case api.ValueTypeI32:
 		return WASM_I32
@@ -197,43 +279,56 @@ func apiValueTypeToWasm(vt api.ValueType) uint8 {
 		return WASM_F32
 	case api.ValueTypeF64:
 		return WASM_F64
+	case api.ValueTypeV128:
+		return WASM_V128
 	case api.ValueTypeFuncref:
-		return WASM_FUNCREF
+		return WASM_VALKIND_FUNCREF
 	case api.ValueTypeExternref:
-		return WASM_EXTERNREF
+		return WASM_VALKIND_EXTERNREF
 	default:
 		return WASM_I32
 	}
 }
 
-// registerHostFunction registers a Go function and returns its ID and callback pointer
-func (r *hostFunctionRegistry) register(builder *hostFunctionBuilder, storeCtx wasmtime_context_t, bindings *bindings, module api.Module, ctx context.Context) (uintptr, uintptr) {
+// register registers a Go function and returns its ID, to be passed as the
+// env pointer alongside hostCallbackTrampoline.
+func (r *hostFunctionRegistry) register(builder *hostFunctionBuilder, bindings *bindings, ctx context.Context) uintptr {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	id := r.nextID
 	r.nextID++
 
-	// Use purego.NewCallback to create a C-callable function pointer
-	// The callback function must match the wasmtime callback signature
-	callbackPtr := purego.NewCallback(hostCallbackWrapper)
-
 	r.functions[id] = &registeredFunction{
 		id:       id,
 		builder:  builder,
-		callback: callbackPtr,
-		storeCtx: storeCtx,
-		module:   module,
 		ctx:      ctx,
 		bindings: bindings,
 	}
+	r.fallback = bindings
 
-	// Prevent GC of the function
-	runtime.SetFinalizer(r.functions[id], func(rf *registeredFunction) {
-		r.mu.Lock()
-		delete(r.functions, rf.id)
-		r.mu.Unlock()
-	})
+	return id
+}
+
+// unregister releases the given IDs. Guests still importing one of them
+// trap when calling it instead of reaching freed Go state.
+func (r *hostFunctionRegistry) unregister(ids ...uintptr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, id := range ids {
+		if f, ok := r.functions[id]; ok {
+			r.released[id] = f.bindings
+			delete(r.functions, id)
+		}
+	}
+}
+
+// forget drops every trace of id, once wasmtime can no longer call it.
+func (r *hostFunctionRegistry) forget(id uintptr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	return id, callbackPtr
+	delete(r.functions, id)
+	delete(r.released, id)
 }
@@ -0,0 +1,231 @@
+package wasmtime
+
+import (
+	"fmt"
+
+	"github.com/rvigee/purego-wasmtime/api"
+)
+
+// HostGlobalBuilder provides a fluent API for defining host globals.
+type HostGlobalBuilder interface {
+	// WithMutable makes the global mutable, so importing modules may set it.
+	WithMutable() HostGlobalBuilder
+
+	// Export finalizes the global and exports it with the given name.
+	Export(name string)
+}
+
+// HostMemoryBuilder provides a fluent API for defining host memories.
+type HostMemoryBuilder interface {
+	// WithMaxPages limits how far the memory may grow, in 64KiB pages.
+	WithMaxPages(max uint32) HostMemoryBuilder
+
+	// WithMemory64 makes the memory indexed by i64, from the memory64
+	// proposal, which must be enabled with RuntimeConfig.WithMemory64.
+	WithMemory64() HostMemoryBuilder
+
+	// Export finalizes the memory and exports it with the given name.
+	Export(name string)
+}
+
+// HostTableBuilder provides a fluent API for defining host tables.
+type HostTableBuilder interface {
+	// WithMax limits how far the table may grow, in elements.
+	WithMax(max uint32) HostTableBuilder
+
+	// Export finalizes the table and exports it with the given name.
+	Export(name string)
+}
+
+// hostGlobalBuilder implements HostGlobalBuilder
+type hostGlobalBuilder struct {
+	parent  *hostModuleBuilder
+	name    string
+	valType api.ValueType
+	value   uint64
+	mutable bool
+}
+
+func (hgb *hostGlobalBuilder) WithMutable() HostGlobalBuilder {
+	hgb.mutable = true
+	return hgb
+}
+
+func (hgb *hostGlobalBuilder) Export(name string) {
+	hgb.name = name
+	hgb.parent.globals = append(hgb.parent.globals, hgb)
+}
+
+// hostMemoryBuilder implements HostMemoryBuilder
+type hostMemoryBuilder struct {
+	parent *hostModuleBuilder
+	name   string
+	min    uint32
+	max    uint32
+	hasMax bool
+	is64   bool
+}
+
+func (hmb *hostMemoryBuilder) WithMaxPages(max uint32) HostMemoryBuilder {
+	hmb.max = max
+	hmb.hasMax = true
+	return hmb
+}
+
+func (hmb *hostMemoryBuilder) WithMemory64() HostMemoryBuilder {
+	hmb.is64 = true
+	return hmb
+}
+
+func (hmb *hostMemoryBuilder) Export(name string) {
+	hmb.name = name
+	hmb.parent.memories = append(hmb.parent.memories, hmb)
+}
+
+// hostTableBuilder implements HostTableBuilder
+type hostTableBuilder struct {
+	parent   *hostModuleBuilder
+	name     string
+	elemType api.ValueType
+	min      uint32
+	max      uint32
+	hasMax   bool
+}
+
+func (htb *hostTableBuilder) WithMax(max uint32) HostTableBuilder {
+	htb.max = max
+	htb.hasMax = true
+	return htb
+}
+
+func (htb *hostTableBuilder) Export(name string) {
+	htb.name = name
+	htb.parent.tables = append(htb.parent.tables, htb)
+}
+
+func (hmb *hostModuleBuilder) NewGlobal(valType api.ValueType, value uint64) HostGlobalBuilder {
+	return &hostGlobalBuilder{
+		parent:  hmb,
+		valType: valType,
+		value:   value,
+	}
+}
+
+func (hmb *hostModuleBuilder) NewMemory(minPages uint32) HostMemoryBuilder {
+	return &hostMemoryBuilder{
+		parent: hmb,
+		min:    minPages,
+	}
+}
+
+// hostSharedMemory is a shared memory exported by a host module.
+type hostSharedMemory struct {
+	name string
+	mem  *SharedMemory
+}
+
+func (hmb *hostModuleBuilder) ExportSharedMemory(name string, mem *SharedMemory) {
+	hmb.shared = append(hmb.shared, hostSharedMemory{name: name, mem: mem})
+}
+
+func (hmb *hostModuleBuilder) NewTable(elemType api.ValueType, min uint32) HostTableBuilder {
+	return &hostTableBuilder{
+		parent:   hmb,
+		elemType: elemType,
+		min:      min,
+	}
+}
+
+// storeExtern is a host global, memory or table. Unlike functions, they
+// belong to a store, so each store instantiating modules gets its own,
+// created from the builder when the store links its first module.
+type storeExtern struct {
+	module string
+	name   string
+	kind   string // "global", "memory" or "table", for errors
+	create func(b *bindings, storeCtx wasmtime_context_t) (wasmtime_extern_t, error)
+	// runtimeExt is the extern in the runtime's store
+	runtimeExt wasmtime_extern_t
+}
+
+// storeExterns returns the globals, memories and tables of the module.
+func (hmb *hostModuleBuilder) storeExterns() []*storeExtern {
+	var externs []*storeExtern
+	for _, g := range hmb.globals {
+		externs = append(externs, &storeExtern{module: hmb.moduleName, name: g.name, kind: "global", create: g.newGlobal})
+	}
+	for _, m := range hmb.memories {
+		externs = append(externs, &storeExtern{module: hmb.moduleName, name: m.name, kind: "memory", create: m.newMemory})
+	}
+	for _, t := range hmb.tables {
+		externs = append(externs, &storeExtern{module: hmb.moduleName, name: t.name, kind: "table", create: t.newTable})
+	}
+	return externs
+}
+
+// newGlobal creates the global in the store behind storeCtx.
+func (hgb *hostGlobalBuilder) newGlobal(b *bindings, storeCtx wasmtime_context_t) (wasmtime_extern_t, error) {
+	var ext wasmtime_extern_t
+
+	mutability := uint8(WASM_CONST)
+	if hgb.mutable {
+		mutability = WASM_VAR
+	}
+	// The global type takes ownership of the value type
+	globalType := b.wasm_globaltype_new(b.wasm_valtype_new(apiValueTypeToWasm(hgb.valType)), mutability)
+	defer b.wasm_globaltype_delete(globalType)
+
+	// A v128 global is initialized from the low half only
+	var val wasmtime_val_t
+	writeStackValue(b, storeCtx, []uint64{hgb.value, 0}, hgb.valType, &val)
+	defer unrootValue(b, &val)
+
+	ext.kind = WASMTIME_EXTERN_GLOBAL
+	if err := b.wasmtime_global_new(storeCtx, globalType, &val, ext.AsGlobal()); err != 0 {
+		return ext, b.getErrorMessage(err, 0)
+	}
+	return ext, nil
+}
+
+// newMemory creates the memory in the store behind storeCtx.
+func (hmb *hostMemoryBuilder) newMemory(b *bindings, storeCtx wasmtime_context_t) (wasmtime_extern_t, error) {
+	var ext wasmtime_extern_t
+
+	memoryType := b.wasmtime_memorytype_new(uint64(hmb.min), hmb.hasMax, uint64(hmb.max), hmb.is64, false)
+	defer b.wasm_memorytype_delete(memoryType)
+
+	ext.kind = WASMTIME_EXTERN_MEMORY
+	if err := b.wasmtime_memory_new(storeCtx, memoryType, ext.AsMemory()); err != 0 {
+		return ext, b.getErrorMessage(err, 0)
+	}
+	return ext, nil
+}
+
+// newTable creates the table in the store behind storeCtx, filled with nulls.
+func (htb *hostTableBuilder) newTable(b *bindings, storeCtx wasmtime_context_t) (wasmtime_extern_t, error) {
+	var ext wasmtime_extern_t
+
+	if htb.elemType != api.ValueTypeFuncref && htb.elemType != api.ValueTypeExternref {
+		return ext, fmt.Errorf("unsupported table element type: %v", htb.elemType)
+	}
+
+	limits := wasm_limits_t{min: htb.min, max: wasm_limits_max_default}
+	if htb.hasMax {
+		limits.max = htb.max
+	}
+	// The table type takes ownership of the value type
+	tableType := b.wasm_tabletype_new(b.wasm_valtype_new(apiValueTypeToWasm(htb.elemType)), &limits)
+	defer b.wasm_tabletype_delete(tableType)
+
+	var init wasmtime_val_t
+	init.kind = WASM_FUNCREF
+	if htb.elemType == api.ValueTypeExternref {
+		init.kind = WASM_EXTERNREF
+	}
+
+	ext.kind = WASMTIME_EXTERN_TABLE
+	if err := b.wasmtime_table_new(storeCtx, tableType, &init, ext.AsTable()); err != 0 {
+		return ext, b.getErrorMessage(err, 0)
+	}
+	return ext, nil
+}
@@ -3,6 +3,7 @@ package wasmtime
 import (
 	"context"
 	"fmt"
+	"runtime"
 
 	"github.com/rvigee/purego-wasmtime/api"
 )
@@ -10,7 +11,11 @@ import (
 // GoFunc is a host function callable from WebAssembly.
 // It receives a context and a stack of parameters/results encoded as uint64.
 // The function should read parameters from the beginning of the stack
-// and write results starting at index len(params).
+// and write results starting right after them. A v128 value takes two
+// stack slots, low half first, and any other value takes one.
+//
+// The context is the one passed to the api.Function.Call that entered the
+// guest, so request-scoped values, deadlines and cancellation reach host code.
 //
 // This matches wazero's api.GoFunc type.
 type GoFunc func(ctx context.Context, stack []uint64)
@@ -41,6 +46,17 @@ type HostFunctionBuilder interface {
 	// WithGoFunction sets the function implementation as a GoFunction.
 	WithGoFunction(fn GoFunction) HostFunctionBuilder
 
+	// WithFunc sets the function implementation from an ordinary Go func,
+	// inferring the Wasm signature from its parameter and result types.
+	//
+	// Supported value types are int32, uint32, int64, uint64, float32 and
+	// float64. The func may take a context.Context and then an api.Module as
+	// leading parameters, and may return a trailing error which traps the
+	// calling guest. The inferred signature is checked against the types given
+	// to NewFunctionBuilder when the function is exported; pass nil types to
+	// use the inferred ones. A mismatch is reported by HostModuleBuilder.Instantiate.
+	WithFunc(fn any) HostFunctionBuilder
+
 	// WithParameterNames sets the parameter names (optional, for debugging).
 	WithParameterNames(names ...string) HostFunctionBuilder
 
@@ -52,12 +68,35 @@ type HostFunctionBuilder interface {
 }
 
 // HostModuleBuilder provides a fluent API for creating host modules.
-// Host modules contain functions, globals, memories defined in Go.
+// Host modules contain functions, globals, memories and tables defined in Go.
+//
+// Functions are shared by every instance of the runtime. Globals, memories
+// and tables belong to a store: instances from Instantiate share those of
+// the runtime's store, while instances with a store of their own, such as
+// from InstantiateModule or InstantiateWithWASI, each get fresh ones with
+// their initial value.
 type HostModuleBuilder interface {
 	// NewFunctionBuilder creates a new function with the given name and signature.
 	// paramTypes and resultTypes should use api.ValueType constants.
 	NewFunctionBuilder(name string, paramTypes, resultTypes []api.ValueType) HostFunctionBuilder
 
+	// NewGlobal creates a new global of the given type, initialized to the
+	// encoded value. The global is immutable unless WithMutable is called.
+	NewGlobal(valType api.ValueType, value uint64) HostGlobalBuilder
+
+	// NewMemory creates a new memory of at least minPages 64KiB pages.
+	// This satisfies guests importing their memory, such as env.memory.
+	NewMemory(minPages uint32) HostMemoryBuilder
+
+	// NewTable creates a new table of at least min null elements.
+	// elemType must be api.ValueTypeFuncref or api.ValueTypeExternref.
+	NewTable(elemType api.ValueType, min uint32) HostTableBuilder
+
+	// ExportSharedMemory exports a shared memory created with
+	// Runtime.NewSharedMemory. Unlike other host memories, it can be imported
+	// by instances in any store of the runtime.
+	ExportSharedMemory(name string, mem *SharedMemory)
+
 	// Instantiate creates the host module and makes it available for imports.
 	// The module name should match the import name in the WASM module.
 	Instantiate(ctx context.Context) error
@@ -75,14 +114,18 @@ type hostFunctionBuilder struct {
 	goFunc       GoFunc
 	goModuleFunc GoModuleFunc
 	goFunction   GoFunction
+	fn           any
+	reflectFunc  *reflectFunc
 	paramNames   []string
 	resultNames  []string
+	err          error // Deferred Export error, reported by Instantiate
 }
 
 func (hfb *hostFunctionBuilder) WithGoFunc(fn GoFunc) HostFunctionBuilder {
 	hfb.goFunc = fn
 	hfb.goModuleFunc = nil
 	hfb.goFunction = nil
+	hfb.fn = nil
 	return hfb
 }
 
@@ -90,6 +133,7 @@ func (hfb *hostFunctionBuilder) WithGoModuleFunc(fn GoModuleFunc) HostFunctionBu
 	hfb.goModuleFunc = fn
 	hfb.goFunc = nil
 	hfb.goFunction = nil
+	hfb.fn = nil
 	return hfb
 }
 
@@ -97,6 +141,15 @@ func (hfb *hostFunctionBuilder) WithGoFunction(fn GoFunction) HostFunctionBuilde
 	hfb.goFunction = fn
 	hfb.goFunc = nil
 	hfb.goModuleFunc = nil
+	hfb.fn = nil
+	return hfb
+}
+
+func (hfb *hostFunctionBuilder) WithFunc(fn any) HostFunctionBuilder {
+	hfb.fn = fn
+	hfb.goFunc = nil
+	hfb.goModuleFunc = nil
+	hfb.goFunction = nil
 	return hfb
 }
 
@@ -112,6 +165,18 @@ func (hfb *hostFunctionBuilder) WithResultNames(names ...string) HostFunctionBui
 
 func (hfb *hostFunctionBuilder) Export(name string) {
 	hfb.name = name
+	if hfb.fn != nil {
+		hfb.reflectFunc, hfb.err = newReflectFunc(hfb.fn)
+		if hfb.err == nil {
+			hfb.err = hfb.reflectFunc.validate(hfb.paramTypes, hfb.resultTypes)
+		}
+		if hfb.err != nil {
+			hfb.err = fmt.Errorf("host function %s::%s: %w", hfb.parent.moduleName, name, hfb.err)
+		} else {
+			hfb.paramTypes = hfb.reflectFunc.paramTypes
+			hfb.resultTypes = hfb.reflectFunc.resultTypes
+		}
+	}
 	hfb.parent.addFunction(hfb)
 }
 
@@ -119,6 +184,12 @@ func (hfb *hostFunctionBuilder) Export(name string) {
 type hostModuleBuilder struct {
 	moduleName string
 	functions  []*hostFunctionBuilder
+	globals    []*hostGlobalBuilder
+	memories   []*hostMemoryBuilder
+	tables     []*hostTableBuilder
+	shared     []hostSharedMemory
+	externs    []*storeExtern // Instantiated globals, memories and tables
+	funcIDs    []uintptr      // Registry IDs of instantiated functions
 	runtime    *wasmRuntime
 	linker     wasmtime_linker_t
 }
@@ -141,61 +212,118 @@ func (hmb *hostModuleBuilder) Instantiate(ctx context.Context) error {
 		return fmt.Errorf("host module builder has no associated runtime")
 	}
 
+	hmb.runtime.linkMu.Lock()
+	defer hmb.runtime.linkMu.Unlock()
+
+	// Report invalid exports before defining anything
+	for _, fn := range hmb.functions {
+		if fn.err != nil {
+			return fn.err
+		}
+	}
+
 	storeCtx := hmb.runtime.bindings.wasmtime_store_context(hmb.runtime.store)
+	hmb.runtime.trackHostModule(hmb)
 
-	// Register each function with the linker
+	// Define each function in the linker itself rather than in a store, so
+	// that it can be imported by instances in any store of the runtime
 	for _, fn := range hmb.functions {
 		// Create function type
 		funcType, cleanup := createFuncType(hmb.runtime.bindings, fn.paramTypes, fn.resultTypes)
 		defer cleanup()
 
-		// Register function in global registry
-		funcID, callbackPtr := globalRegistry.register(fn, storeCtx, hmb.runtime.bindings, nil, ctx)
+		// Register function in global registry; the ID is released on Close
+		funcID := globalRegistry.register(fn, hmb.runtime.bindings, ctx)
+		hmb.funcIDs = append(hmb.funcIDs, funcID)
 
-		// Get callback pointer
+		if err := hmb.defineFunc(fn.name, funcType, funcID); err != nil {
+			return fmt.Errorf("failed to define host function %s::%s: %w", hmb.moduleName, fn.name, err)
+		}
+	}
 
-		// Create wasmtime function
-		var wasmFunc wasmtime_func_t
-		hmb.runtime.bindings.wasmtime_func_new(
-			storeCtx,
-			funcType,
-			callbackPtr,
-			funcID, // env pointer (our function ID)
-			0,      // finalizer
-			&wasmFunc,
-		)
+	// Globals, memories and tables are created in the runtime's store here,
+	// and again in each store linking a module, see wasmRuntime.link
+	for _, e := range hmb.storeExterns() {
+		ext, err := e.create(hmb.runtime.bindings, storeCtx)
+		if err == nil {
+			err = hmb.define(storeCtx, e.name, &ext)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to define host %s %s::%s: %w", e.kind, hmb.moduleName, e.name, err)
+		}
+		if ext.kind == WASMTIME_EXTERN_MEMORY {
+			hmb.runtime.storeState.watchMemory(hmb.runtime.bindings, storeCtx, nil, hmb.moduleName, e.name, *ext.AsMemory())
+		}
+		e.runtimeExt = ext
+		hmb.externs = append(hmb.externs, e)
+	}
 
-		// Create extern from function
+	// Shared memories belong to no store, and the linker keeps its own handle
+	for _, m := range hmb.shared {
 		var ext wasmtime_extern_t
-		ext.kind = WASMTIME_EXTERN_FUNC
-		funcPtr := ext.AsFunc()
-		*funcPtr = wasmFunc
-
-		// Define in linker
-		moduleBytes := []byte(hmb.moduleName + "\000")
-		nameBytes := []byte(fn.name + "\000")
-
-		err := hmb.runtime.bindings.wasmtime_linker_define(
-			hmb.linker,
-			storeCtx, // Add the missing store context
-			&moduleBytes[0],
-			uintptr(len(hmb.moduleName)),
-			&nameBytes[0],
-			uintptr(len(fn.name)),
-			&ext,
-		)
-
-		if err != 0 {
-			hmb.runtime.bindings.wasmtime_error_delete(err)
-			return fmt.Errorf("failed to define host function %s::%s", hmb.moduleName, fn.name)
+		ext.kind = WASMTIME_EXTERN_SHAREDMEMORY
+		*ext.AsSharedMemory() = m.mem.ptr
+		err := hmb.define(storeCtx, m.name, &ext)
+		runtime.KeepAlive(m.mem)
+		if err != nil {
+			return fmt.Errorf("failed to define host shared memory %s::%s: %w", hmb.moduleName, m.name, err)
 		}
 	}
 
 	return nil
 }
 
+// define adds ext to the linker under this module's name.
+func (hmb *hostModuleBuilder) define(storeCtx wasmtime_context_t, name string, ext *wasmtime_extern_t) error {
+	moduleBytes := []byte(hmb.moduleName + "\000")
+	nameBytes := []byte(name + "\000")
+
+	err := hmb.runtime.bindings.wasmtime_linker_define(
+		hmb.linker,
+		storeCtx,
+		&moduleBytes[0],
+		uintptr(len(hmb.moduleName)),
+		&nameBytes[0],
+		uintptr(len(name)),
+		ext,
+	)
+	if err != 0 {
+		return hmb.runtime.bindings.getErrorMessage(err, 0)
+	}
+	return nil
+}
+
+// defineFunc adds a host function dispatching to funcID to the linker under
+// this module's name.
+func (hmb *hostModuleBuilder) defineFunc(name string, funcType wasm_functype_t, funcID uintptr) error {
+	moduleBytes := []byte(hmb.moduleName + "\000")
+	nameBytes := []byte(name + "\000")
+
+	err := hmb.runtime.bindings.wasmtime_linker_define_func(
+		hmb.linker,
+		&moduleBytes[0],
+		uintptr(len(hmb.moduleName)),
+		&nameBytes[0],
+		uintptr(len(name)),
+		funcType,
+		hostCallbackTrampoline(),
+		funcID, // env pointer (our function ID)
+		hostFuncFinalizer(),
+	)
+	if err != 0 {
+		return hmb.runtime.bindings.getErrorMessage(err, 0)
+	}
+	return nil
+}
+
+// Close releases the Go functions of this host module. Instances still
+// importing them trap if they call them afterwards.
 func (hmb *hostModuleBuilder) Close(ctx context.Context) error {
-	// Clean up resources
+	globalRegistry.unregister(hmb.funcIDs...)
+	hmb.funcIDs = nil
+	if hmb.runtime != nil {
+		hmb.runtime.untrackHostModule(hmb)
+	}
 	return nil
 }
 
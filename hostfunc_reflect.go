@@ -0,0 +1,181 @@
+package wasmtime
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/rvigee/purego-wasmtime/api"
+)
+
+var (
+	contextType = reflect.TypeFor[context.Context]()
+	moduleType  = reflect.TypeFor[api.Module]()
+	errorType   = reflect.TypeFor[error]()
+)
+
+// reflectFunc adapts an ordinary Go func to the uint64 stack calling convention.
+type reflectFunc struct {
+	fn          reflect.Value
+	withContext bool
+	withModule  bool
+	withError   bool
+	paramKinds  []reflect.Kind
+	paramGoType []reflect.Type
+	resultKinds []reflect.Kind
+	paramTypes  []api.ValueType
+	resultTypes []api.ValueType
+}
+
+// newReflectFunc inspects fn and infers its Wasm signature.
+//
+// Supported parameters are an optional leading context.Context, an optional
+// api.Module after it, then any number of int32, uint32, int64, uint64,
+// float32 or float64 values. Results are any number of those value types
+// optionally followed by an error, which is turned into a trap.
+func newReflectFunc(fn any) (*reflectFunc, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("expected a func, got %T", fn)
+	}
+	t := v.Type()
+	if t.IsVariadic() {
+		return nil, fmt.Errorf("variadic funcs are not supported: %s", t)
+	}
+
+	rf := &reflectFunc{fn: v}
+
+	in := 0
+	if in < t.NumIn() && t.In(in) == contextType {
+		rf.withContext = true
+		in++
+	}
+	if in < t.NumIn() && t.In(in) == moduleType {
+		rf.withModule = true
+		in++
+	}
+	for ; in < t.NumIn(); in++ {
+		vt, err := goTypeToValueType(t.In(in))
+		if err != nil {
+			return nil, fmt.Errorf("param %d: %w", in, err)
+		}
+		rf.paramKinds = append(rf.paramKinds, t.In(in).Kind())
+		rf.paramGoType = append(rf.paramGoType, t.In(in))
+		rf.paramTypes = append(rf.paramTypes, vt)
+	}
+
+	out := t.NumOut()
+	if out > 0 && t.Out(out-1) == errorType {
+		rf.withError = true
+		out--
+	}
+	for i := 0; i < out; i++ {
+		vt, err := goTypeToValueType(t.Out(i))
+		if err != nil {
+			return nil, fmt.Errorf("result %d: %w", i, err)
+		}
+		rf.resultKinds = append(rf.resultKinds, t.Out(i).Kind())
+		rf.resultTypes = append(rf.resultTypes, vt)
+	}
+
+	return rf, nil
+}
+
+// goTypeToValueType maps a Go value type to its Wasm value type.
+func goTypeToValueType(t reflect.Type) (api.ValueType, error) {
+	switch t.Kind() {
+	case reflect.Int32, reflect.Uint32:
+		return api.ValueTypeI32, nil
+	case reflect.Int64, reflect.Uint64:
+		return api.ValueTypeI64, nil
+	case reflect.Float32:
+		return api.ValueTypeF32, nil
+	case reflect.Float64:
+		return api.ValueTypeF64, nil
+	default:
+		return 0, fmt.Errorf("unsupported type %s", t)
+	}
+}
+
+// validate checks the inferred signature against declared types.
+// Nil declared types are accepted as-is and replaced by the inferred ones.
+func (rf *reflectFunc) validate(paramTypes, resultTypes []api.ValueType) error {
+	if paramTypes != nil && !equalValueTypes(paramTypes, rf.paramTypes) {
+		return fmt.Errorf("param types %v do not match func params %v", paramTypes, rf.paramTypes)
+	}
+	if resultTypes != nil && !equalValueTypes(resultTypes, rf.resultTypes) {
+		return fmt.Errorf("result types %v do not match func results %v", resultTypes, rf.resultTypes)
+	}
+	return nil
+}
+
+func equalValueTypes(a, b []api.ValueType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// call decodes the params from stack, invokes the func and encodes its results
+// onto stack after the params. A non-nil error returned by the func is passed through.
+func (rf *reflectFunc) call(ctx context.Context, mod api.Module, stack []uint64) error {
+	args := make([]reflect.Value, 0, len(rf.paramKinds)+2)
+	if rf.withContext {
+		args = append(args, reflect.ValueOf(&ctx).Elem())
+	}
+	if rf.withModule {
+		args = append(args, reflect.ValueOf(&mod).Elem())
+	}
+	for i, kind := range rf.paramKinds {
+		arg := reflect.New(rf.paramGoType[i]).Elem()
+		switch kind {
+		case reflect.Int32:
+			arg.SetInt(int64(DecodeI32(stack[i])))
+		case reflect.Uint32:
+			arg.SetUint(uint64(DecodeU32(stack[i])))
+		case reflect.Int64:
+			arg.SetInt(DecodeI64(stack[i]))
+		case reflect.Uint64:
+			arg.SetUint(stack[i])
+		case reflect.Float32:
+			arg.SetFloat(float64(DecodeF32(stack[i])))
+		case reflect.Float64:
+			arg.SetFloat(DecodeF64(stack[i]))
+		}
+		args = append(args, arg)
+	}
+
+	out := rf.fn.Call(args)
+
+	if rf.withError {
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			return err
+		}
+	}
+
+	// Results follow the params, matching the GoFunc stack layout
+	n := len(rf.paramKinds)
+	for i, kind := range rf.resultKinds {
+		switch kind {
+		case reflect.Int32:
+			stack[n+i] = EncodeI32(int32(out[i].Int()))
+		case reflect.Uint32:
+			stack[n+i] = EncodeU32(uint32(out[i].Uint()))
+		case reflect.Int64:
+			stack[n+i] = EncodeI64(out[i].Int())
+		case reflect.Uint64:
+			stack[n+i] = out[i].Uint()
+		case reflect.Float32:
+			stack[n+i] = EncodeF32(float32(out[i].Float()))
+		case reflect.Float64:
+			stack[n+i] = EncodeF64(out[i].Float())
+		}
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package wasmtime
 
 import (
 	"context"
+	"fmt"
 	"testing"
 
 	"github.com/rvigee/purego-wasmtime/api"
@@ -378,3 +379,423 @@ func TestHostFunctionWithMemoryAccess(t *testing.T) {
 
 	assert.Equal(t, int32(16), DecodeI32(results[0]))
 }
+
+func TestHostFunctionCallContext(t *testing.T) {
+
+	r, err := NewRuntime(t.Context())
+	require.NoError(t, err)
+	defer r.Close(t.Context())
+
+	type ctxKey struct{}
+
+	var seen []any
+	hostModule := r.NewHostModuleBuilder("env")
+	hostModule.NewFunctionBuilder("record",
+		[]api.ValueType{},
+		[]api.ValueType{},
+	).WithGoFunc(func(ctx context.Context, stack []uint64) {
+		seen = append(seen, ctx.Value(ctxKey{}))
+	}).Export("record")
+
+	// Instantiate with a context that carries no value
+	require.NoError(t, hostModule.Instantiate(t.Context()))
+	defer hostModule.Close(t.Context())
+
+	wat := `(module
+		(import "env" "record" (func $record))
+		(func (export "run") (call $record))
+	)`
+
+	compiled, err := r.CompileModule(t.Context(), []byte(wat))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.Instantiate(t.Context(), compiled)
+	require.NoError(t, err)
+	defer mod.Close(t.Context())
+
+	fn := mod.ExportedFunction("run")
+	require.NotNil(t, fn)
+
+	// Each call's context must reach the host function
+	_, err = fn.Call(context.WithValue(t.Context(), ctxKey{}, "first"))
+	require.NoError(t, err)
+	_, err = fn.Call(context.WithValue(t.Context(), ctxKey{}, "second"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []any{"first", "second"}, seen)
+}
+
+func TestReflectFuncSignature(t *testing.T) {
+	tests := []struct {
+		name        string
+		fn          any
+		paramTypes  []api.ValueType
+		resultTypes []api.ValueType
+		withContext bool
+		withModule  bool
+		withError   bool
+	}{
+		{
+			name:        "plain",
+			fn:          func(a, b int32) int32 { return a + b },
+			paramTypes:  []api.ValueType{api.ValueTypeI32, api.ValueTypeI32},
+			resultTypes: []api.ValueType{api.ValueTypeI32},
+		},
+		{
+			name:        "context_module_error",
+			fn:          func(context.Context, api.Module, uint64, float32) (float64, error) { return 0, nil },
+			paramTypes:  []api.ValueType{api.ValueTypeI64, api.ValueTypeF32},
+			resultTypes: []api.ValueType{api.ValueTypeF64},
+			withContext: true,
+			withModule:  true,
+			withError:   true,
+		},
+		{
+			name:        "module_only_no_results",
+			fn:          func(api.Module, uint32) {},
+			paramTypes:  []api.ValueType{api.ValueTypeI32},
+			resultTypes: nil,
+			withModule:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rf, err := newReflectFunc(tt.fn)
+			require.NoError(t, err)
+			assert.Equal(t, tt.paramTypes, rf.paramTypes)
+			assert.Equal(t, tt.resultTypes, rf.resultTypes)
+			assert.Equal(t, tt.withContext, rf.withContext)
+			assert.Equal(t, tt.withModule, rf.withModule)
+			assert.Equal(t, tt.withError, rf.withError)
+		})
+	}
+
+	t.Run("unsupported_types", func(t *testing.T) {
+		_, err := newReflectFunc(func(string) {})
+		assert.Error(t, err)
+		_, err = newReflectFunc(func() int { return 0 })
+		assert.Error(t, err)
+		_, err = newReflectFunc(42)
+		assert.Error(t, err)
+	})
+
+	t.Run("call_encodes_stack", func(t *testing.T) {
+		rf, err := newReflectFunc(func(a int32, b float64) (int64, float32) {
+			return int64(a) * 2, float32(b) / 2
+		})
+		require.NoError(t, err)
+
+		stack := []uint64{EncodeI32(-21), EncodeF64(3), 0, 0}
+		require.NoError(t, rf.call(context.Background(), nil, stack))
+		assert.Equal(t, int64(-42), DecodeI64(stack[2]))
+		assert.Equal(t, float32(1.5), DecodeF32(stack[3]))
+	})
+}
+
+func TestHostFunctionWithFunc(t *testing.T) {
+
+	r, err := NewRuntime(t.Context())
+	require.NoError(t, err)
+	defer r.Close(t.Context())
+
+	t.Run("signature_mismatch", func(t *testing.T) {
+		hostModule := r.NewHostModuleBuilder("bad")
+		hostModule.NewFunctionBuilder("add",
+			[]api.ValueType{api.ValueTypeI64, api.ValueTypeI64},
+			[]api.ValueType{api.ValueTypeI64},
+		).WithFunc(func(a, b int32) int32 { return a + b }).Export("add")
+
+		assert.Error(t, hostModule.Instantiate(t.Context()))
+	})
+
+	hostModule := r.NewHostModuleBuilder("env")
+	hostModule.NewFunctionBuilder("add", nil, nil).
+		WithFunc(func(a, b int32) int32 { return a + b }).
+		Export("add")
+	hostModule.NewFunctionBuilder("div",
+		[]api.ValueType{api.ValueTypeI32, api.ValueTypeI32},
+		[]api.ValueType{api.ValueTypeI32},
+	).WithFunc(func(ctx context.Context, a, b int32) (int32, error) {
+		if b == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return a / b, nil
+	}).Export("div")
+	require.NoError(t, hostModule.Instantiate(t.Context()))
+	defer hostModule.Close(t.Context())
+
+	wat := `(module
+		(import "env" "add" (func $add (param i32 i32) (result i32)))
+		(import "env" "div" (func $div (param i32 i32) (result i32)))
+		(func (export "add") (param i32 i32) (result i32)
+			(call $add (local.get 0) (local.get 1)))
+		(func (export "div") (param i32 i32) (result i32)
+			(call $div (local.get 0) (local.get 1)))
+	)`
+
+	compiled, err := r.CompileModule(t.Context(), []byte(wat))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.Instantiate(t.Context(), compiled)
+	require.NoError(t, err)
+	defer mod.Close(t.Context())
+
+	results, err := mod.ExportedFunction("add").Call(t.Context(), EncodeI32(40), EncodeI32(2))
+	require.NoError(t, err)
+	assert.Equal(t, int32(42), DecodeI32(results[0]))
+
+	results, err = mod.ExportedFunction("div").Call(t.Context(), EncodeI32(84), EncodeI32(2))
+	require.NoError(t, err)
+	assert.Equal(t, int32(42), DecodeI32(results[0]))
+
+	_, err = mod.ExportedFunction("div").Call(t.Context(), EncodeI32(1), EncodeI32(0))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "division by zero")
+}
+
+func TestHostFunctionReentrantCalls(t *testing.T) {
+
+	r, err := NewRuntime(t.Context())
+	require.NoError(t, err)
+	defer r.Close(t.Context())
+
+	type ctxKey struct{}
+
+	hostModule := r.NewHostModuleBuilder("env")
+
+	// recurse calls back into the guest's "down" export, which calls recurse
+	// again until the counter reaches zero.
+	hostModule.NewFunctionBuilder("recurse",
+		[]api.ValueType{api.ValueTypeI32},
+		[]api.ValueType{api.ValueTypeI32},
+	).WithGoModuleFunc(func(ctx context.Context, mod api.Module, stack []uint64) {
+		require.Equal(t, "deep", ctx.Value(ctxKey{}))
+
+		down := mod.ExportedFunction("down")
+		require.NotNil(t, down)
+
+		results, err := down.Call(ctx, stack[0])
+		require.NoError(t, err)
+		stack[1] = results[0]
+	}).Export("recurse")
+
+	// greet asks the guest to allocate a buffer, then fills it
+	hostModule.NewFunctionBuilder("greet",
+		[]api.ValueType{},
+		[]api.ValueType{api.ValueTypeI32},
+	).WithGoModuleFunc(func(ctx context.Context, mod api.Module, stack []uint64) {
+		greeting := "hello"
+
+		results, err := mod.ExportedFunction("malloc").Call(ctx, EncodeI32(int32(len(greeting))))
+		require.NoError(t, err)
+		ptr := DecodeI32(results[0])
+
+		mem := mod.ExportedMemory("memory")
+		data := (*[1 << 30]byte)(mem.Data(ctx))
+		copy(data[ptr:], greeting)
+
+		stack[0] = EncodeI32(ptr)
+	}).Export("greet")
+
+	require.NoError(t, hostModule.Instantiate(t.Context()))
+	defer hostModule.Close(t.Context())
+
+	wat := `(module
+		(import "env" "recurse" (func $recurse (param i32) (result i32)))
+		(import "env" "greet" (func $greet (result i32)))
+		(memory (export "memory") 1)
+		(global $heap (mut i32) (i32.const 1024))
+
+		(func (export "malloc") (param $size i32) (result i32)
+			(local $ptr i32)
+			(local.set $ptr (global.get $heap))
+			(global.set $heap (i32.add (global.get $heap) (local.get $size)))
+			(local.get $ptr))
+
+		(func (export "down") (param $n i32) (result i32)
+			(if (result i32) (i32.eqz (local.get $n))
+				(then (i32.const 0))
+				(else (i32.add (i32.const 1)
+					(call $recurse (i32.sub (local.get $n) (i32.const 1)))))))
+
+		(func (export "greet") (result i32)
+			(call $greet))
+	)`
+
+	compiled, err := r.CompileModule(t.Context(), []byte(wat))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.Instantiate(t.Context(), compiled)
+	require.NoError(t, err)
+	defer mod.Close(t.Context())
+
+	t.Run("deep_recursion", func(t *testing.T) {
+		ctx := context.WithValue(t.Context(), ctxKey{}, "deep")
+
+		results, err := mod.ExportedFunction("down").Call(ctx, EncodeI32(100))
+		require.NoError(t, err)
+		assert.Equal(t, int32(100), DecodeI32(results[0]))
+	})
+
+	t.Run("guest_allocation", func(t *testing.T) {
+		results, err := mod.ExportedFunction("greet").Call(t.Context())
+		require.NoError(t, err)
+
+		ptr := DecodeI32(results[0])
+		assert.Equal(t, int32(1024), ptr)
+
+		data := (*[1 << 30]byte)(mod.ExportedMemory("memory").Data(t.Context()))
+		assert.Equal(t, "hello", string(data[ptr:ptr+5]))
+	})
+}
+
+func TestHostModuleExterns(t *testing.T) {
+
+	r, err := NewRuntime(t.Context())
+	require.NoError(t, err)
+	defer r.Close(t.Context())
+
+	hostModule := r.NewHostModuleBuilder("env")
+	hostModule.NewMemory(1).WithMaxPages(4).Export("memory")
+	hostModule.NewGlobal(api.ValueTypeI32, EncodeI32(4096)).WithMutable().Export("__stack_pointer")
+	hostModule.NewGlobal(api.ValueTypeF64, EncodeF64(2.5)).Export("scale")
+	hostModule.NewTable(api.ValueTypeFuncref, 2).Export("__indirect_function_table")
+	require.NoError(t, hostModule.Instantiate(t.Context()))
+	defer hostModule.Close(t.Context())
+
+	wat := `(module
+		(import "env" "memory" (memory 1 4))
+		(import "env" "__stack_pointer" (global $sp (mut i32)))
+		(import "env" "scale" (global $scale f64))
+		(import "env" "__indirect_function_table" (table 2 funcref))
+
+		(func $seven (result i32) (i32.const 7))
+		(elem (i32.const 1) $seven)
+
+		(func (export "push") (param i32) (result i32)
+			(global.set $sp (i32.sub (global.get $sp) (i32.const 4)))
+			(i32.store (global.get $sp) (local.get 0))
+			(global.get $sp))
+		(func (export "scaled") (param f64) (result f64)
+			(f64.mul (local.get 0) (global.get $scale)))
+		(func (export "indirect") (result i32)
+			(call_indirect (result i32) (i32.const 1)))
+		(func (export "pages") (result i32)
+			(memory.size))
+	)`
+
+	compiled, err := r.CompileModule(t.Context(), []byte(wat))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.Instantiate(t.Context(), compiled)
+	require.NoError(t, err)
+	defer mod.Close(t.Context())
+
+	results, err := mod.ExportedFunction("push").Call(t.Context(), EncodeI32(99))
+	require.NoError(t, err)
+	assert.Equal(t, int32(4092), DecodeI32(results[0]))
+
+	results, err = mod.ExportedFunction("scaled").Call(t.Context(), EncodeF64(4))
+	require.NoError(t, err)
+	assert.Equal(t, 10.0, DecodeF64(results[0]))
+
+	results, err = mod.ExportedFunction("indirect").Call(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, int32(7), DecodeI32(results[0]))
+
+	results, err = mod.ExportedFunction("pages").Call(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), DecodeI32(results[0]))
+
+	t.Run("invalid_table_type", func(t *testing.T) {
+		bad := r.NewHostModuleBuilder("bad")
+		bad.NewTable(api.ValueTypeI32, 1).Export("table")
+		assert.Error(t, bad.Instantiate(t.Context()))
+	})
+}
+
+func TestHostFunctionRegistryRelease(t *testing.T) {
+	before := len(globalRegistry.functions)
+
+	b := &bindings{}
+	id := globalRegistry.register(&hostFunctionBuilder{}, b, context.Background())
+	assert.Len(t, globalRegistry.functions, before+1)
+	other := globalRegistry.register(&hostFunctionBuilder{}, &bindings{}, context.Background())
+	defer globalRegistry.forget(other)
+
+	globalRegistry.unregister(id)
+	assert.Len(t, globalRegistry.functions, before+1)
+	assert.Same(t, b, globalRegistry.released[id], "late calls trap with the bindings of the released function")
+
+	// Once wasmtime dropped the function, nothing is left of it
+	globalRegistry.forget(id)
+	assert.NotContains(t, globalRegistry.released, id)
+}
+
+func TestHostFunctionFinalizer(t *testing.T) {
+	r, err := NewRuntime(t.Context())
+	require.NoError(t, err)
+
+	hostModule := r.NewHostModuleBuilder("env")
+	hostModule.NewFunctionBuilder("noop", []api.ValueType{}, []api.ValueType{}).
+		WithGoFunc(func(ctx context.Context, stack []uint64) {}).
+		Export("noop")
+	require.NoError(t, hostModule.Instantiate(t.Context()))
+	ids := hostModule.(*hostModuleBuilder).funcIDs
+	require.NoError(t, hostModule.Close(t.Context()))
+	require.NoError(t, r.Close(t.Context()))
+
+	// Wasmtime dropped the functions with the linker, which forgets them
+	for _, id := range ids {
+		assert.NotContains(t, globalRegistry.released, id)
+	}
+}
+
+func TestHostModuleRebuild(t *testing.T) {
+
+	r, err := NewRuntime(t.Context())
+	require.NoError(t, err)
+	defer r.Close(t.Context())
+
+	before := len(globalRegistry.functions)
+
+	// purego caps the number of callbacks per process (2000), so creating
+	// one per function would fail well before this loop ends.
+	for i := 0; i < 2500; i++ {
+		hostModule := r.NewHostModuleBuilder(fmt.Sprintf("env%d", i))
+		hostModule.NewFunctionBuilder("noop", []api.ValueType{}, []api.ValueType{}).
+			WithGoFunc(func(ctx context.Context, stack []uint64) {}).
+			Export("noop")
+		require.NoError(t, hostModule.Instantiate(t.Context()))
+		require.NoError(t, hostModule.Close(t.Context()))
+	}
+
+	assert.Len(t, globalRegistry.functions, before)
+
+	t.Run("call_after_close_traps", func(t *testing.T) {
+		hostModule := r.NewHostModuleBuilder("closed")
+		hostModule.NewFunctionBuilder("f", []api.ValueType{}, []api.ValueType{api.ValueTypeI32}).
+			WithGoFunc(func(ctx context.Context, stack []uint64) { stack[0] = 1 }).
+			Export("f")
+		require.NoError(t, hostModule.Instantiate(t.Context()))
+
+		compiled, err := r.CompileModule(t.Context(), []byte(`(module
+			(import "closed" "f" (func $f (result i32)))
+			(func (export "run") (result i32) (call $f)))`))
+		require.NoError(t, err)
+		defer compiled.Close()
+
+		mod, err := r.Instantiate(t.Context(), compiled)
+		require.NoError(t, err)
+
+		require.NoError(t, hostModule.Close(t.Context()))
+
+		_, err = mod.ExportedFunction("run").Call(t.Context())
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,121 @@
+package wasmtime
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory64(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntimeWithConfig(ctx, NewRuntimeConfig().WithMemory64(true))
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	host := r.NewHostModuleBuilder("env")
+	host.NewMemory(1).WithMaxPages(4).WithMemory64().Export("shared")
+	require.NoError(t, host.Instantiate(ctx))
+	defer host.Close(ctx)
+
+	wat := `
+	(module
+		(import "env" "shared" (memory $shared i64 1 4))
+		(memory $mem (export "memory") i64 1)
+		(func (export "load") (param i64) (result i64)
+			(i64.load $mem (local.get 0)))
+		(func (export "store_shared") (param i64 i64)
+			(i64.store $shared (local.get 0) (local.get 1)))
+		(func (export "size") (result i64)
+			(memory.size $mem))
+		(export "shared" (memory $shared))
+	)`
+
+	compiled, err := r.CompileModule(ctx, []byte(wat))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.Instantiate(ctx, compiled)
+	require.NoError(t, err)
+	defer mod.Close(ctx)
+
+	mem := mod.ExportedMemory("memory")
+	require.NotNil(t, mem)
+	const pageSize = 65536
+
+	t.Run("definition", func(t *testing.T) {
+		def := mem.Definition()
+		assert.True(t, def.Is64())
+		assert.Equal(t, uint64(1), def.Min())
+		assert.False(t, def.IsMaxEncoded())
+
+		shared := mod.ExportedMemory("shared").Definition()
+		assert.True(t, shared.Is64())
+		assert.True(t, shared.IsMaxEncoded())
+		assert.Equal(t, uint64(4), shared.Max())
+
+		results, err := mod.ExportedFunction("size").Call(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), results[0])
+	})
+
+	t.Run("i64 addresses", func(t *testing.T) {
+		require.True(t, mem.Write64(pageSize-8, []byte{1, 2, 3, 4, 5, 6, 7, 8}))
+		results, err := mod.ExportedFunction("load").Call(ctx, pageSize-8)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(0x0807060504030201), results[0])
+
+		b, ok := mem.Read64(pageSize-8, 8)
+		require.True(t, ok)
+		assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8}, b)
+
+		// Addresses beyond 4GiB are bounds-checked rather than truncated
+		_, ok = mem.Read64(1<<32, 1)
+		assert.False(t, ok)
+		assert.False(t, mem.Write64(1<<32, []byte{1}))
+		_, ok = mem.Read64(^uint64(0), 2)
+		assert.False(t, ok)
+
+		_, err = mod.ExportedFunction("load").Call(ctx, 1<<32)
+		var trapErr *TrapError
+		require.ErrorAs(t, err, &trapErr)
+		assert.Equal(t, TrapCodeMemoryOutOfBounds, trapErr.Code)
+	})
+
+	t.Run("host memory", func(t *testing.T) {
+		_, err := mod.ExportedFunction("store_shared").Call(ctx, 16, 42)
+		require.NoError(t, err)
+		v, ok := mod.ExportedMemory("shared").ReadUint64Le(16)
+		require.True(t, ok)
+		assert.Equal(t, uint64(42), v)
+	})
+
+	t.Run("memory view", func(t *testing.T) {
+		view := NewMemoryView64(mem, pageSize-8, 8)
+		buf := make([]byte, 8)
+		_, err := view.ReadAt(buf, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8}, buf)
+	})
+}
+
+func TestMemory32Definition(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntime(ctx)
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	compiled, err := r.CompileModule(ctx, []byte(`(module (memory (export "memory") 2 10))`))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.Instantiate(ctx, compiled)
+	require.NoError(t, err)
+	defer mod.Close(ctx)
+
+	def := mod.ExportedMemory("memory").Definition()
+	assert.False(t, def.Is64())
+	assert.Equal(t, uint64(2), def.Min())
+	assert.Equal(t, uint64(10), def.Max())
+	assert.True(t, def.IsMaxEncoded())
+}
@@ -0,0 +1,124 @@
+package wasmtime
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rvigee/purego-wasmtime/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryReadWrite(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntime(ctx)
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	// echo has the host read a string from guest memory and write it back
+	// doubled right after it
+	host := r.NewHostModuleBuilder("env")
+	host.NewFunctionBuilder("echo",
+		[]api.ValueType{api.ValueTypeI32, api.ValueTypeI32},
+		[]api.ValueType{api.ValueTypeI32},
+	).WithGoModuleFunc(func(ctx context.Context, mod api.Module, stack []uint64) {
+		ptr, length := DecodeU32(stack[0]), DecodeU32(stack[1])
+		mem := mod.ExportedMemory("memory")
+		b, ok := mem.Read(ptr, length)
+		require.True(t, ok)
+		s := string(b)
+		require.True(t, mem.WriteString(ptr+length, s+s))
+		stack[2] = EncodeU32(3 * length)
+	}).Export("echo")
+	require.NoError(t, host.Instantiate(ctx))
+	defer host.Close(ctx)
+
+	wat := `
+	(module
+		(import "env" "echo" (func $echo (param i32 i32) (result i32)))
+		(memory (export "memory") 1)
+		(data (i32.const 16) "abc")
+		(func (export "echo") (result i32)
+			(call $echo (i32.const 16) (i32.const 3)))
+		(func (export "load64") (param i32) (result i64)
+			(i64.load (local.get 0)))
+	)`
+
+	compiled, err := r.CompileModule(ctx, []byte(wat))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.Instantiate(ctx, compiled)
+	require.NoError(t, err)
+	defer mod.Close(ctx)
+
+	mem := mod.ExportedMemory("memory")
+	require.NotNil(t, mem)
+	const pageSize = 65536
+
+	t.Run("typed values", func(t *testing.T) {
+		require.True(t, mem.WriteByte(0, 0xab))
+		v8, ok := mem.ReadByte(0)
+		require.True(t, ok)
+		assert.Equal(t, byte(0xab), v8)
+
+		require.True(t, mem.WriteUint16Le(0, 0xbeef))
+		v16, ok := mem.ReadUint16Le(0)
+		require.True(t, ok)
+		assert.Equal(t, uint16(0xbeef), v16)
+
+		require.True(t, mem.WriteUint32Le(0, 0xdeadbeef))
+		v32, ok := mem.ReadUint32Le(0)
+		require.True(t, ok)
+		assert.Equal(t, uint32(0xdeadbeef), v32)
+
+		require.True(t, mem.WriteFloat32Le(0, 1.5))
+		f32, ok := mem.ReadFloat32Le(0)
+		require.True(t, ok)
+		assert.Equal(t, float32(1.5), f32)
+
+		require.True(t, mem.WriteFloat64Le(0, -2.25))
+		f64, ok := mem.ReadFloat64Le(0)
+		require.True(t, ok)
+		assert.Equal(t, -2.25, f64)
+
+		// Values are little-endian, as the guest sees them
+		require.True(t, mem.WriteUint64Le(8, 0x0102030405060708))
+		results, err := mod.ExportedFunction("load64").Call(ctx, EncodeI32(8))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(0x0102030405060708), results[0])
+		b, ok := mem.ReadByte(8)
+		require.True(t, ok)
+		assert.Equal(t, byte(0x08), b)
+	})
+
+	t.Run("bounds", func(t *testing.T) {
+		_, ok := mem.ReadUint32Le(pageSize - 4)
+		assert.True(t, ok)
+		_, ok = mem.ReadUint32Le(pageSize - 3)
+		assert.False(t, ok)
+		_, ok = mem.ReadUint64Le(0xffffffff)
+		assert.False(t, ok)
+		_, ok = mem.Read(pageSize, 1)
+		assert.False(t, ok)
+		assert.False(t, mem.WriteUint16Le(pageSize-1, 1))
+		assert.False(t, mem.Write(pageSize-2, []byte{1, 2, 3}))
+
+		b, ok := mem.Read(pageSize, 0)
+		assert.True(t, ok)
+		assert.Empty(t, b)
+
+		// Growing extends the valid range
+		_, ok = mem.Grow(ctx, 1)
+		require.True(t, ok)
+		assert.True(t, mem.WriteUint32Le(pageSize, 7))
+	})
+
+	t.Run("caller memory", func(t *testing.T) {
+		results, err := mod.ExportedFunction("echo").Call(ctx)
+		require.NoError(t, err)
+		b, ok := mem.Read(16, DecodeU32(results[0]))
+		require.True(t, ok)
+		assert.Equal(t, "abcabcabc", string(b))
+	})
+}
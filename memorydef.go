@@ -4,16 +4,18 @@ import "github.com/rvigee/purego-wasmtime/api"
 
 // memoryDefinition implements api.MemoryDefinition
 type memoryDefinition struct {
-	min        uint32
-	max        uint32
+	min        uint64
+	max        uint64
 	maxEncoded bool
+	is64       bool
+	shared     bool
 }
 
-func (md *memoryDefinition) Min() uint32 {
+func (md *memoryDefinition) Min() uint64 {
 	return md.min
 }
 
-func (md *memoryDefinition) Max() uint32 {
+func (md *memoryDefinition) Max() uint64 {
 	return md.max
 }
 
@@ -21,11 +23,34 @@ func (md *memoryDefinition) IsMaxEncoded() bool {
 	return md.maxEncoded
 }
 
+func (md *memoryDefinition) Is64() bool {
+	return md.is64
+}
+
+func (md *memoryDefinition) IsShared() bool {
+	return md.shared
+}
+
 // newMemoryDefinition creates a memory definition with the given limits.
-func newMemoryDefinition(min, max uint32, maxEncoded bool) api.MemoryDefinition {
+func newMemoryDefinition(min, max uint64, maxEncoded, is64, shared bool) api.MemoryDefinition {
 	return &memoryDefinition{
 		min:        min,
 		max:        max,
 		maxEncoded: maxEncoded,
+		is64:       is64,
+		shared:     shared,
 	}
 }
+
+// memoryTypeDefinition describes a wasmtime memory type.
+func memoryTypeDefinition(b *bindings, memoryType wasm_memorytype_t) api.MemoryDefinition {
+	var max uint64
+	maxEncoded := b.wasmtime_memorytype_maximum(memoryType, &max)
+	return newMemoryDefinition(
+		b.wasmtime_memorytype_minimum(memoryType),
+		max,
+		maxEncoded,
+		b.wasmtime_memorytype_is64(memoryType),
+		b.wasmtime_memorytype_isshared(memoryType),
+	)
+}
@@ -0,0 +1,155 @@
+package wasmtime
+
+import (
+	"context"
+	"slices"
+)
+
+// MemoryGrowthSource tells what grew a memory.
+type MemoryGrowthSource uint8
+
+const (
+	// MemoryGrowthGuest is growth by the guest, through memory.grow.
+	MemoryGrowthGuest MemoryGrowthSource = iota
+	// MemoryGrowthHost is growth by the host, through api.Memory.Grow.
+	MemoryGrowthHost
+)
+
+// String returns the name of the source.
+func (s MemoryGrowthSource) String() string {
+	switch s {
+	case MemoryGrowthGuest:
+		return "guest"
+	case MemoryGrowthHost:
+		return "host"
+	default:
+		return "unknown"
+	}
+}
+
+// MemoryGrowthEvent describes a memory that grew.
+type MemoryGrowthEvent struct {
+	// Module is the name of the module exporting the memory: the module
+	// name given by ModuleConfig, or the name of a host module. It is empty
+	// for modules instantiated without a name.
+	Module string
+	// Memory is the export name of the memory, empty when the host grows a
+	// memory the observer does not know about.
+	Memory string
+	// OldPages and NewPages are the sizes before and after growing.
+	OldPages, NewPages uint64
+	// Source tells whether the guest or the host grew the memory.
+	Source MemoryGrowthSource
+}
+
+// MemoryObserver is called when a memory of the runtime grows, for example
+// to emit metrics or to drop slices of guest memory cached by the host,
+// which growing may move. The helpers of api.Memory and MemoryView fetch the
+// memory's base pointer on every access, so they need no such care.
+//
+// The observer does not hook memory.grow: the C API has no resource limiter
+// callback, as wasmtime_store_limiter only takes fixed limits. Instead, the
+// size of every watched memory of a store is polled at each call boundary,
+// when a call into the guest returns or the guest calls a host function.
+// This has two consequences:
+//
+//   - Growth by the guest is reported late, once per call boundary, even if
+//     the guest grew the memory several times in between.
+//   - Every call into or out of a store pays for one size check per watched
+//     memory while an observer is set. Without an observer, nothing is
+//     polled.
+//
+// Growth by the host is reported as it happens.
+//
+// The observer watches the memories exported by instances and host modules,
+// not shared memories, and stops watching those of an instance when it is
+// closed. It runs on the goroutine using the store, so it may be called
+// concurrently for instances in different stores.
+type MemoryObserver func(ctx context.Context, event MemoryGrowthEvent)
+
+// watchedMemory is a memory whose growth is reported to the observer.
+type watchedMemory struct {
+	owner  *module // Instance exporting the memory, nil for host modules
+	module string
+	name   string
+	val    wasmtime_memory_t
+	pages  uint64 // Size when last reported
+}
+
+// watchMemory starts reporting the growth of a memory, when the store has an
+// observer.
+func (s *storeState) watchMemory(b *bindings, storeCtx wasmtime_context_t, owner *module, module, name string, val wasmtime_memory_t) {
+	if s.observer == nil {
+		return
+	}
+	// Memories imported and exported again are reported once
+	for _, w := range s.watched {
+		if sameMemory(w.val, val) {
+			return
+		}
+	}
+	s.watched = append(s.watched, &watchedMemory{
+		owner:  owner,
+		module: module,
+		name:   name,
+		val:    val,
+		pages:  b.wasmtime_memory_size(storeCtx, &val),
+	})
+}
+
+// watchExports starts reporting the growth of the memories exported by mod.
+func (s *storeState) watchExports(mod *module) error {
+	if s.observer == nil {
+		return nil
+	}
+	storeCtx := mod.bindings.wasmtime_store_context(mod.store)
+	return mod.forEachExport(func(name string, ext *wasmtime_extern_t) error {
+		if ext.kind == WASMTIME_EXTERN_MEMORY {
+			s.watchMemory(mod.bindings, storeCtx, mod, mod.name, name, *ext.AsMemory())
+		}
+		return nil
+	})
+}
+
+// unwatch stops reporting the growth of the memories exported by mod, which
+// is closed.
+func (s *storeState) unwatch(mod *module) {
+	s.watched = slices.DeleteFunc(s.watched, func(w *watchedMemory) bool {
+		return w.owner == mod
+	})
+}
+
+// checkMemoryGrowth reports the watched memories that grew since they were
+// last reported, as grown by the guest.
+func (s *storeState) checkMemoryGrowth(ctx context.Context, b *bindings, storeCtx wasmtime_context_t) {
+	for _, w := range s.watched {
+		pages := b.wasmtime_memory_size(storeCtx, &w.val)
+		if pages == w.pages {
+			continue
+		}
+		event := MemoryGrowthEvent{Module: w.module, Memory: w.name, OldPages: w.pages, NewPages: pages, Source: MemoryGrowthGuest}
+		w.pages = pages
+		s.observer(ctx, event)
+	}
+}
+
+// reportHostGrowth reports that the host grew val from oldPages to newPages.
+// Pending growth by the guest must have been reported beforehand, or it would
+// be mistaken for the host's.
+func (s *storeState) reportHostGrowth(ctx context.Context, val wasmtime_memory_t, oldPages, newPages uint64) {
+	event := MemoryGrowthEvent{OldPages: oldPages, NewPages: newPages, Source: MemoryGrowthHost}
+	for _, w := range s.watched {
+		if sameMemory(w.val, val) {
+			event.Module, event.Memory = w.module, w.name
+			w.pages = newPages
+			break
+		}
+	}
+	s.observer(ctx, event)
+}
+
+// sameMemory reports whether a and b refer to the same memory, ignoring the
+// padding the C API does not write.
+func sameMemory(a, b wasmtime_memory_t) bool {
+	return a.store_id == b.store_id && a.__private1 == b.__private1 && a.__private2 == b.__private2
+}
@@ -0,0 +1,132 @@
+package wasmtime
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryObserver(t *testing.T) {
+	ctx := t.Context()
+
+	var mu sync.Mutex
+	var events []MemoryGrowthEvent
+	observer := func(ctx context.Context, event MemoryGrowthEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+	takeEvents := func() []MemoryGrowthEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		e := events
+		events = nil
+		return e
+	}
+
+	r, err := NewRuntimeWithConfig(ctx, NewRuntimeConfig().WithMemoryObserver(observer))
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	// probe records the events seen when the guest calls the host
+	var seenByProbe []MemoryGrowthEvent
+	host := r.NewHostModuleBuilder("env")
+	host.NewFunctionBuilder("probe", nil, nil).
+		WithGoFunc(func(ctx context.Context, stack []uint64) {
+			seenByProbe = takeEvents()
+		}).
+		Export("probe")
+	host.NewMemory(1).Export("hostmem")
+	require.NoError(t, host.Instantiate(ctx))
+	defer host.Close(ctx)
+
+	t.Run("guest growth", func(t *testing.T) {
+		compiled, err := r.CompileModule(ctx, []byte(`
+		(module
+			(import "env" "probe" (func $probe))
+			(memory (export "memory") 1)
+			(func (export "grow") (param i32) (result i32)
+				(memory.grow (local.get 0)))
+			(func (export "grow_and_probe")
+				(drop (memory.grow (i32.const 1)))
+				(drop (memory.grow (i32.const 1)))
+				(call $probe))
+		)`))
+		require.NoError(t, err)
+		defer compiled.Close()
+
+		mod, err := r.InstantiateModule(ctx, compiled, NewModuleConfig().WithName("guest"))
+		require.NoError(t, err)
+		defer mod.Close(ctx)
+
+		_, err = mod.ExportedFunction("grow").Call(ctx, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []MemoryGrowthEvent{
+			{Module: "guest", Memory: "memory", OldPages: 1, NewPages: 3, Source: MemoryGrowthGuest},
+		}, takeEvents())
+
+		// Growing by zero pages is not growth
+		_, err = mod.ExportedFunction("grow").Call(ctx, 0)
+		require.NoError(t, err)
+		assert.Empty(t, takeEvents())
+
+		// Growth is reported before a host function runs, once for both grows
+		_, err = mod.ExportedFunction("grow_and_probe").Call(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []MemoryGrowthEvent{
+			{Module: "guest", Memory: "memory", OldPages: 3, NewPages: 5, Source: MemoryGrowthGuest},
+		}, seenByProbe)
+		assert.Empty(t, takeEvents())
+
+		_, ok := mod.ExportedMemory("memory").Grow(ctx, 1)
+		require.True(t, ok)
+		assert.Equal(t, []MemoryGrowthEvent{
+			{Module: "guest", Memory: "memory", OldPages: 5, NewPages: 6, Source: MemoryGrowthHost},
+		}, takeEvents())
+	})
+
+	t.Run("host memory", func(t *testing.T) {
+		compiled, err := r.CompileModule(ctx, []byte(`
+		(module
+			(import "env" "hostmem" (memory 1))
+			(func (export "grow") (param i32) (result i32)
+				(memory.grow (local.get 0)))
+			(export "memory" (memory 0))
+		)`))
+		require.NoError(t, err)
+		defer compiled.Close()
+
+		mod, err := r.Instantiate(ctx, compiled)
+		require.NoError(t, err)
+		defer mod.Close(ctx)
+
+		_, err = mod.ExportedFunction("grow").Call(ctx, 1)
+		require.NoError(t, err)
+		assert.Equal(t, []MemoryGrowthEvent{
+			{Module: "env", Memory: "hostmem", OldPages: 1, NewPages: 2, Source: MemoryGrowthGuest},
+		}, takeEvents(), "the re-exported memory is reported once, under its host name")
+	})
+
+	t.Run("closed instances are not watched", func(t *testing.T) {
+		compiled, err := r.CompileModule(ctx, []byte(`(module (memory (export "memory") 1))`))
+		require.NoError(t, err)
+		defer compiled.Close()
+
+		state := r.(*wasmRuntime).storeState
+		before := len(state.watched)
+		mod, err := r.Instantiate(ctx, compiled)
+		require.NoError(t, err)
+		assert.Len(t, state.watched, before+1)
+		require.NoError(t, mod.Close(ctx))
+		assert.Len(t, state.watched, before)
+	})
+}
+
+func TestMemoryGrowthSourceString(t *testing.T) {
+	assert.Equal(t, "guest", MemoryGrowthGuest.String())
+	assert.Equal(t, "host", MemoryGrowthHost.String())
+	assert.Equal(t, "unknown", MemoryGrowthSource(9).String())
+}
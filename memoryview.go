@@ -0,0 +1,134 @@
+package wasmtime
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/rvigee/purego-wasmtime/api"
+)
+
+// MemoryView exposes a region of guest memory as an io.ReaderAt,
+// io.WriterAt and io.ReadWriteSeeker, so that guest buffers can be used with
+// io.Copy, bufio and encoders.
+//
+// Every access goes through the bounds-checked helpers of api.Memory, which
+// fetch the memory's base pointer anew, so a view stays valid after the
+// guest or the host grows the memory. Like an *os.File, a MemoryView is not
+// safe for concurrent use except through ReadAt and WriteAt.
+type MemoryView struct {
+	mem    api.Memory
+	offset uint64
+	length uint64
+	pos    int64
+}
+
+var (
+	_ io.ReaderAt        = (*MemoryView)(nil)
+	_ io.WriterAt        = (*MemoryView)(nil)
+	_ io.ReadWriteSeeker = (*MemoryView)(nil)
+)
+
+// errOutOfRange is returned when a view reaches past the end of the memory.
+var errOutOfRange = errors.New("memory view: out of range of memory")
+
+// NewMemoryView returns a view of the length bytes of mem starting at offset.
+// The region may extend past the current end of mem, in which case accesses
+// to that part fail until the memory has grown enough, but is cut short at
+// the end of the 32-bit address space.
+func NewMemoryView(mem api.Memory, offset, length uint32) *MemoryView {
+	length = min(length, math.MaxUint32-offset)
+	return &MemoryView{mem: mem, offset: uint64(offset), length: uint64(length)}
+}
+
+// NewMemoryView64 is like NewMemoryView with a 64-bit region, for memory64
+// memories. The length is cut short to what an int can hold.
+func NewMemoryView64(mem api.Memory, offset, length uint64) *MemoryView {
+	length = min(length, math.MaxUint64-offset, math.MaxInt64, uint64(math.MaxInt))
+	return &MemoryView{mem: mem, offset: offset, length: length}
+}
+
+// Offset returns the offset of the view in guest memory.
+func (v *MemoryView) Offset() uint64 {
+	return v.offset
+}
+
+// Len returns the length of the view in bytes.
+func (v *MemoryView) Len() int {
+	return int(v.length)
+}
+
+// ReadAt reads len(p) bytes from the view starting at off, returning io.EOF
+// when it reaches the end of the view.
+func (v *MemoryView) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("memory view: negative offset %d", off)
+	}
+	if off >= int64(v.length) {
+		return 0, io.EOF
+	}
+	n := min(int64(len(p)), int64(v.length)-off)
+	b, ok := v.mem.Read64(v.offset+uint64(off), uint64(n))
+	if !ok {
+		return 0, errOutOfRange
+	}
+	copy(p, b)
+	if n < int64(len(p)) {
+		return int(n), io.EOF
+	}
+	return int(n), nil
+}
+
+// WriteAt writes p to the view starting at off. Writes are never truncated:
+// it fails with io.ErrShortWrite if p does not fit in the view.
+func (v *MemoryView) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("memory view: negative offset %d", off)
+	}
+	if off+int64(len(p)) > int64(v.length) {
+		return 0, io.ErrShortWrite
+	}
+	if !v.mem.Write64(v.offset+uint64(off), p) {
+		return 0, errOutOfRange
+	}
+	return len(p), nil
+}
+
+// Read reads from the current position of the view.
+func (v *MemoryView) Read(p []byte) (int, error) {
+	n, err := v.ReadAt(p, v.pos)
+	v.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		// Report EOF on the next call, as io.Reader prefers
+		err = nil
+	}
+	return n, err
+}
+
+// Write writes at the current position of the view.
+func (v *MemoryView) Write(p []byte) (int, error) {
+	n, err := v.WriteAt(p, v.pos)
+	v.pos += int64(n)
+	return n, err
+}
+
+// Seek sets the position of the next Read or Write, relative to the start of
+// the view. Positions past the end are allowed, but reading there returns
+// io.EOF and writing fails.
+func (v *MemoryView) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += v.pos
+	case io.SeekEnd:
+		offset += int64(v.length)
+	default:
+		return 0, fmt.Errorf("memory view: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("memory view: negative position %d", offset)
+	}
+	v.pos = offset
+	return offset, nil
+}
@@ -0,0 +1,102 @@
+package wasmtime
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryView(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntime(ctx)
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	wat := `
+	(module
+		(memory (export "memory") 1)
+		(data (i32.const 100) "line one\nline two\n")
+		(func (export "grow") (param i32) (result i32)
+			(memory.grow (local.get 0)))
+	)`
+
+	compiled, err := r.CompileModule(ctx, []byte(wat))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.Instantiate(ctx, compiled)
+	require.NoError(t, err)
+	defer mod.Close(ctx)
+
+	mem := mod.ExportedMemory("memory")
+	const pageSize = 65536
+
+	t.Run("reader", func(t *testing.T) {
+		view := NewMemoryView(mem, 100, 18)
+		scanner := bufio.NewScanner(view)
+		var lines []string
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		require.NoError(t, scanner.Err())
+		assert.Equal(t, []string{"line one", "line two"}, lines)
+
+		buf := make([]byte, 10)
+		n, err := view.ReadAt(buf, 14)
+		assert.Equal(t, 4, n)
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, "two\n", string(buf[:n]))
+	})
+
+	t.Run("writer_and_seek", func(t *testing.T) {
+		view := NewMemoryView(mem, 1000, 16)
+		n, err := io.Copy(view, strings.NewReader("0123456789"))
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), n)
+
+		_, err = view.Seek(-4, io.SeekCurrent)
+		require.NoError(t, err)
+		_, err = view.Write([]byte("ab"))
+		require.NoError(t, err)
+
+		_, err = view.Write([]byte("too long for the view"))
+		assert.ErrorIs(t, err, io.ErrShortWrite)
+
+		_, err = view.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+		var out bytes.Buffer
+		_, err = io.Copy(&out, io.LimitReader(view, 10))
+		require.NoError(t, err)
+		assert.Equal(t, "012345ab89", out.String())
+
+		b, ok := mem.Read(1000, 10)
+		require.True(t, ok)
+		assert.Equal(t, "012345ab89", string(b))
+	})
+
+	t.Run("growth", func(t *testing.T) {
+		// The view starts at the end of memory until the guest grows it
+		view := NewMemoryView(mem, pageSize, 8)
+		_, err := view.WriteAt([]byte("grown"), 0)
+		assert.ErrorIs(t, err, errOutOfRange)
+
+		results, err := mod.ExportedFunction("grow").Call(ctx, EncodeI32(1))
+		require.NoError(t, err)
+		require.Equal(t, int32(1), DecodeI32(results[0]))
+
+		_, err = view.WriteAt([]byte("grown"), 0)
+		require.NoError(t, err)
+		b, ok := mem.Read(pageSize, 5)
+		require.True(t, ok)
+		assert.Equal(t, "grown", string(b))
+	})
+
+	t.Run("address_space_end", func(t *testing.T) {
+		assert.Equal(t, 15, NewMemoryView(mem, 0xffffffff-15, 100).Len())
+	})
+}
@@ -2,6 +2,7 @@ package wasmtime
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"runtime"
 	"sync"
@@ -13,7 +14,8 @@ import (
 type callBuffer struct {
 	Params  []wasmtime_val_t
 	Results []wasmtime_val_t
-	Trap    *wasm_trap_t
+	Raw     []wasmtime_val_raw_t
+	Trap    wasm_trap_t
 }
 
 var bufferPool = sync.Pool{
@@ -21,16 +23,27 @@ var bufferPool = sync.Pool{
 		return &callBuffer{
 			Params:  make([]wasmtime_val_t, 0, 8),
 			Results: make([]wasmtime_val_t, 0, 1),
+			Raw:     make([]wasmtime_val_raw_t, 0, 8),
 		}
 	},
 }
 
 // module implements api.Module for an instantiated WebAssembly module.
 type module struct {
-	inst     wasmtime_instance_t
-	store    wasmtime_store_t
-	name     string
-	bindings *bindings
+	inst       wasmtime_instance_t
+	store      wasmtime_store_t
+	storeState *storeState
+	name       string
+	bindings   *bindings
+	runtime    *wasmRuntime
+
+	// ownsStore is set when the module has a store of its own, as with
+	// InstantiateModule and InstantiateWithWASI, and the store is deleted
+	// on Close
+	ownsStore bool
+
+	// stdio pumps the WASI stdio of an owned store
+	stdio *stdioPipes
 }
 
 func (m *module) Name() string {
@@ -44,23 +57,32 @@ func (m *module) ExportedFunction(name string) api.Function {
 	}
 
 	if ext.kind != WASMTIME_EXTERN_FUNC {
+		m.bindings.wasmtime_extern_delete(ext)
 		return nil
 	}
 
-	funcPtr := ext.AsFunc()
+	return newFunction(name, *ext.AsFunc(), m.store, m.bindings.wasmtime_store_context(m.store), m.storeState, m.bindings)
+}
+
+// newFunction wraps a wasmtime function living in the store behind storeCtx.
+func newFunction(name string, val wasmtime_func_t, store wasmtime_store_t, storeCtx wasmtime_context_t, state *storeState, bindings *bindings) *function {
 	f := &function{
-		name:     name,
-		val:      *funcPtr,
-		store:    m.store,
-		storeCtx: m.bindings.wasmtime_store_context(m.store),
-		fnCache:  nil,
-		bindings: m.bindings,
+		name:       name,
+		val:        val,
+		store:      store,
+		storeCtx:   storeCtx,
+		storeState: state,
+		fnCache:    nil,
+		bindings:   bindings,
 	}
 
 	// Pre-populate definition to cache types
 	def := f.Definition()
 	f.paramTypes = def.ParamTypes()
 	f.resultTypes = def.ResultTypes()
+	f.paramSlots = stackSlots(f.paramTypes)
+	f.resultSlots = stackSlots(f.resultTypes)
+	f.unchecked = isRawSignature(f.paramTypes, f.resultTypes)
 
 	return f
 }
@@ -72,12 +94,30 @@ func (m *module) ExportedFunctionDefinitions() map[string]api.FunctionDefinition
 }
 
 func (m *module) Close(ctx context.Context) error {
-	// Instance doesn't need explicit cleanup in wasmtime
-	// Resources are managed by the store
+	// Instances sharing the runtime's store are released with it, but
+	// their memories need no more watching
+	if !m.ownsStore || m.store == 0 {
+		if m.storeState != nil {
+			m.storeState.unwatch(m)
+		}
+		return nil
+	}
+	m.runtime.untrackModule(m)
+	m.bindings.wasmtime_store_delete(m.store)
+	m.store = 0
+	m.storeState.goWASI.close()
+	globalStores.unregister(m.storeState.id)
+	m.storeState = nil
+	// Deleting the store closed wasmtime's end of the stdio pipes
+	m.stdio.wait()
+	m.stdio = nil
 	return nil
 }
 
 func (m *module) getExport(name string) (*wasmtime_extern_t, error) {
+	if m.store == 0 {
+		return nil, fmt.Errorf("module %q is closed", m.name)
+	}
 	ext := new(wasmtime_extern_t)
 	nameByte := []byte(name + "\000")
 
@@ -100,17 +140,16 @@ func (m *module) ExportedMemory(name string) api.Memory {
 		return nil
 	}
 
-	if ext.kind != WASMTIME_EXTERN_MEMORY {
+	switch ext.kind {
+	case WASMTIME_EXTERN_MEMORY:
+		return newMemory(*ext.AsMemory(), m.store, m.bindings.wasmtime_store_context(m.store), m.bindings)
+	case WASMTIME_EXTERN_SHAREDMEMORY:
+		// The shared memory takes ownership of the handle
+		return newSharedMemory(*ext.AsSharedMemory(), m.runtime, m.bindings)
+	default:
+		m.bindings.wasmtime_extern_delete(ext)
 		return nil
 	}
-
-	memPtr := ext.AsMemory()
-	return &memory{
-		val:      *memPtr,
-		store:    m.store,
-		storeCtx: m.bindings.wasmtime_store_context(m.store),
-		bindings: m.bindings,
-	}
 }
 
 func (m *module) ExportedGlobal(name string) api.Global {
@@ -120,20 +159,12 @@ func (m *module) ExportedGlobal(name string) api.Global {
 	}
 
 	if ext.kind != WASMTIME_EXTERN_GLOBAL {
+		m.bindings.wasmtime_extern_delete(ext)
 		return nil
 	}
 
-	globalPtr := ext.AsGlobal()
-	return &global{
-		val:      *globalPtr,
-		store:    m.store,
-		storeCtx: m.bindings.wasmtime_store_context(m.store),
-		// Wasmtime C API limitation: No wasmtime_global_type() or similar API available.
-		// Type and mutability cannot be queried, so we use safe defaults.
-		valType:  api.ValueTypeI32, // Default - actual type depends on the global
-		mutable:  true,             // Default - assume mutable for safety
-		bindings: m.bindings,
-	}
+	storeCtx := m.bindings.wasmtime_store_context(m.store)
+	return newGlobal(*ext.AsGlobal(), m.store, storeCtx, m.bindings)
 }
 
 func (m *module) ExportedTable(name string) api.Table {
@@ -143,19 +174,16 @@ func (m *module) ExportedTable(name string) api.Table {
 	}
 
 	if ext.kind != WASMTIME_EXTERN_TABLE {
+		m.bindings.wasmtime_extern_delete(ext)
 		return nil
 	}
 
-	tablePtr := ext.AsTable()
-	return &table{
-		val:      *tablePtr,
-		store:    m.store,
-		storeCtx: m.bindings.wasmtime_store_context(m.store),
-		bindings: m.bindings,
-	}
+	storeCtx := m.bindings.wasmtime_store_context(m.store)
+	return newTable(*ext.AsTable(), m.store, storeCtx, m.bindings)
 }
 
 type memory struct {
+	memoryAccessors
 	val      wasmtime_memory_t
 	store    wasmtime_store_t
 	storeCtx wasmtime_context_t
@@ -175,12 +203,21 @@ func (m *memory) Size(ctx context.Context) uint64 {
 }
 
 func (m *memory) Grow(ctx context.Context, delta uint64) (uint64, bool) {
+	state := m.bindings.stateFromContext(m.storeCtx)
+	observed := state != nil && state.observer != nil
+	if observed {
+		state.checkMemoryGrowth(ctx, m.bindings, m.storeCtx)
+	}
+
 	var prevSize uint64
 	err := m.bindings.wasmtime_memory_grow(m.storeCtx, &m.val, delta, &prevSize)
 	if err != 0 {
 		m.bindings.wasmtime_error_delete(err)
 		return 0, false
 	}
+	if observed && delta > 0 {
+		state.reportHostGrowth(ctx, m.val, prevSize, prevSize+delta)
+	}
 	return prevSize, true
 }
 
@@ -190,9 +227,13 @@ type function struct {
 	val         wasmtime_func_t
 	store       wasmtime_store_t
 	storeCtx    wasmtime_context_t
+	storeState  *storeState
 	fnCache     api.FunctionDefinition
 	paramTypes  []api.ValueType
 	resultTypes []api.ValueType
+	paramSlots  int  // Stack slots taken by the params
+	resultSlots int  // Stack slots taken by the results
+	unchecked   bool // Whether the signature allows wasmtime_func_call_unchecked
 	bindings    *bindings
 }
 
@@ -201,8 +242,7 @@ func (f *function) Definition() api.FunctionDefinition {
 		return f.fnCache
 	}
 
-	storeCtx := f.bindings.wasmtime_store_context(f.store)
-	funcType := f.bindings.wasmtime_func_type(storeCtx, &f.val)
+	funcType := f.bindings.wasmtime_func_type(f.storeCtx, &f.val)
 	if funcType == 0 {
 		return &functionDefinition{
 			name:        f.name,
@@ -237,21 +277,91 @@ func (f *function) Definition() api.FunctionDefinition {
 }
 
 func (f *function) Call(ctx context.Context, params ...uint64) ([]uint64, error) {
-	if len(params) != len(f.paramTypes) {
-		return nil, fmt.Errorf("expected %d parameters, got %d", len(f.paramTypes), len(params))
+	results, err := f.call(ctx, params...)
+	if isExit(err, 0) {
+		return nil, nil
+	}
+	return results, err
+}
+
+// call is Call, returning a WASIExitError for exit(0) too.
+func (f *function) call(ctx context.Context, params ...uint64) ([]uint64, error) {
+	if len(params) != f.paramSlots {
+		return nil, fmt.Errorf("expected %d parameters, got %d", f.paramSlots, len(params))
+	}
+
+	// The caller owns the returned results, so they get a fresh stack
+	stack := make([]uint64, max(f.paramSlots, f.resultSlots))
+	copy(stack, params)
+	if err := f.callWithStack(ctx, stack); err != nil {
+		return nil, err
+	}
+	return stack[:f.resultSlots], nil
+}
+
+// CallWithStack invokes the function with its params read from the start of
+// stack and writes its results back to the start of stack. It does not
+// allocate in the steady state.
+func (f *function) CallWithStack(ctx context.Context, stack []uint64) error {
+	err := f.callWithStack(ctx, stack)
+	// As with a native program, exit(0) from WASI is a normal return
+	if isExit(err, 0) {
+		return nil
+	}
+	return err
+}
+
+// isExit reports whether err comes from WASI proc_exit with the given code.
+func isExit(err error, code int32) bool {
+	var exitErr *WASIExitError
+	return errors.As(err, &exitErr) && exitErr.ExitCode == code
+}
+
+// callWithStack is CallWithStack, returning a WASIExitError for exit(0) too.
+func (f *function) callWithStack(ctx context.Context, stack []uint64) error {
+	if n := max(f.paramSlots, f.resultSlots); len(stack) < n {
+		return fmt.Errorf("stack too small: need %d values, got %d", n, len(stack))
 	}
 
 	// Get buffer from pool
 	buf := bufferPool.Get().(*callBuffer)
 	defer bufferPool.Put(buf)
 
+	// Reset the trap pointer in the reused buffer
+	buf.Trap = 0
+
+	// Expose ctx to host functions invoked during this call
+	if f.storeState != nil {
+		defer f.storeState.leave(f.storeState.enter(ctx))
+		// Report the memories the guest grew once the call returns
+		if f.storeState.observer != nil {
+			defer f.storeState.checkMemoryGrowth(ctx, f.bindings, f.storeCtx)
+		}
+	}
+
+	if f.unchecked {
+		return f.callUnchecked(buf, stack)
+	}
+	return f.callChecked(buf, stack)
+}
+
+// callChecked calls the function through wasmtime_func_call, which tags and
+// type checks every value.
+func (f *function) callChecked(buf *callBuffer, stack []uint64) error {
 	// Prepare params
-	buf.Params = buf.Params[:len(params)]
-	for i, param := range params {
+	numParams := len(f.paramTypes)
+	buf.Params = buf.Params[:0]
+	if cap(buf.Params) < numParams {
+		buf.Params = make([]wasmtime_val_t, 0, numParams)
+	}
+	buf.Params = buf.Params[:numParams]
+	slot := 0
+	for i, vt := range f.paramTypes {
 		// Inline encodeToWasmValue
 		// Optimization: Skip zero-init of padding since we override the value anyway
 		// and C API ignores padding.
-		vt := f.paramTypes[i]
+		param := stack[slot]
+		slot++
 		switch vt {
 		case api.ValueTypeI32:
 			buf.Params[i].kind = WASM_I32
@@ -267,13 +377,15 @@ func (f *function) Call(ctx context.Context, params ...uint64) ([]uint64, error)
 			*(*float64)(unsafe.Pointer(&buf.Params[i].of.data[0])) = *(*float64)(unsafe.Pointer(&param))
 		case api.ValueTypeV128:
 			buf.Params[i].kind = WASM_V128
-			*(*int64)(unsafe.Pointer(&buf.Params[i].of.data[0])) = int64(param)
+			*(*uint64)(unsafe.Pointer(&buf.Params[i].of.data[0])) = param
+			*(*uint64)(unsafe.Pointer(&buf.Params[i].of.data[8])) = stack[slot]
+			slot++
 		case api.ValueTypeFuncref:
 			buf.Params[i].kind = WASM_FUNCREF
-			*(*wasmtime_func_t)(unsafe.Pointer(&buf.Params[i].of.data[0])) = wasmtime_func_t{}
+			decodeFuncref(f.bindings, f.storeCtx, param, (*wasmtime_func_t)(unsafe.Pointer(&buf.Params[i].of.data[0])))
 		case api.ValueTypeExternref:
 			buf.Params[i].kind = WASM_EXTERNREF
-			*(*uintptr)(unsafe.Pointer(&buf.Params[i].of.data[0])) = uintptr(param)
+			decodeExternref(f.bindings, f.storeCtx, param, buf.Params[i].AsExternRef())
 		}
 	}
 
@@ -296,58 +408,158 @@ func (f *function) Call(ctx context.Context, params ...uint64) ([]uint64, error)
 		paramsPtr = &buf.Params[0]
 	}
 
-	// Reset the trap pointer in the reused buffer
-	buf.Trap = nil
-
 	callErr := f.bindings.wasmtime_func_call(f.storeCtx, &f.val, paramsPtr, uintptr(len(buf.Params)), resultsPtr, uintptr(numResults), &buf.Trap)
 
 	runtime.KeepAlive(f)
 	// buf is kept alive by the function scope reference
 
-	if callErr != 0 {
-		err := f.bindings.getErrorMessage(callErr, 0)
-		// Handle WASI exit(0) gracefully
-		if exitErr, ok := err.(*WASIExitError); ok && exitErr.ExitCode == 0 {
-			// Success exit - return results normally
-		} else {
-			return nil, fmt.Errorf("call failed: %w", err)
-		}
+	// Params were copied by the call, so our externref roots can go
+	for i := range buf.Params {
+		unrootValue(f.bindings, &buf.Params[i])
 	}
-	if buf.Trap != nil {
-		return nil, fmt.Errorf("call failed (trap): %w", f.bindings.getErrorMessage(0, *buf.Trap))
+
+	if err := f.callError(callErr, buf.Trap); err != nil {
+		return err
 	}
 
-	// Convert results back to uint64
-	// We return a new slice because the caller owns the result
-	// Assuming the caller wants []uint64 and not a reused buffer
-	// Optimizing this further would require changing the API to accept a result buffer
-	resultValues := make([]uint64, numResults)
+	// Convert results back to uint64, overwriting the params
+	slot = 0
 	for i := 0; i < numResults; i++ {
 		// Inline decodeFromWasmValue
 		val := &buf.Results[i]
 		switch val.kind {
 		case WASM_I32:
-			resultValues[i] = uint64(uint32(*(*int32)(unsafe.Pointer(&val.of.data[0]))))
+			stack[slot] = uint64(uint32(*(*int32)(unsafe.Pointer(&val.of.data[0]))))
 		case WASM_I64:
-			resultValues[i] = uint64(*(*int64)(unsafe.Pointer(&val.of.data[0])))
+			stack[slot] = uint64(*(*int64)(unsafe.Pointer(&val.of.data[0])))
 		case WASM_F32:
 			f32 := *(*float32)(unsafe.Pointer(&val.of.data[0]))
-			resultValues[i] = uint64(*(*uint32)(unsafe.Pointer(&f32)))
+			stack[slot] = uint64(*(*uint32)(unsafe.Pointer(&f32)))
 		case WASM_F64:
 			f64 := *(*float64)(unsafe.Pointer(&val.of.data[0]))
-			resultValues[i] = *(*uint64)(unsafe.Pointer(&f64))
+			stack[slot] = *(*uint64)(unsafe.Pointer(&f64))
 		case WASM_V128:
-			resultValues[i] = uint64(*(*int64)(unsafe.Pointer(&val.of.data[0])))
+			stack[slot] = *(*uint64)(unsafe.Pointer(&val.of.data[0]))
+			slot++
+			stack[slot] = *(*uint64)(unsafe.Pointer(&val.of.data[8]))
 		case WASM_FUNCREF:
-			resultValues[i] = 0
+			stack[slot] = encodeFuncref(f.bindings, f.storeCtx, (*wasmtime_func_t)(unsafe.Pointer(&val.of.data[0])))
 		case WASM_EXTERNREF:
-			resultValues[i] = uint64(*(*uintptr)(unsafe.Pointer(&val.of.data[0])))
+			stack[slot] = encodeExternref(f.bindings, f.storeCtx, val.AsExternRef())
+			unrootValue(f.bindings, val)
+		default:
+			stack[slot] = 0
+		}
+		slot++
+	}
+
+	return nil
+}
+
+// callUnchecked calls the function through wasmtime_func_call_unchecked,
+// which passes params and results in one untagged wasmtime_val_raw_t array.
+// It is only used for signatures of numeric, vector and funcref types, whose
+// raw form is the little-endian value zero-extended to the full slot. For a
+// funcref, that is the raw funcref pointer our uint64 ABI already uses.
+func (f *function) callUnchecked(buf *callBuffer, stack []uint64) error {
+	n := max(len(f.paramTypes), len(f.resultTypes))
+	buf.Raw = buf.Raw[:0]
+	if cap(buf.Raw) < n {
+		buf.Raw = make([]wasmtime_val_raw_t, 0, n)
+	}
+	buf.Raw = buf.Raw[:n]
+
+	slot := 0
+	for i, vt := range f.paramTypes {
+		raw := &buf.Raw[i]
+		*raw = wasmtime_val_raw_t{}
+		switch vt {
+		case api.ValueTypeI32, api.ValueTypeF32:
+			*(*uint32)(unsafe.Pointer(&raw.data[0])) = uint32(stack[slot])
+		case api.ValueTypeV128:
+			*(*uint64)(unsafe.Pointer(&raw.data[0])) = stack[slot]
+			slot++
+			*(*uint64)(unsafe.Pointer(&raw.data[8])) = stack[slot]
 		default:
-			resultValues[i] = 0
+			*(*uint64)(unsafe.Pointer(&raw.data[0])) = stack[slot]
 		}
+		slot++
 	}
 
-	return resultValues, nil
+	var rawPtr *wasmtime_val_raw_t
+	if n > 0 {
+		rawPtr = &buf.Raw[0]
+	}
+
+	callErr := f.bindings.wasmtime_func_call_unchecked(f.storeCtx, &f.val, rawPtr, uintptr(n), &buf.Trap)
+
+	runtime.KeepAlive(f)
+
+	if err := f.callError(callErr, buf.Trap); err != nil {
+		return err
+	}
+
+	slot = 0
+	for i, vt := range f.resultTypes {
+		raw := &buf.Raw[i]
+		switch vt {
+		case api.ValueTypeI32, api.ValueTypeF32:
+			stack[slot] = uint64(*(*uint32)(unsafe.Pointer(&raw.data[0])))
+		case api.ValueTypeV128:
+			stack[slot] = *(*uint64)(unsafe.Pointer(&raw.data[0]))
+			slot++
+			stack[slot] = *(*uint64)(unsafe.Pointer(&raw.data[8]))
+		default:
+			stack[slot] = *(*uint64)(unsafe.Pointer(&raw.data[0]))
+		}
+		slot++
+	}
+
+	return nil
+}
+
+// callError converts the outcome of a wasmtime call into a Go error.
+func (f *function) callError(callErr wasmtime_error_t, trap wasm_trap_t) error {
+	if callErr != 0 {
+		return fmt.Errorf("call failed: %w", f.bindings.getErrorMessage(callErr, 0))
+	}
+	if trap != 0 {
+		trapErr := f.bindings.newTrapError(trap)
+		// A host function raised the trap: expose its error as the cause
+		if f.storeState != nil {
+			trapErr.hostErr = f.storeState.takeHostErr()
+		}
+		return fmt.Errorf("call failed (trap): %w", trapErr)
+	}
+	return nil
+}
+
+// stackSlots returns how many uint64 stack slots values of the given types
+// occupy. As in wazero, a v128 takes two slots, low half first, and any
+// other type takes one.
+func stackSlots(types []api.ValueType) int {
+	n := len(types)
+	for _, vt := range types {
+		if vt == api.ValueTypeV128 {
+			n++
+		}
+	}
+	return n
+}
+
+// isRawSignature reports whether all types are numbers, vectors or funcrefs,
+// which is what the unchecked call path supports.
+func isRawSignature(types ...[]api.ValueType) bool {
+	for _, ts := range types {
+		for _, vt := range ts {
+			switch vt {
+			case api.ValueTypeI32, api.ValueTypeI64, api.ValueTypeF32, api.ValueTypeF64, api.ValueTypeV128, api.ValueTypeFuncref:
+			default:
+				return false
+			}
+		}
+	}
+	return true
 }
 
 // functionDefinition implements api.FunctionDefinition.
@@ -381,6 +593,7 @@ func (fd *functionDefinition) ResultNames() []string {
 
 // Helper functions for value conversion
 
+// wasmValueTypeToAPI converts the kind of a wasm_valtype_t to api.ValueType.
 func wasmValueTypeToAPI(kind wasm_valkind_t) api.ValueType {
 	switch kind {
 	case WASM_I32:
@@ -393,9 +606,9 @@ func wasmValueTypeToAPI(kind wasm_valkind_t) api.ValueType {
 		return api.ValueTypeF64
 	case WASM_V128:
 		return api.ValueTypeV128
-	case WASM_FUNCREF:
+	case WASM_VALKIND_FUNCREF:
 		return api.ValueTypeFuncref
-	case WASM_EXTERNREF:
+	case WASM_VALKIND_EXTERNREF:
 		return api.ValueTypeExternref
 	default:
 		return api.ValueTypeI32 // Default fallback
@@ -1,6 +1,7 @@
 package wasmtime
 
 import (
+	"fmt"
 	"io"
 	"io/fs"
 )
@@ -22,16 +23,21 @@ type ModuleConfig interface {
 	// WithEnvs sets multiple environment variables from a map.
 	WithEnvs(env map[string]string) ModuleConfig
 
-	// WithStdin configures standard input.
+	// WithStdin configures standard input. Readers other than os.Stdin are
+	// copied by a goroutine, and the guest reads EOF once r does, or once
+	// the context given to instantiation is done.
 	WithStdin(r io.Reader) ModuleConfig
 
-	// WithStdout configures standard output.
+	// WithStdout configures standard output. Writers other than os.Stdout
+	// are fed by a goroutine, and have received all the output once the
+	// module is closed, or once RunCommand returns.
 	WithStdout(w io.Writer) ModuleConfig
 
-	// WithStderr configures standard error.
+	// WithStderr configures standard error, like WithStdout.
 	WithStderr(w io.Writer) ModuleConfig
 
-	// WithFS sets the filesystem for WASI preopened directories.
+	// WithFS sets the filesystem preopened as "/". It requires the Go WASI
+	// implementation, see InstantiateGoWASI.
 	WithFS(filesystem fs.FS) ModuleConfig
 
 	// WithDirPreopen grants access to a host directory.
@@ -124,3 +130,24 @@ func (mc *moduleConfig) WithStartFunctions(names ...string) ModuleConfig {
 	mc.startFunctions = names
 	return mc
 }
+
+// wasiConfig translates the WASI settings of mc into the configuration of
+// the instance's WASI context.
+func (mc *moduleConfig) wasiConfig() (*wasiConfig, error) {
+	if mc.filesystem != nil {
+		return nil, fmt.Errorf("WithFS is not supported by wasmtime's WASI, use WithDirPreopen or InstantiateGoWASI")
+	}
+
+	w := NewWASIConfig().(*wasiConfig)
+	w.args = mc.args
+	w.WithEnvs(mc.env)
+	for guestPath, hostPath := range mc.preopens {
+		w.WithPreopenDir(hostPath, guestPath)
+	}
+
+	w.stdin = mc.stdin
+	w.stdout = mc.stdout
+	w.stderr = mc.stderr
+
+	return w, nil
+}
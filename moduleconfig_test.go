@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -185,23 +186,136 @@ func TestCompiledModuleIntrospection(t *testing.T) {
 func TestMemoryDefinition(t *testing.T) {
 	tests := []struct {
 		name       string
-		min        uint32
-		max        uint32
+		min        uint64
+		max        uint64
 		maxEncoded bool
+		is64       bool
+		shared     bool
 	}{
-		{"min_only", 1, 0, false},
-		{"with_max", 1, 10, true},
-		{"large_min", 100, 1000, true},
-		{"zero_min", 0, 5, true},
+		{"min_only", 1, 0, false, false, false},
+		{"with_max", 1, 10, true, false, false},
+		{"large_min", 100, 1000, true, false, false},
+		{"zero_min", 0, 5, true, false, false},
+		{"memory64", 1, 1 << 40, true, true, false},
+		{"shared", 1, 16, true, false, true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			md := newMemoryDefinition(tt.min, tt.max, tt.maxEncoded)
+			md := newMemoryDefinition(tt.min, tt.max, tt.maxEncoded, tt.is64, tt.shared)
 
 			assert.Equal(t, tt.min, md.Min())
 			assert.Equal(t, tt.max, md.Max())
 			assert.Equal(t, tt.maxEncoded, md.IsMaxEncoded())
+			assert.Equal(t, tt.is64, md.Is64())
+			assert.Equal(t, tt.shared, md.IsShared())
 		})
 	}
 }
+
+func TestInstantiateModule(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntime(ctx)
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	host := r.NewHostModuleBuilder("env")
+	host.NewFunctionBuilder("double", nil, nil).
+		WithFunc(func(v int32) int32 { return v * 2 }).
+		Export("double")
+	require.NoError(t, host.Instantiate(ctx))
+	defer host.Close(ctx)
+
+	// _start records argc and envc, init bumps a counter, and fail exits
+	// with status 3.
+	wat := `
+	(module
+		(import "wasi_snapshot_preview1" "args_sizes_get"
+			(func $args_sizes_get (param i32 i32) (result i32)))
+		(import "wasi_snapshot_preview1" "environ_sizes_get"
+			(func $environ_sizes_get (param i32 i32) (result i32)))
+		(import "wasi_snapshot_preview1" "proc_exit"
+			(func $proc_exit (param i32)))
+		(import "env" "double" (func $double (param i32) (result i32)))
+		(memory (export "memory") 1)
+		(global $argc (export "argc") (mut i32) (i32.const -1))
+		(global $envc (export "envc") (mut i32) (i32.const -1))
+		(global $counter (export "counter") (mut i32) (i32.const 0))
+		(func (export "_start")
+			(drop (call $args_sizes_get (i32.const 0) (i32.const 4)))
+			(global.set $argc (i32.load (i32.const 0)))
+			(drop (call $environ_sizes_get (i32.const 0) (i32.const 4)))
+			(global.set $envc (i32.load (i32.const 0)))
+		)
+		(func (export "init")
+			(global.set $counter (i32.add (global.get $counter) (i32.const 1)))
+		)
+		(func (export "double") (param i32) (result i32)
+			(call $double (local.get 0))
+		)
+		(func (export "fail")
+			(call $proc_exit (i32.const 3))
+		)
+	)`
+
+	compiled, err := r.CompileModule(ctx, []byte(wat))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	t.Run("wasi_context_per_instance", func(t *testing.T) {
+		first, err := r.InstantiateModule(ctx, compiled, NewModuleConfig().
+			WithName("first").
+			WithArgs("prog", "a", "b").
+			WithEnv("K", "V"))
+		require.NoError(t, err)
+		defer first.Close(ctx)
+
+		second, err := r.InstantiateModule(ctx, compiled, NewModuleConfig().
+			WithName("second").
+			WithArgs("prog"))
+		require.NoError(t, err)
+		defer second.Close(ctx)
+
+		assert.Equal(t, "first", first.Name())
+		assert.Equal(t, uint64(3), first.ExportedGlobal("argc").Get(ctx))
+		assert.Equal(t, uint64(1), first.ExportedGlobal("envc").Get(ctx))
+		assert.Equal(t, "second", second.Name())
+		assert.Equal(t, uint64(1), second.ExportedGlobal("argc").Get(ctx))
+		assert.Equal(t, uint64(0), second.ExportedGlobal("envc").Get(ctx))
+
+		// Host functions are reachable from every store
+		results, err := second.ExportedFunction("double").Call(ctx, EncodeI32(21))
+		require.NoError(t, err)
+		assert.Equal(t, int32(42), DecodeI32(results[0]))
+	})
+
+	t.Run("start_functions", func(t *testing.T) {
+		mod, err := r.InstantiateModule(ctx, compiled, NewModuleConfig().
+			WithStartFunctions("init", "missing", "init"))
+		require.NoError(t, err)
+		defer mod.Close(ctx)
+
+		assert.Equal(t, uint64(2), mod.ExportedGlobal("counter").Get(ctx))
+		assert.Equal(t, uint64(0xffffffff), mod.ExportedGlobal("argc").Get(ctx), "_start is not run")
+	})
+
+	t.Run("failing_start_function", func(t *testing.T) {
+		_, err := r.InstantiateModule(ctx, compiled, NewModuleConfig().WithStartFunctions("fail"))
+		var exitErr *WASIExitError
+		require.ErrorAs(t, err, &exitErr)
+		assert.Equal(t, int32(3), exitErr.ExitCode)
+	})
+
+	t.Run("close", func(t *testing.T) {
+		mod, err := r.InstantiateModule(ctx, compiled, NewModuleConfig())
+		require.NoError(t, err)
+		require.NoError(t, mod.Close(ctx))
+		require.NoError(t, mod.Close(ctx))
+		assert.Nil(t, mod.ExportedFunction("double"))
+	})
+
+	t.Run("unsupported_settings", func(t *testing.T) {
+		_, err := r.InstantiateModule(ctx, compiled, NewModuleConfig().WithFS(fstest.MapFS{}))
+		assert.Error(t, err)
+	})
+}
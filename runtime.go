@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"runtime"
+	"sync"
 
 	"github.com/rvigee/purego-wasmtime/api"
 )
@@ -14,14 +15,50 @@ type Runtime interface {
 	CompileModule(ctx context.Context, binary []byte) (CompiledModule, error)
 
 	// Instantiate instantiates a compiled module without WASI.
+	// Reactor modules get their _initialize export called.
 	Instantiate(ctx context.Context, compiled CompiledModule) (api.Module, error)
 
-	// InstantiateWithWASI instantiates a compiled module with WASI support.
+	// InstantiateWithWASI instantiates a compiled module with WASI support,
+	// in a store of its own with a WASI context of its own, configured by
+	// RuntimeConfig.WithWASI. Reactor modules get their _initialize export
+	// called, while commands are left for the caller to run, such as with
+	// RunCommand. Closing the module releases its store.
 	InstantiateWithWASI(ctx context.Context, compiled CompiledModule) (api.Module, error)
 
+	// InstantiateWithWASIConfig is like InstantiateWithWASI, with the WASI
+	// context configured by wasi rather than by the runtime, so that
+	// instances of one runtime can have different args, env, stdio and
+	// preopens.
+	InstantiateWithWASIConfig(ctx context.Context, compiled CompiledModule, wasi WASIConfig) (api.Module, error)
+
+	// InstantiateModule instantiates a compiled module in a store of its own,
+	// configured by config: its name, WASI args, env, stdio and preopens, and
+	// the start functions run before it is returned, by default _start for
+	// commands and _initialize for reactors. Closing the module releases its
+	// store.
+	//
+	// Host functions and shared memories are shared by all instances. Host
+	// globals, memories and tables belong to a store, so each instance with
+	// a store of its own imports its own copy, created from the host
+	// module's definition. Instances in different stores may run on
+	// different goroutines.
+	InstantiateModule(ctx context.Context, compiled CompiledModule, config ModuleConfig) (api.Module, error)
+
+	// RunCommand runs a WASI command module to completion in a store of its
+	// own, calling _start or the functions given by config.WithStartFunctions,
+	// and returns the exit status passed to proc_exit, or 0 if they returned
+	// normally. Other failures, such as traps, are returned as errors with an
+	// exit code of -1.
+	RunCommand(ctx context.Context, compiled CompiledModule, config ModuleConfig) (exitCode int, err error)
+
 	// NewHostModuleBuilder creates a builder for defining host modules (Go functions).
 	NewHostModuleBuilder(name string) HostModuleBuilder
 
+	// NewSharedMemory creates a memory that instances in any store of the
+	// runtime can import and access concurrently, from the threads proposal.
+	// It is made importable with HostModuleBuilder.ExportSharedMemory.
+	NewSharedMemory(minPages, maxPages uint32) (*SharedMemory, error)
+
 	// Close closes the runtime and releases resources.
 	Close(ctx context.Context) error
 }
@@ -33,6 +70,21 @@ type RuntimeConfig interface {
 
 	// WithCompilationCache sets the compilation cache for this runtime.
 	WithCompilationCache(cache CompilationCache) RuntimeConfig
+
+	// WithMemory64 enables or disables the memory64 proposal, which allows
+	// memories indexed by i64, such as (memory i64 1). Wasmtime's default
+	// applies when it is not called.
+	WithMemory64(enabled bool) RuntimeConfig
+
+	// WithThreads enables or disables the threads proposal, which allows
+	// shared memories and atomic instructions. Wasmtime's default applies
+	// when it is not called.
+	WithThreads(enabled bool) RuntimeConfig
+
+	// WithMemoryObserver sets a function called when memories exported by
+	// the runtime's instances and host modules grow. See MemoryObserver for
+	// when growth is noticed.
+	WithMemoryObserver(observer MemoryObserver) RuntimeConfig
 }
 
 type runtimeConfig struct {
@@ -41,6 +93,9 @@ type runtimeConfig struct {
 	libraryPath  string
 	autoDownload bool
 	version      string
+	memory64     *bool // nil keeps wasmtime's default
+	threads      *bool // nil keeps wasmtime's default
+	observer     MemoryObserver
 }
 
 func (rc *runtimeConfig) WithWASI(wasi WASIConfig) RuntimeConfig {
@@ -53,6 +108,21 @@ func (rc *runtimeConfig) WithCompilationCache(cache CompilationCache) RuntimeCon
 	return rc
 }
 
+func (rc *runtimeConfig) WithMemory64(enabled bool) RuntimeConfig {
+	rc.memory64 = &enabled
+	return rc
+}
+
+func (rc *runtimeConfig) WithThreads(enabled bool) RuntimeConfig {
+	rc.threads = &enabled
+	return rc
+}
+
+func (rc *runtimeConfig) WithMemoryObserver(observer MemoryObserver) RuntimeConfig {
+	rc.observer = observer
+	return rc
+}
+
 func (rc *runtimeConfig) WithLibraryPath(path string) RuntimeConfig {
 	rc.libraryPath = path
 	rc.autoDownload = false // Disable auto-download when custom path is set
@@ -76,10 +146,26 @@ func NewRuntimeConfig() RuntimeConfig {
 type wasmRuntime struct {
 	engine      wasm_engine_t
 	store       wasmtime_store_t
+	storeState  *storeState
 	linker      wasmtime_linker_t
 	config      *runtimeConfig
 	bindings    *bindings
 	libraryPath string
+
+	hostModulesMu sync.Mutex
+	hostModules   []*hostModuleBuilder // Instantiated host modules, closed with the runtime
+
+	modulesMu sync.Mutex
+	modules   []*module // Modules owning their store, closed with the runtime
+
+	linkMu sync.Mutex // Held while the linker is modified or instantiates
+
+	sharedMu       sync.Mutex
+	sharedMemories []*SharedMemory // Open shared memory handles, closed with the runtime
+
+	wasiMu       sync.Mutex
+	wasiDefined  bool          // Whether WASI was defined in the linker
+	goWASIConfig *moduleConfig // Config given to InstantiateGoWASI, nil with wasmtime's WASI
 }
 
 // NewRuntime creates a new WebAssembly runtime with default configuration.
@@ -123,25 +209,33 @@ func NewRuntimeWithConfig(ctx context.Context, config RuntimeConfig) (Runtime, e
 		return nil, fmt.Errorf("failed to create bindings: %w", err)
 	}
 
-	//Create engine
-	enginePtr := bindings.wasm_engine_new()
+	// Create engine, which takes ownership of its config
+	engineConfig := bindings.wasm_config_new()
+	if rc.memory64 != nil {
+		bindings.wasmtime_config_wasm_memory64_set(engineConfig, *rc.memory64)
+	}
+	if rc.threads != nil {
+		bindings.wasmtime_config_wasm_threads_set(engineConfig, *rc.threads)
+	}
+	enginePtr := bindings.wasm_engine_new_with_config(engineConfig)
 	if enginePtr == 0 {
 		releaseLibrary(libPath)
 		return nil, fmt.Errorf("failed to create engine")
 	}
 
-	// Create store
-	storePtr := bindings.wasmtime_store_new(enginePtr, 0, 0)
-	if storePtr == 0 {
+	storePtr, state, err := newStore(bindings, enginePtr)
+	if err != nil {
 		bindings.wasm_engine_delete(enginePtr)
 		releaseLibrary(libPath)
-		return nil, fmt.Errorf("failed to create store")
+		return nil, err
 	}
+	state.observer = rc.observer
 
 	// Create linker
 	linkerPtr := bindings.wasmtime_linker_new(enginePtr)
 	if linkerPtr == 0 {
 		bindings.wasmtime_store_delete(storePtr)
+		globalStores.unregister(state.id)
 		bindings.wasm_engine_delete(enginePtr)
 		releaseLibrary(libPath)
 		return nil, fmt.Errorf("failed to create linker")
@@ -150,6 +244,7 @@ func NewRuntimeWithConfig(ctx context.Context, config RuntimeConfig) (Runtime, e
 	r := &wasmRuntime{
 		engine:      enginePtr,
 		store:       storePtr,
+		storeState:  state,
 		linker:      linkerPtr,
 		config:      rc,
 		bindings:    bindings,
@@ -161,6 +256,18 @@ func NewRuntimeWithConfig(ctx context.Context, config RuntimeConfig) (Runtime, e
 	return r, nil
 }
 
+// newStore creates a store in engine, recording the id of its Go-side state
+// in the data slot.
+func newStore(bindings *bindings, engine wasm_engine_t) (wasmtime_store_t, *storeState, error) {
+	state := globalStores.register()
+	store := bindings.wasmtime_store_new(engine, state.id, 0)
+	if store == 0 {
+		globalStores.unregister(state.id)
+		return 0, nil, fmt.Errorf("failed to create store")
+	}
+	return store, state, nil
+}
+
 func (r *wasmRuntime) CompileModule(ctx context.Context, binary []byte) (CompiledModule, error) {
 	// Try to compile as WASM first
 	var modulePtr wasmtime_module_t
@@ -205,74 +312,280 @@ func (r *wasmRuntime) Instantiate(ctx context.Context, compiled CompiledModule)
 		return nil, fmt.Errorf("invalid compiled module type")
 	}
 
-	var inst wasmtime_instance_t
-	var trap *wasm_trap_t
+	mod := &module{
+		store:      r.store,
+		storeState: r.storeState,
+		bindings:   r.bindings,
+		runtime:    r,
+	}
+	if err := r.link(mod, cm); err != nil {
+		return nil, fmt.Errorf("failed to instantiate: %w", err)
+	}
+	if err := r.storeState.watchExports(mod); err != nil {
+		return nil, err
+	}
+	if err := initializeReactor(ctx, mod); err != nil {
+		return nil, err
+	}
+	return mod, nil
+}
+
+func (r *wasmRuntime) InstantiateWithWASI(ctx context.Context, compiled CompiledModule) (api.Module, error) {
+	return r.instantiateWithWASI(ctx, compiled, r.config.wasiConfig)
+}
 
-	storeCtx := r.bindings.wasmtime_store_context(r.store)
+func (r *wasmRuntime) InstantiateWithWASIConfig(ctx context.Context, compiled CompiledModule, wasi WASIConfig) (api.Module, error) {
+	if wasi == nil {
+		return nil, fmt.Errorf("WASI config is nil")
+	}
+	if r.usesGoWASI() != nil {
+		return nil, fmt.Errorf("WASIConfig does not apply to the Go WASI implementation, use InstantiateModule")
+	}
+	return r.instantiateWithWASI(ctx, compiled, wasi)
+}
 
-	// Use linker to instantiate - this allows host functions to be resolved
-	// This matches wazero's behavior where the runtime automatically links imports
-	err := r.bindings.wasmtime_linker_instantiate(r.linker, storeCtx, cm.ptr, &inst, &trap)
+// instantiateWithWASI instantiates compiled in a new store with a WASI
+// context configured by wasi, or an empty one if it is nil.
+func (r *wasmRuntime) instantiateWithWASI(ctx context.Context, compiled CompiledModule, wasi WASIConfig) (api.Module, error) {
+	cm, ok := compiled.(*compiledModule)
+	if !ok {
+		return nil, fmt.Errorf("invalid compiled module type")
+	}
+	if err := r.defineWASI(); err != nil {
+		return nil, err
+	}
 
-	runtime.KeepAlive(r)
-	runtime.KeepAlive(cm)
+	// The Go implementation ignores WASIConfig: each instance gets a fresh
+	// context from the config given to InstantiateGoWASI
+	var goWASI *goWASI
+	if goWASIConfig := r.usesGoWASI(); goWASIConfig != nil {
+		var err error
+		if goWASI, err = newGoWASI(goWASIConfig); err != nil {
+			return nil, fmt.Errorf("failed to configure Go WASI: %w", err)
+		}
+	}
 
-	if err != 0 {
-		return nil, fmt.Errorf("failed to instantiate: %w", r.bindings.getErrorMessage(err, 0))
+	mod, err := r.newStoreModule("")
+	if err != nil {
+		return nil, err
 	}
-	if trap != nil {
-		return nil, fmt.Errorf("failed to instantiate (trap): %w", r.bindings.getErrorMessage(0, *trap))
+	mod.storeState.goWASI = goWASI
+	if goWASI == nil {
+		if wasi == nil {
+			wasi = NewWASIConfig()
+		}
+		storeCtx := r.bindings.wasmtime_store_context(mod.store)
+		if mod.stdio, err = wasi.apply(ctx, storeCtx, r.bindings); err != nil {
+			mod.Close(ctx)
+			return nil, fmt.Errorf("failed to apply WASI config: %w", err)
+		}
 	}
 
-	return &module{
-		inst:     inst,
-		store:    r.store,
-		bindings: r.bindings,
-	}, nil
+	if err := r.link(mod, cm); err != nil {
+		mod.Close(ctx)
+		return nil, fmt.Errorf("failed to instantiate with WASI: %w", err)
+	}
+	if err := mod.storeState.watchExports(mod); err != nil {
+		mod.Close(ctx)
+		return nil, err
+	}
+	if err := initializeReactor(ctx, mod); err != nil {
+		mod.Close(ctx)
+		return nil, err
+	}
+	return mod, nil
 }
 
-func (r *wasmRuntime) InstantiateWithWASI(ctx context.Context, compiled CompiledModule) (api.Module, error) {
+func (r *wasmRuntime) InstantiateModule(ctx context.Context, compiled CompiledModule, config ModuleConfig) (api.Module, error) {
+	mod, mc, err := r.instantiateModule(ctx, compiled, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Run the start functions, skipping those the module does not export
+	for _, name := range mc.startFunctionsFor(mod) {
+		fn := mod.ExportedFunction(name)
+		if fn == nil {
+			continue
+		}
+		_, err := fn.(*function).call(ctx)
+		if isExit(err, 0) {
+			// The program ended, so later start functions must not run
+			break
+		}
+		if err != nil {
+			mod.Close(ctx)
+			return nil, fmt.Errorf("module %q: start function %s failed: %w", mc.name, name, err)
+		}
+	}
+
+	return mod, nil
+}
+
+// instantiateModule instantiates compiled in a new store configured by
+// config, without running any start function.
+func (r *wasmRuntime) instantiateModule(ctx context.Context, compiled CompiledModule, config ModuleConfig) (*module, *moduleConfig, error) {
 	cm, ok := compiled.(*compiledModule)
 	if !ok {
-		return nil, fmt.Errorf("invalid compiled module type")
+		return nil, nil, fmt.Errorf("invalid compiled module type")
+	}
+	mc, ok := config.(*moduleConfig)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid module config type")
 	}
 
-	// Apply WASI configuration if provided
-	if r.config.wasiConfig != nil {
-		storeCtx := r.bindings.wasmtime_store_context(r.store)
-		if err := r.config.wasiConfig.apply(storeCtx, r.bindings); err != nil {
-			return nil, fmt.Errorf("failed to apply WASI config: %w", err)
+	// The Go WASI implementation is set up in the runtime before any
+	// instance, so it is known whether this one uses it
+	if err := r.defineWASI(); err != nil {
+		return nil, nil, err
+	}
+	var wasi *wasiConfig
+	var goWASI *goWASI
+	var err error
+	if r.usesGoWASI() != nil {
+		goWASI, err = newGoWASI(mc)
+	} else {
+		wasi, err = mc.wasiConfig()
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid module config: %w", err)
+	}
+
+	mod, err := r.newStoreModule(mc.name)
+	if err != nil {
+		return nil, nil, err
+	}
+	mod.storeState.goWASI = goWASI
+
+	if wasi != nil {
+		storeCtx := r.bindings.wasmtime_store_context(mod.store)
+		if mod.stdio, err = wasi.apply(ctx, storeCtx, r.bindings); err != nil {
+			mod.Close(ctx)
+			return nil, nil, fmt.Errorf("failed to apply WASI config: %w", err)
 		}
 	}
 
-	// Define WASI in linker
-	err := r.bindings.wasmtime_linker_define_wasi(r.linker)
-	if err != 0 {
-		return nil, fmt.Errorf("failed to define WASI: %w", r.bindings.getErrorMessage(err, 0))
+	if err := r.link(mod, cm); err != nil {
+		mod.Close(ctx)
+		return nil, nil, fmt.Errorf("failed to instantiate module %q: %w", mc.name, err)
 	}
+	if err := mod.storeState.watchExports(mod); err != nil {
+		mod.Close(ctx)
+		return nil, nil, err
+	}
+
+	return mod, mc, nil
+}
+
+// usesGoWASI returns the config given to InstantiateGoWASI, or nil if the
+// runtime uses wasmtime's WASI.
+func (r *wasmRuntime) usesGoWASI() *moduleConfig {
+	r.wasiMu.Lock()
+	defer r.wasiMu.Unlock()
+	return r.goWASIConfig
+}
+
+// newStoreModule creates a module owning a new store, not yet instantiated.
+// It is closed with the runtime unless closed before.
+func (r *wasmRuntime) newStoreModule(name string) (*module, error) {
+	store, state, err := newStore(r.bindings, r.engine)
+	if err != nil {
+		return nil, err
+	}
+	state.observer = r.config.observer
+	mod := &module{
+		store:      store,
+		storeState: state,
+		name:       name,
+		bindings:   r.bindings,
+		runtime:    r,
+		ownsStore:  true,
+	}
+	r.trackModule(mod)
+	return mod, nil
+}
 
-	// Instantiate using linker
-	var inst wasmtime_instance_t
-	var trap *wasm_trap_t
+// link instantiates cm in the store of mod, resolving its imports with the
+// linker.
+func (r *wasmRuntime) link(mod *module, cm *compiledModule) error {
+	// The linker holds the host globals, memories and tables of one store
+	// at a time, so defining and instantiating must not interleave. Host
+	// functions called by a start section therefore cannot instantiate.
+	r.linkMu.Lock()
+	defer r.linkMu.Unlock()
+
+	storeCtx := r.bindings.wasmtime_store_context(mod.store)
+	if err := r.defineStoreExterns(mod, storeCtx); err != nil {
+		return err
+	}
 
-	storeCtx := r.bindings.wasmtime_store_context(r.store)
-	err2 := r.bindings.wasmtime_linker_instantiate(r.linker, storeCtx, cm.ptr, &inst, &trap)
+	var trap wasm_trap_t
+	err := r.bindings.wasmtime_linker_instantiate(r.linker, storeCtx, cm.ptr, &mod.inst, &trap)
 
 	runtime.KeepAlive(r)
 	runtime.KeepAlive(cm)
 
-	if err2 != 0 {
-		return nil, fmt.Errorf("failed to instantiate with WASI: %w", r.bindings.getErrorMessage(err2, 0))
+	if err != 0 {
+		return r.bindings.getErrorMessage(err, 0)
 	}
-	if trap != nil {
-		return nil, fmt.Errorf("failed to instantiate with WASI (trap): %w", r.bindings.getErrorMessage(0, *trap))
+	if trap != 0 {
+		return fmt.Errorf("trap: %w", r.bindings.getErrorMessage(0, trap))
 	}
+	return nil
+}
 
-	return &module{
-		inst:     inst,
-		store:    r.store,
-		bindings: r.bindings,
-	}, nil
+// defineStoreExterns defines in the linker the host globals, memories and
+// tables of the store of mod, replacing those of the store linked before.
+// Stores other than the runtime's get new ones, so that each instance with
+// a store of its own also has its own env.memory or __stack_pointer.
+func (r *wasmRuntime) defineStoreExterns(mod *module, storeCtx wasmtime_context_t) error {
+	r.hostModulesMu.Lock()
+	var externs []*storeExtern
+	for _, hmb := range r.hostModules {
+		externs = append(externs, hmb.externs...)
+	}
+	r.hostModulesMu.Unlock()
+	if len(externs) == 0 {
+		return nil
+	}
+
+	r.bindings.wasmtime_linker_allow_shadowing(r.linker, true)
+	defer r.bindings.wasmtime_linker_allow_shadowing(r.linker, false)
+	for _, e := range externs {
+		ext := e.runtimeExt
+		if mod.store != r.store {
+			var err error
+			if ext, err = e.create(r.bindings, storeCtx); err != nil {
+				return fmt.Errorf("failed to create host %s %s::%s: %w", e.kind, e.module, e.name, err)
+			}
+			if ext.kind == WASMTIME_EXTERN_MEMORY {
+				mod.storeState.watchMemory(r.bindings, storeCtx, nil, e.module, e.name, *ext.AsMemory())
+			}
+		}
+		module, name := []byte(e.module+"\x00"), []byte(e.name+"\x00")
+		if err := r.bindings.wasmtime_linker_define(r.linker, storeCtx, &module[0], uintptr(len(e.module)), &name[0], uintptr(len(e.name)), &ext); err != 0 {
+			return fmt.Errorf("failed to define host %s %s::%s: %w", e.kind, e.module, e.name, r.bindings.getErrorMessage(err, 0))
+		}
+	}
+	return nil
+}
+
+// defineWASI adds the WASI imports to the linker the first time it is needed.
+// Each store still gets its own WASI context.
+func (r *wasmRuntime) defineWASI() error {
+	r.wasiMu.Lock()
+	defer r.wasiMu.Unlock()
+	if r.wasiDefined {
+		// Either wasmtime's WASI or the Go implementation
+		return nil
+	}
+	r.linkMu.Lock()
+	defer r.linkMu.Unlock()
+	if err := r.bindings.wasmtime_linker_define_wasi(r.linker); err != 0 {
+		return fmt.Errorf("failed to define WASI: %w", r.bindings.getErrorMessage(err, 0))
+	}
+	r.wasiDefined = true
+	return nil
 }
 
 func (r *wasmRuntime) Close(ctx context.Context) error {
@@ -281,6 +594,68 @@ func (r *wasmRuntime) Close(ctx context.Context) error {
 	return nil
 }
 
+// trackHostModule records an instantiated host module so that its functions
+// are released when the runtime closes.
+func (r *wasmRuntime) trackHostModule(hmb *hostModuleBuilder) {
+	r.hostModulesMu.Lock()
+	defer r.hostModulesMu.Unlock()
+	for _, m := range r.hostModules {
+		if m == hmb {
+			return
+		}
+	}
+	r.hostModules = append(r.hostModules, hmb)
+}
+
+func (r *wasmRuntime) untrackHostModule(hmb *hostModuleBuilder) {
+	r.hostModulesMu.Lock()
+	defer r.hostModulesMu.Unlock()
+	for i, m := range r.hostModules {
+		if m == hmb {
+			r.hostModules = append(r.hostModules[:i], r.hostModules[i+1:]...)
+			return
+		}
+	}
+}
+
+// trackModule records a module owning its store so that the store is
+// released when the runtime closes.
+func (r *wasmRuntime) trackModule(m *module) {
+	r.modulesMu.Lock()
+	defer r.modulesMu.Unlock()
+	r.modules = append(r.modules, m)
+}
+
+// trackSharedMemory records a shared memory handle so that it is released
+// when the runtime closes.
+func (r *wasmRuntime) trackSharedMemory(m *SharedMemory) {
+	r.sharedMu.Lock()
+	defer r.sharedMu.Unlock()
+	r.sharedMemories = append(r.sharedMemories, m)
+}
+
+func (r *wasmRuntime) untrackSharedMemory(m *SharedMemory) {
+	r.sharedMu.Lock()
+	defer r.sharedMu.Unlock()
+	for i, other := range r.sharedMemories {
+		if other == m {
+			r.sharedMemories = append(r.sharedMemories[:i], r.sharedMemories[i+1:]...)
+			return
+		}
+	}
+}
+
+func (r *wasmRuntime) untrackModule(m *module) {
+	r.modulesMu.Lock()
+	defer r.modulesMu.Unlock()
+	for i, other := range r.modules {
+		if other == m {
+			r.modules = append(r.modules[:i], r.modules[i+1:]...)
+			return
+		}
+	}
+}
+
 func (r *wasmRuntime) finalize() {
 	if r.linker != 0 {
 		r.bindings.wasmtime_linker_delete(r.linker)
@@ -290,6 +665,36 @@ func (r *wasmRuntime) finalize() {
 		r.bindings.wasmtime_store_delete(r.store)
 		r.store = 0
 	}
+	if r.storeState != nil {
+		r.storeState.goWASI.close()
+		globalStores.unregister(r.storeState.id)
+		r.storeState = nil
+	}
+	// The store is gone, so no guest can reach the host functions anymore
+	r.hostModulesMu.Lock()
+	hostModules := r.hostModules
+	r.hostModules = nil
+	r.hostModulesMu.Unlock()
+	for _, hmb := range hostModules {
+		globalRegistry.unregister(hmb.funcIDs...)
+		hmb.funcIDs = nil
+	}
+	// Shared memory handles must go before the library is released
+	r.sharedMu.Lock()
+	sharedMemories := r.sharedMemories
+	r.sharedMemories = nil
+	r.sharedMu.Unlock()
+	for _, m := range sharedMemories {
+		m.Close()
+	}
+	// Stores must go before the engine they were created in
+	r.modulesMu.Lock()
+	modules := r.modules
+	r.modules = nil
+	r.modulesMu.Unlock()
+	for _, m := range modules {
+		m.Close(context.Background())
+	}
 	if r.engine != 0 {
 		r.bindings.wasm_engine_delete(r.engine)
 		r.engine = 0
@@ -0,0 +1,303 @@
+package wasmtime
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/rvigee/purego-wasmtime/api"
+)
+
+// SharedMemory is a linear memory from the threads proposal. Unlike other
+// memories, it does not belong to a store: instances in any store of the
+// runtime can import it, and goroutines can access it concurrently, so that
+// guests running on different goroutines communicate through it with atomic
+// instructions.
+//
+// Shared memories are created with Runtime.NewSharedMemory and made
+// importable with HostModuleBuilder.ExportSharedMemory. Modules defining their
+// own shared memory return a SharedMemory from ExportedMemory.
+//
+// Besides the helpers of api.Memory, which are not atomic, SharedMemory
+// provides atomic loads, stores and read-modify-write operations, which are
+// sequentially consistent like those of WebAssembly, and Notify to wake
+// guests blocked in memory.atomic.wait32 or memory.atomic.wait64.
+type SharedMemory struct {
+	memoryAccessors
+	ptr      wasmtime_sharedmemory_t
+	runtime  *wasmRuntime
+	bindings *bindings
+
+	notifyMu sync.Mutex
+	notifier *module      // Instance running memory.atomic.notify, created by the first Notify
+	notify   api.Function // The notify export of notifier
+}
+
+var _ api.Memory = (*SharedMemory)(nil)
+
+// newSharedMemory wraps a shared memory handle, taking ownership of it. The
+// handle is released by Close or with the runtime, before its library is.
+func newSharedMemory(ptr wasmtime_sharedmemory_t, r *wasmRuntime, bindings *bindings) *SharedMemory {
+	m := &SharedMemory{ptr: ptr, runtime: r, bindings: bindings}
+	m.memoryAccessors = memoryAccessors{at: m.view}
+	if r != nil {
+		r.trackSharedMemory(m)
+	}
+	return m
+}
+
+// NewSharedMemory creates a shared memory of minPages 64KiB pages, which can
+// grow up to maxPages. Shared memories must declare their maximum size, and
+// modules importing one must declare it shared with the same maximum, as in
+// (import "env" "memory" (memory 1 16 shared)).
+//
+// The threads proposal may need to be enabled with RuntimeConfig.WithThreads.
+func (r *wasmRuntime) NewSharedMemory(minPages, maxPages uint32) (*SharedMemory, error) {
+	memoryType := r.bindings.wasmtime_memorytype_new(uint64(minPages), true, uint64(maxPages), false, true)
+	defer r.bindings.wasm_memorytype_delete(memoryType)
+
+	var ptr wasmtime_sharedmemory_t
+	if err := r.bindings.wasmtime_sharedmemory_new(r.engine, memoryType, &ptr); err != 0 {
+		return nil, fmt.Errorf("failed to create shared memory: %w", r.bindings.getErrorMessage(err, 0))
+	}
+	return newSharedMemory(ptr, r, r.bindings), nil
+}
+
+// Close releases this handle to the shared memory. The memory itself lives on
+// for as long as instances import it. Handles not closed are released when
+// the runtime closes.
+func (m *SharedMemory) Close() error {
+	if m.runtime != nil {
+		m.runtime.untrackSharedMemory(m)
+	}
+	m.notifyMu.Lock()
+	defer m.notifyMu.Unlock()
+	if m.notifier != nil {
+		m.notifier.Close(context.Background())
+		m.notifier, m.notify = nil, nil
+	}
+	if m.ptr != 0 {
+		m.bindings.wasmtime_sharedmemory_delete(m.ptr)
+		m.ptr = 0
+	}
+	return nil
+}
+
+// view returns the n bytes of the memory at offset, or false if they are out
+// of range. Shared memories never move, but they may grow concurrently, so
+// the size is fetched on every access.
+func (m *SharedMemory) view(offset, n uint64) ([]byte, bool) {
+	size := uint64(m.bindings.wasmtime_sharedmemory_data_size(m.ptr))
+	if offset > size || n > size-offset {
+		return nil, false
+	}
+	if n == 0 {
+		return []byte{}, true
+	}
+	data := m.bindings.wasmtime_sharedmemory_data(m.ptr)
+	return unsafe.Slice((*byte)(unsafe.Add(data, uintptr(offset))), n), true
+}
+
+func (m *SharedMemory) Data(ctx context.Context) unsafe.Pointer {
+	return m.bindings.wasmtime_sharedmemory_data(m.ptr)
+}
+
+func (m *SharedMemory) DataSize(ctx context.Context) uintptr {
+	return m.bindings.wasmtime_sharedmemory_data_size(m.ptr)
+}
+
+func (m *SharedMemory) Size(ctx context.Context) uint64 {
+	return m.bindings.wasmtime_sharedmemory_size(m.ptr)
+}
+
+func (m *SharedMemory) Grow(ctx context.Context, delta uint64) (uint64, bool) {
+	var prevSize uint64
+	if err := m.bindings.wasmtime_sharedmemory_grow(m.ptr, delta, &prevSize); err != 0 {
+		m.bindings.wasmtime_error_delete(err)
+		return 0, false
+	}
+	return prevSize, true
+}
+
+func (m *SharedMemory) Definition() api.MemoryDefinition {
+	memoryType := m.bindings.wasmtime_sharedmemory_type(m.ptr)
+	defer m.bindings.wasm_memorytype_delete(memoryType)
+	return memoryTypeDefinition(m.bindings, memoryType)
+}
+
+// aligned returns a pointer to the size bytes at offset, or nil if they are
+// out of range or not naturally aligned, as atomic instructions require.
+// The memory starts on a page boundary, so aligned offsets give aligned
+// pointers.
+func (m *SharedMemory) aligned(offset uint32, size uint64) unsafe.Pointer {
+	if uint64(offset)%size != 0 {
+		return nil
+	}
+	b, ok := m.view(uint64(offset), size)
+	if !ok {
+		return nil
+	}
+	return unsafe.Pointer(&b[0])
+}
+
+// The atomic helpers use the host byte order, which is little-endian like
+// WebAssembly on every platform purego supports. They return false if the
+// value is out of range or offset is not a multiple of its size.
+
+// AtomicLoadUint32 atomically reads a uint32 at offset.
+func (m *SharedMemory) AtomicLoadUint32(offset uint32) (uint32, bool) {
+	p := m.aligned(offset, 4)
+	if p == nil {
+		return 0, false
+	}
+	return atomic.LoadUint32((*uint32)(p)), true
+}
+
+// AtomicStoreUint32 atomically writes a uint32 at offset.
+func (m *SharedMemory) AtomicStoreUint32(offset, v uint32) bool {
+	p := m.aligned(offset, 4)
+	if p == nil {
+		return false
+	}
+	atomic.StoreUint32((*uint32)(p), v)
+	return true
+}
+
+// AtomicAddUint32 atomically adds delta to the uint32 at offset and returns
+// the new value.
+func (m *SharedMemory) AtomicAddUint32(offset, delta uint32) (uint32, bool) {
+	p := m.aligned(offset, 4)
+	if p == nil {
+		return 0, false
+	}
+	return atomic.AddUint32((*uint32)(p), delta), true
+}
+
+// AtomicCompareAndSwapUint32 atomically replaces the uint32 at offset with
+// new if it holds old, and reports whether it did.
+func (m *SharedMemory) AtomicCompareAndSwapUint32(offset, old, new uint32) (swapped, ok bool) {
+	p := m.aligned(offset, 4)
+	if p == nil {
+		return false, false
+	}
+	return atomic.CompareAndSwapUint32((*uint32)(p), old, new), true
+}
+
+// AtomicLoadUint64 atomically reads a uint64 at offset.
+func (m *SharedMemory) AtomicLoadUint64(offset uint32) (uint64, bool) {
+	p := m.aligned(offset, 8)
+	if p == nil {
+		return 0, false
+	}
+	return atomic.LoadUint64((*uint64)(p)), true
+}
+
+// AtomicStoreUint64 atomically writes a uint64 at offset.
+func (m *SharedMemory) AtomicStoreUint64(offset uint32, v uint64) bool {
+	p := m.aligned(offset, 8)
+	if p == nil {
+		return false
+	}
+	atomic.StoreUint64((*uint64)(p), v)
+	return true
+}
+
+// AtomicAddUint64 atomically adds delta to the uint64 at offset and returns
+// the new value.
+func (m *SharedMemory) AtomicAddUint64(offset uint32, delta uint64) (uint64, bool) {
+	p := m.aligned(offset, 8)
+	if p == nil {
+		return 0, false
+	}
+	return atomic.AddUint64((*uint64)(p), delta), true
+}
+
+// AtomicCompareAndSwapUint64 atomically replaces the uint64 at offset with
+// new if it holds old, and reports whether it did.
+func (m *SharedMemory) AtomicCompareAndSwapUint64(offset uint32, old, new uint64) (swapped, ok bool) {
+	p := m.aligned(offset, 8)
+	if p == nil {
+		return false, false
+	}
+	return atomic.CompareAndSwapUint64((*uint64)(p), old, new), true
+}
+
+// Notify wakes up to count guests waiting on offset with memory.atomic.wait32
+// or memory.atomic.wait64, and returns how many were woken. offset must be
+// a multiple of 4.
+//
+// The C API has no way to notify waiters, so the first call instantiates a
+// small module importing the memory in a store of its own, and every call
+// runs its memory.atomic.notify. Calls are serialized on that store.
+func (m *SharedMemory) Notify(ctx context.Context, offset, count uint32) (uint32, error) {
+	m.notifyMu.Lock()
+	defer m.notifyMu.Unlock()
+	if m.notify == nil {
+		if err := m.newNotifier(ctx); err != nil {
+			return 0, fmt.Errorf("failed to notify shared memory: %w", err)
+		}
+	}
+	if m.notifier.store == 0 {
+		return 0, fmt.Errorf("failed to notify shared memory: runtime is closed")
+	}
+	results, err := m.notify.Call(ctx, uint64(offset), uint64(count))
+	if err != nil {
+		return 0, fmt.Errorf("failed to notify shared memory: %w", err)
+	}
+	return DecodeU32(results[0]), nil
+}
+
+// newNotifier instantiates the module behind Notify.
+func (m *SharedMemory) newNotifier(ctx context.Context) error {
+	if m.runtime == nil || m.ptr == 0 {
+		return fmt.Errorf("shared memory is closed")
+	}
+	r := m.runtime
+
+	// The import matches any size up to the memory's own maximum
+	def := m.Definition()
+	memType, addrType := fmt.Sprintf("0 %d shared", def.Max()), "i32"
+	if def.Is64() {
+		memType, addrType = "i64 "+memType, "i64"
+	}
+	wat := fmt.Sprintf(`(module
+		(import "" "memory" (memory %s))
+		(func (export "notify") (param %s i32) (result i32)
+			(memory.atomic.notify (local.get 0) (local.get 1))))`, memType, addrType)
+	compiled, err := r.CompileModule(ctx, []byte(wat))
+	if err != nil {
+		return err
+	}
+	defer compiled.Close()
+
+	store, state, err := newStore(r.bindings, r.engine)
+	if err != nil {
+		return err
+	}
+	mod := &module{
+		store:      store,
+		storeState: state,
+		name:       "notify",
+		bindings:   r.bindings,
+		runtime:    r,
+		ownsStore:  true,
+	}
+	r.trackModule(mod)
+
+	var ext wasmtime_extern_t
+	ext.kind = WASMTIME_EXTERN_SHAREDMEMORY
+	*ext.AsSharedMemory() = m.ptr
+
+	var trap wasm_trap_t
+	storeCtx := r.bindings.wasmtime_store_context(store)
+	err2 := r.bindings.wasmtime_instance_new(storeCtx, compiled.(*compiledModule).ptr, &ext, 1, &mod.inst, &trap)
+	if err2 != 0 || trap != 0 {
+		mod.Close(ctx)
+		return r.bindings.getErrorMessage(err2, trap)
+	}
+
+	m.notifier, m.notify = mod, mod.ExportedFunction("notify")
+	return nil
+}
@@ -0,0 +1,207 @@
+package wasmtime
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedMemory(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntimeWithConfig(ctx, NewRuntimeConfig().WithThreads(true))
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	mem, err := r.NewSharedMemory(1, 4)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	host := r.NewHostModuleBuilder("env")
+	host.ExportSharedMemory("memory", mem)
+	require.NoError(t, host.Instantiate(ctx))
+	defer host.Close(ctx)
+
+	wat := `
+	(module
+		(import "env" "memory" (memory 1 4 shared))
+		(func (export "incr") (param $n i32)
+			(block $done
+				(loop $next
+					(br_if $done (i32.eqz (local.get $n)))
+					(drop (i32.atomic.rmw.add (i32.const 0) (i32.const 1)))
+					(local.set $n (i32.sub (local.get $n) (i32.const 1)))
+					(br $next))))
+		(func (export "wait") (param i32) (result i32)
+			(memory.atomic.wait32 (local.get 0) (i32.const 0) (i64.const -1)))
+		(func (export "size") (result i32)
+			(memory.size))
+	)`
+	compiled, err := r.CompileModule(ctx, []byte(wat))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	t.Run("definition", func(t *testing.T) {
+		def := mem.Definition()
+		assert.True(t, def.IsShared())
+		assert.Equal(t, uint64(1), def.Min())
+		assert.Equal(t, uint64(4), def.Max())
+	})
+
+	t.Run("stores on goroutines", func(t *testing.T) {
+		const goroutines, increments = 4, 1000
+		var wg sync.WaitGroup
+		errs := make(chan error, goroutines)
+		for range goroutines {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				mod, err := r.InstantiateModule(ctx, compiled, NewModuleConfig())
+				if err != nil {
+					errs <- err
+					return
+				}
+				defer mod.Close(ctx)
+				_, err = mod.ExportedFunction("incr").Call(ctx, increments)
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		v, ok := mem.AtomicLoadUint32(0)
+		require.True(t, ok)
+		assert.Equal(t, uint32(goroutines*increments), v)
+	})
+
+	t.Run("atomic helpers", func(t *testing.T) {
+		require.True(t, mem.AtomicStoreUint32(8, 1))
+		v, ok := mem.AtomicAddUint32(8, 2)
+		require.True(t, ok)
+		assert.Equal(t, uint32(3), v)
+
+		swapped, ok := mem.AtomicCompareAndSwapUint32(8, 1, 5)
+		require.True(t, ok)
+		assert.False(t, swapped)
+		swapped, ok = mem.AtomicCompareAndSwapUint32(8, 3, 5)
+		require.True(t, ok)
+		assert.True(t, swapped)
+		v32, ok := mem.ReadUint32Le(8)
+		require.True(t, ok)
+		assert.Equal(t, uint32(5), v32)
+
+		require.True(t, mem.AtomicStoreUint64(16, 1<<40))
+		v64, ok := mem.AtomicAddUint64(16, 1)
+		require.True(t, ok)
+		assert.Equal(t, uint64(1<<40+1), v64)
+		v64, ok = mem.AtomicLoadUint64(16)
+		require.True(t, ok)
+		assert.Equal(t, uint64(1<<40+1), v64)
+
+		_, ok = mem.AtomicLoadUint32(2)
+		assert.False(t, ok, "misaligned")
+		_, ok = mem.AtomicLoadUint64(12)
+		assert.False(t, ok, "misaligned")
+		assert.False(t, mem.AtomicStoreUint32(65536, 1), "out of range")
+	})
+
+	t.Run("notify", func(t *testing.T) {
+		mod, err := r.InstantiateModule(ctx, compiled, NewModuleConfig())
+		require.NoError(t, err)
+		defer mod.Close(ctx)
+
+		const addr = 64
+		require.True(t, mem.AtomicStoreUint32(addr, 0))
+		done := make(chan uint64, 1)
+		go func() {
+			results, err := mod.ExportedFunction("wait").Call(ctx, addr)
+			if err != nil {
+				done <- 99
+				return
+			}
+			done <- results[0]
+		}()
+
+		// The waiter may not be waiting yet, so notify until it is woken
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			woken, err := mem.Notify(ctx, addr, 1)
+			require.NoError(t, err)
+			if woken == 1 {
+				break
+			}
+			require.True(t, time.Now().Before(deadline), "waiter was never woken")
+			time.Sleep(time.Millisecond)
+		}
+		assert.Equal(t, uint64(0), <-done, "wait32 returns 0 when woken")
+	})
+
+	t.Run("grow", func(t *testing.T) {
+		mod, err := r.InstantiateModule(ctx, compiled, NewModuleConfig())
+		require.NoError(t, err)
+		defer mod.Close(ctx)
+
+		prev, ok := mem.Grow(ctx, 1)
+		require.True(t, ok)
+		assert.Equal(t, uint64(1), prev)
+
+		results, err := mod.ExportedFunction("size").Call(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), results[0], "growth is visible to every instance")
+
+		_, ok = mem.Grow(ctx, 3)
+		assert.False(t, ok, "beyond the maximum")
+	})
+}
+
+func TestExportedSharedMemory(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntimeWithConfig(ctx, NewRuntimeConfig().WithThreads(true))
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	compiled, err := r.CompileModule(ctx, []byte(`(module (memory (export "memory") 1 2 shared))`))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.Instantiate(ctx, compiled)
+	require.NoError(t, err)
+	defer mod.Close(ctx)
+
+	mem, ok := mod.ExportedMemory("memory").(*SharedMemory)
+	require.True(t, ok)
+	defer mem.Close()
+
+	assert.True(t, mem.Definition().IsShared())
+	require.True(t, mem.WriteUint32Le(4, 7))
+	v, ok := mem.AtomicLoadUint32(4)
+	require.True(t, ok)
+	assert.Equal(t, uint32(7), v)
+
+	// A notify without waiters wakes none
+	woken, err := mem.Notify(ctx, 4, 1)
+	require.NoError(t, err)
+	assert.Zero(t, woken)
+}
+
+func TestSharedMemoryClosedWithRuntime(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntimeWithConfig(ctx, NewRuntimeConfig().WithThreads(true))
+	require.NoError(t, err)
+
+	closed, err := r.NewSharedMemory(1, 1)
+	require.NoError(t, err)
+	require.NoError(t, closed.Close())
+	open, err := r.NewSharedMemory(1, 1)
+	require.NoError(t, err)
+
+	// The runtime releases the handles left open before its library
+	require.NoError(t, r.Close(ctx))
+	assert.Zero(t, open.ptr)
+	assert.Empty(t, r.(*wasmRuntime).sharedMemories)
+}
@@ -0,0 +1,288 @@
+package wasmtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"github.com/rvigee/purego-wasmtime/api"
+)
+
+// Snapshotter is implemented by the modules instantiated by a Runtime, to
+// reset their state without instantiating them again:
+//
+//	snap, err := mod.(wasmtime.Snapshotter).Snapshot(ctx)
+//	// ... handle a request ...
+//	err = mod.(wasmtime.Snapshotter).Restore(ctx, snap)
+type Snapshotter interface {
+	// Snapshot captures the exported linear memories and mutable globals
+	// of the module.
+	//
+	// Only exported state is captured: memories and mutable globals the
+	// module keeps internal, such as the __stack_pointer most toolchains
+	// emit, keep their current value on Restore. Such modules are only
+	// consistent when snapshotted and restored between calls, while their
+	// internal state is at rest.
+	Snapshot(ctx context.Context) (*Snapshot, error)
+
+	// Restore writes a snapshot back into the module, which must have been
+	// instantiated from the same compiled module as the snapshotted one.
+	// Memories are grown back to their snapshotted size if needed.
+	// WebAssembly memories cannot shrink, so memories that grew since the
+	// snapshot keep their size, with the memory past the snapshot zeroed.
+	Restore(ctx context.Context, snap *Snapshot) error
+}
+
+// Snapshot is the state of a module captured by Snapshotter.Snapshot.
+//
+// The C API can only reach exported items, so memories and globals the
+// module does not export, such as the __stack_pointer of most toolchains,
+// are not captured: take snapshots while no call into the module is in
+// progress, when such internal state is back to its resting value.
+// Reference-typed globals are not captured either, since their values are
+// only meaningful within one store, nor are shared memories, which other
+// instances may be using.
+//
+// A Snapshot can be serialized with MarshalBinary to share a warmed-up
+// state across processes.
+type Snapshot struct {
+	Memories []MemorySnapshot
+	Globals  []GlobalSnapshot
+}
+
+// MemorySnapshot is the content of an exported linear memory.
+type MemorySnapshot struct {
+	// Name is the export name of the memory.
+	Name string
+	// Pages is the size of the memory in pages.
+	Pages uint64
+	// Data is the content of the memory, without its trailing zero bytes.
+	Data []byte
+}
+
+// GlobalSnapshot is the value of an exported mutable global.
+type GlobalSnapshot struct {
+	// Name is the export name of the global.
+	Name string
+	// Type is the value type of the global.
+	Type api.ValueType
+	// Lo and Hi hold the value, encoded as for api.Global. Hi is only used
+	// by v128 globals.
+	Lo, Hi uint64
+}
+
+// forEachExport calls fn with every export of the module, in order.
+func (m *module) forEachExport(fn func(name string, ext *wasmtime_extern_t) error) error {
+	if m.store == 0 {
+		return fmt.Errorf("module %q is closed", m.name)
+	}
+	storeCtx := m.bindings.wasmtime_store_context(m.store)
+	for i := uintptr(0); ; i++ {
+		var namePtr *byte
+		var nameLen uintptr
+		var ext wasmtime_extern_t
+		if !m.bindings.wasmtime_instance_export_nth(storeCtx, &m.inst, i, &namePtr, &nameLen, &ext) {
+			return nil
+		}
+		// The name is owned by the instance, so copy it
+		name := string(unsafe.Slice(namePtr, nameLen))
+		err := fn(name, &ext)
+		// Releases the handle of shared memories, a no-op for other kinds
+		m.bindings.wasmtime_extern_delete(&ext)
+		if err != nil {
+			return err
+		}
+		runtime.KeepAlive(m)
+	}
+}
+
+var _ Snapshotter = (*module)(nil)
+
+func (m *module) Snapshot(ctx context.Context) (*Snapshot, error) {
+	if m.store == 0 {
+		return nil, fmt.Errorf("module %q is closed", m.name)
+	}
+	snap := &Snapshot{}
+	storeCtx := m.bindings.wasmtime_store_context(m.store)
+	err := m.forEachExport(func(name string, ext *wasmtime_extern_t) error {
+		switch ext.kind {
+		case WASMTIME_EXTERN_MEMORY:
+			mem := newMemory(*ext.AsMemory(), m.store, storeCtx, m.bindings)
+			data := mem.bytes()
+			snap.Memories = append(snap.Memories, MemorySnapshot{
+				Name:  name,
+				Pages: mem.Size(ctx),
+				Data:  bytes.Clone(bytes.TrimRight(data, "\x00")),
+			})
+		case WASMTIME_EXTERN_GLOBAL:
+			g := newGlobal(*ext.AsGlobal(), m.store, storeCtx, m.bindings)
+			if !g.mutable || g.valType == api.ValueTypeFuncref || g.valType == api.ValueTypeExternref {
+				return nil
+			}
+			lo, hi := g.GetV128(ctx)
+			snap.Globals = append(snap.Globals, GlobalSnapshot{Name: name, Type: g.valType, Lo: lo, Hi: hi})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+func (m *module) Restore(ctx context.Context, snap *Snapshot) error {
+	if m.store == 0 {
+		return fmt.Errorf("module %q is closed", m.name)
+	}
+	for _, ms := range snap.Memories {
+		exported := m.ExportedMemory(ms.Name)
+		if exported == nil {
+			return fmt.Errorf("failed to restore memory %q: not exported by the module", ms.Name)
+		}
+		mem, ok := exported.(*memory)
+		if !ok {
+			if shared, ok := exported.(*SharedMemory); ok {
+				shared.Close()
+			}
+			return fmt.Errorf("failed to restore memory %q: shared memories are not restored", ms.Name)
+		}
+		if pages := mem.Size(ctx); pages < ms.Pages {
+			if _, ok := mem.Grow(ctx, ms.Pages-pages); !ok {
+				return fmt.Errorf("failed to restore memory %q: cannot grow to %d pages", ms.Name, ms.Pages)
+			}
+		}
+		data := mem.bytes()
+		if len(data) < len(ms.Data) {
+			return fmt.Errorf("failed to restore memory %q: snapshot is larger than the memory", ms.Name)
+		}
+		clear(data[copy(data, ms.Data):])
+	}
+	for _, gs := range snap.Globals {
+		g, ok := m.ExportedGlobal(gs.Name).(*global)
+		if !ok {
+			return fmt.Errorf("failed to restore global %q: not exported by the module", gs.Name)
+		}
+		if g.valType != gs.Type {
+			return fmt.Errorf("failed to restore global %q: it is %v, not %v", gs.Name, g.valType, gs.Type)
+		}
+		var err error
+		if gs.Type == api.ValueTypeV128 {
+			err = g.SetV128(ctx, gs.Lo, gs.Hi)
+		} else {
+			err = g.Set(ctx, gs.Lo)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore global %q: %w", gs.Name, err)
+		}
+	}
+	return nil
+}
+
+// snapshotMagic starts serialized snapshots, followed by a format version.
+const snapshotMagic = "wasmsnap\x01"
+
+var errSnapshotFormat = errors.New("invalid snapshot data")
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (s *Snapshot) MarshalBinary() ([]byte, error) {
+	b := []byte(snapshotMagic)
+	appendString := func(s string) {
+		b = binary.AppendUvarint(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+
+	b = binary.AppendUvarint(b, uint64(len(s.Memories)))
+	for _, ms := range s.Memories {
+		appendString(ms.Name)
+		b = binary.AppendUvarint(b, ms.Pages)
+		appendString(string(ms.Data))
+	}
+	b = binary.AppendUvarint(b, uint64(len(s.Globals)))
+	for _, gs := range s.Globals {
+		appendString(gs.Name)
+		b = append(b, byte(gs.Type))
+		b = binary.LittleEndian.AppendUint64(b, gs.Lo)
+		b = binary.LittleEndian.AppendUint64(b, gs.Hi)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (s *Snapshot) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(snapshotMagic)) {
+		return errSnapshotFormat
+	}
+	r := snapshotReader{data: data[len(snapshotMagic):]}
+
+	var snap Snapshot
+	for range r.count() {
+		ms := MemorySnapshot{Name: r.string()}
+		ms.Pages = r.uvarint()
+		ms.Data = []byte(r.string())
+		snap.Memories = append(snap.Memories, ms)
+	}
+	for range r.count() {
+		gs := GlobalSnapshot{Name: r.string()}
+		if b := r.bytes(17); b != nil {
+			gs.Type = api.ValueType(b[0])
+			gs.Lo = binary.LittleEndian.Uint64(b[1:])
+			gs.Hi = binary.LittleEndian.Uint64(b[9:])
+		}
+		snap.Globals = append(snap.Globals, gs)
+	}
+	if r.err || len(r.data) != 0 {
+		return errSnapshotFormat
+	}
+
+	*s = snap
+	return nil
+}
+
+// snapshotReader decodes serialized snapshots, recording the first error
+// rather than returning it from every call.
+type snapshotReader struct {
+	data []byte
+	err  bool
+}
+
+func (r *snapshotReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = true
+		r.data = nil
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// count reads a number of items, bounded by the remaining data so that
+// corrupt input cannot cause huge allocations.
+func (r *snapshotReader) count() int {
+	n := r.uvarint()
+	if n > uint64(len(r.data)) {
+		r.err = true
+		r.data = nil
+		return 0
+	}
+	return int(n)
+}
+
+func (r *snapshotReader) bytes(n uint64) []byte {
+	if n > uint64(len(r.data)) {
+		r.err = true
+		r.data = nil
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *snapshotReader) string() string {
+	return string(r.bytes(r.uvarint()))
+}
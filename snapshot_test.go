@@ -0,0 +1,152 @@
+package wasmtime
+
+import (
+	"testing"
+
+	"github.com/rvigee/purego-wasmtime/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotMarshal(t *testing.T) {
+	snap := &Snapshot{
+		Memories: []MemorySnapshot{{Name: "memory", Pages: 2, Data: []byte("state")}},
+		Globals: []GlobalSnapshot{
+			{Name: "counter", Type: api.ValueTypeI32, Lo: 42},
+			{Name: "vec", Type: api.ValueTypeV128, Lo: 1, Hi: 2},
+		},
+	}
+
+	data, err := snap.MarshalBinary()
+	require.NoError(t, err)
+
+	var decoded Snapshot
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, *snap, decoded)
+
+	for _, bad := range [][]byte{nil, []byte("not a snapshot"), data[:len(data)-1], append(data, 0)} {
+		assert.Error(t, decoded.UnmarshalBinary(bad))
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntime(ctx)
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	wat := `
+	(module
+		(memory (export "memory") 1)
+		(global $counter (export "counter") (mut i32) (i32.const 0))
+		(global (export "const") i32 (i32.const 7))
+		(func (export "work")
+			(global.set $counter (i32.add (global.get $counter) (i32.const 1)))
+			(i32.store (i32.const 100) (global.get $counter))
+			(drop (memory.grow (i32.const 1)))
+			(i32.store (i32.const 70000) (i32.const 1)))
+	)`
+
+	compiled, err := r.CompileModule(ctx, []byte(wat))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.Instantiate(ctx, compiled)
+	require.NoError(t, err)
+	defer mod.Close(ctx)
+
+	mem := mod.ExportedMemory("memory")
+	require.True(t, mem.WriteString(0, "warm"))
+
+	snap, err := mod.(Snapshotter).Snapshot(ctx)
+	require.NoError(t, err)
+	require.Len(t, snap.Memories, 1)
+	assert.Equal(t, uint64(1), snap.Memories[0].Pages)
+	assert.Equal(t, []byte("warm"), snap.Memories[0].Data)
+	assert.Equal(t, []GlobalSnapshot{{Name: "counter", Type: api.ValueTypeI32}}, snap.Globals, "immutable globals are skipped")
+
+	work := mod.ExportedFunction("work")
+	_, err = work.Call(ctx)
+	require.NoError(t, err)
+	_, err = work.Call(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), mod.ExportedGlobal("counter").Get(ctx))
+
+	require.NoError(t, mod.(Snapshotter).Restore(ctx, snap))
+	assert.Equal(t, uint64(0), mod.ExportedGlobal("counter").Get(ctx))
+	v, ok := mem.ReadUint32Le(100)
+	require.True(t, ok)
+	assert.Zero(t, v)
+	v, ok = mem.ReadUint32Le(70000)
+	require.True(t, ok)
+	assert.Zero(t, v, "memory grown since the snapshot is zeroed")
+	b, ok := mem.Read(0, 4)
+	require.True(t, ok)
+	assert.Equal(t, "warm", string(b))
+
+	t.Run("other instance", func(t *testing.T) {
+		data, err := snap.MarshalBinary()
+		require.NoError(t, err)
+		var decoded Snapshot
+		require.NoError(t, decoded.UnmarshalBinary(data))
+
+		fresh, err := r.InstantiateModule(ctx, compiled, NewModuleConfig())
+		require.NoError(t, err)
+		defer fresh.Close(ctx)
+
+		require.NoError(t, fresh.(Snapshotter).Restore(ctx, &decoded))
+		b, ok := fresh.ExportedMemory("memory").Read(0, 4)
+		require.True(t, ok)
+		assert.Equal(t, "warm", string(b))
+	})
+}
+
+func TestSnapshotRestoreSharedMemory(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntimeWithConfig(ctx, NewRuntimeConfig().WithThreads(true))
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	compiled, err := r.CompileModule(ctx, []byte(`(module (memory (export "memory") 1 2 shared))`))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.Instantiate(ctx, compiled)
+	require.NoError(t, err)
+	defer mod.Close(ctx)
+
+	snap := &Snapshot{Memories: []MemorySnapshot{{Name: "memory", Pages: 1, Data: []byte("warm")}}}
+	err = mod.(Snapshotter).Restore(ctx, snap)
+	assert.ErrorContains(t, err, "shared memories are not restored")
+}
+
+func TestSnapshotInternalGlobal(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntime(ctx)
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	compiled, err := r.CompileModule(ctx, []byte(`(module
+		(global $internal (mut i32) (i32.const 0))
+		(func (export "bump") (result i32)
+			(global.set $internal (i32.add (global.get $internal) (i32.const 1)))
+			(global.get $internal)))`))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.Instantiate(ctx, compiled)
+	require.NoError(t, err)
+	defer mod.Close(ctx)
+
+	snap, err := mod.(Snapshotter).Snapshot(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, snap.Globals, "non-exported globals are not captured")
+
+	bump := mod.ExportedFunction("bump")
+	_, err = bump.Call(ctx)
+	require.NoError(t, err)
+	require.NoError(t, mod.(Snapshotter).Restore(ctx, snap))
+	results, err := bump.Call(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), results[0], "Restore leaves non-exported globals alone")
+}
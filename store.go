@@ -0,0 +1,100 @@
+package wasmtime
+
+import (
+	"context"
+	"sync"
+)
+
+// storeState is the Go-side state attached to a wasmtime store.
+// Its id is stored in the store's data slot so that host callbacks,
+// which only receive a wasmtime_caller_t, can find it again.
+type storeState struct {
+	id uintptr
+
+	// ctx is the context of the innermost function.Call currently
+	// executing in this store, or nil when no call is in progress.
+	ctx context.Context
+
+	// hostErr is the error of the host function whose trap is unwinding
+	// through the guest, taken by the call that receives the trap.
+	hostErr error
+
+	// observer is the runtime's memory observer, and watched the memories
+	// it is told about.
+	observer MemoryObserver
+	watched  []*watchedMemory
+
+	// goWASI is the WASI context of the store when the runtime uses the Go
+	// WASI implementation.
+	goWASI *goWASI
+}
+
+// enter makes ctx the current call context and returns the previous one,
+// to be handed back to leave. Calls may nest when a host function calls
+// back into the guest, so the previous context is kept on the Go stack
+// rather than cleared.
+func (s *storeState) enter(ctx context.Context) context.Context {
+	prev := s.ctx
+	s.ctx = ctx
+	// Drop any host error left by a trap nobody collected, such as one
+	// raised while instantiating
+	s.hostErr = nil
+	return prev
+}
+
+// leave restores the call context returned by enter.
+func (s *storeState) leave(prev context.Context) {
+	s.ctx = prev
+}
+
+// takeHostErr returns and clears the error recorded by a failing host function.
+func (s *storeState) takeHostErr() error {
+	err := s.hostErr
+	s.hostErr = nil
+	return err
+}
+
+// storeRegistry maps store data ids to their Go-side state.
+type storeRegistry struct {
+	mu     sync.RWMutex
+	states map[uintptr]*storeState
+	nextID uintptr
+}
+
+var globalStores = &storeRegistry{
+	states: make(map[uintptr]*storeState),
+	nextID: 1,
+}
+
+// register allocates a new storeState with a unique id.
+func (r *storeRegistry) register() *storeState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := &storeState{id: r.nextID}
+	r.nextID++
+	r.states[s.id] = s
+	return s
+}
+
+// lookup returns the state registered under id, or nil.
+func (r *storeRegistry) lookup(id uintptr) *storeState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.states[id]
+}
+
+// unregister releases the state registered under id.
+func (r *storeRegistry) unregister(id uintptr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.states, id)
+}
+
+// stateFromContext returns the Go-side state of the store owning storeCtx.
+func (b *bindings) stateFromContext(storeCtx wasmtime_context_t) *storeState {
+	if storeCtx == 0 {
+		return nil
+	}
+	return globalStores.lookup(b.wasmtime_context_get_data(storeCtx))
+}
@@ -8,70 +8,88 @@ import (
 )
 
 // table implements api.Table for a WebAssembly table.
+// Funcref elements are encoded as with EncodeFuncref, and externref
+// elements are handles from NewExternRef.
 type table struct {
 	val      wasmtime_table_t
 	store    wasmtime_store_t
 	storeCtx wasmtime_context_t
+	elemType api.ValueType
 	bindings *bindings
 }
 
+// newTable wraps a wasmtime table living in the store behind storeCtx,
+// reading its element type once.
+func newTable(val wasmtime_table_t, store wasmtime_store_t, storeCtx wasmtime_context_t, bindings *bindings) *table {
+	t := &table{
+		val:      val,
+		store:    store,
+		storeCtx: storeCtx,
+		elemType: api.ValueTypeFuncref,
+		bindings: bindings,
+	}
+
+	tableType := bindings.wasmtime_table_type(storeCtx, &t.val)
+	if tableType != 0 {
+		defer bindings.wasm_tabletype_delete(tableType)
+		// The element type is owned by the table type
+		t.elemType = wasmValueTypeToAPI(bindings.wasm_valtype_kind(bindings.wasm_tabletype_element(tableType)))
+	}
+
+	return t
+}
+
 func (t *table) Type(ctx context.Context) api.ValueType {
-	// Wasmtime C API limitation: No wasmtime_table_type() API available.
-	// Defaulting to funcref as it's the most common table element type.
-	return api.ValueTypeFuncref
+	return t.elemType
 }
 
 func (t *table) Size(ctx context.Context) uint32 {
-	return t.bindings.wasmtime_table_size(t.storeCtx, &t.val)
+	return uint32(t.bindings.wasmtime_table_size(t.storeCtx, &t.val))
 }
 
 func (t *table) Grow(ctx context.Context, delta uint32) (uint32, bool) {
-	var prevSize uint32
-	// Initialize value for growth (typically null for funcref)
-	var val wasmtime_val_t
-	val.kind = WASM_FUNCREF
+	var prevSize uint64
+	// New elements are null
+	val := t.nullValue()
 
-	err := t.bindings.wasmtime_table_grow(t.storeCtx, &t.val, delta, &val, &prevSize)
+	err := t.bindings.wasmtime_table_grow(t.storeCtx, &t.val, uint64(delta), &val, &prevSize)
 	if err != 0 {
+		t.bindings.wasmtime_error_delete(err)
 		return 0, false
 	}
-	return prevSize, true
+	return uint32(prevSize), true
 }
 
 func (t *table) Get(ctx context.Context, index uint32) uint64 {
 	var val wasmtime_val_t
-	ok := t.bindings.wasmtime_table_get(t.storeCtx, &t.val, index, &val)
+	ok := t.bindings.wasmtime_table_get(t.storeCtx, &t.val, uint64(index), &val)
 	if !ok {
 		return 0
 	}
 
-	// Convert to uint64 based on type
-	switch val.kind {
-	case WASM_FUNCREF:
-		funcRef := val.GetFuncRef()
-		// Return the function's internal ID
-		return funcRef.store_id
-	case WASM_EXTERNREF:
-		return uint64(val.GetExternRef())
-	default:
-		return 0
-	}
+	var v [1]uint64
+	readStackValue(t.bindings, t.storeCtx, v[:], &val)
+	unrootValue(t.bindings, &val)
+	return v[0]
 }
 
 func (t *table) Set(ctx context.Context, index uint32, v uint64) error {
 	var val wasmtime_val_t
-	// Wasmtime C API limitation: Cannot query table element type.
-	// Assuming funcref as the default. For externref tables, this may not work correctly.
-	val.kind = WASM_FUNCREF
-
-	var funcRef wasmtime_func_t
-	funcRef.store_id = v
-
-	val.SetFuncRef(funcRef)
+	writeStackValue(t.bindings, t.storeCtx, []uint64{v}, t.elemType, &val)
+	defer unrootValue(t.bindings, &val)
 
-	ok := t.bindings.wasmtime_table_set(t.storeCtx, &t.val, index, &val)
-	if !ok {
-		return fmt.Errorf("failed to set table element at index %d", index)
+	if err := t.bindings.wasmtime_table_set(t.storeCtx, &t.val, uint64(index), &val); err != 0 {
+		return fmt.Errorf("failed to set table element at index %d: %w", index, t.bindings.getErrorMessage(err, 0))
 	}
 	return nil
 }
+
+// nullValue returns the null reference of the table's element type.
+func (t *table) nullValue() wasmtime_val_t {
+	var val wasmtime_val_t
+	val.kind = WASM_FUNCREF
+	if t.elemType == api.ValueTypeExternref {
+		val.kind = WASM_EXTERNREF
+	}
+	return val
+}
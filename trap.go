@@ -0,0 +1,188 @@
+package wasmtime
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// TrapCode identifies why WebAssembly execution trapped.
+// Values match wasmtime's wasmtime_trap_code_t.
+type TrapCode uint8
+
+const (
+	// TrapCodeStackOverflow means the call stack was exhausted.
+	TrapCodeStackOverflow TrapCode = iota
+	// TrapCodeMemoryOutOfBounds means a memory access was out of bounds.
+	TrapCodeMemoryOutOfBounds
+	// TrapCodeHeapMisaligned means an atomic memory access was misaligned.
+	TrapCodeHeapMisaligned
+	// TrapCodeTableOutOfBounds means a table access was out of bounds.
+	TrapCodeTableOutOfBounds
+	// TrapCodeIndirectCallToNull means call_indirect hit a null entry.
+	TrapCodeIndirectCallToNull
+	// TrapCodeBadSignature means call_indirect hit a function of another type.
+	TrapCodeBadSignature
+	// TrapCodeIntegerOverflow means an integer arithmetic operation overflowed.
+	TrapCodeIntegerOverflow
+	// TrapCodeIntegerDivisionByZero means an integer was divided by zero.
+	TrapCodeIntegerDivisionByZero
+	// TrapCodeBadConversionToInteger means a float could not be truncated to an integer.
+	TrapCodeBadConversionToInteger
+	// TrapCodeUnreachable means an unreachable instruction was executed.
+	TrapCodeUnreachable
+	// TrapCodeInterrupt means execution was interrupted, such as by an epoch deadline.
+	TrapCodeInterrupt
+	// TrapCodeOutOfFuel means execution ran out of the fuel it was given.
+	TrapCodeOutOfFuel
+	// TrapCodeAtomicWaitNonSharedMemory means memory.atomic.wait was used on non-shared memory.
+	TrapCodeAtomicWaitNonSharedMemory
+	// TrapCodeNullReference means a null reference was dereferenced.
+	TrapCodeNullReference
+	// TrapCodeArrayOutOfBounds means a GC array access was out of bounds.
+	TrapCodeArrayOutOfBounds
+	// TrapCodeAllocationTooLarge means a GC allocation was too large to succeed.
+	TrapCodeAllocationTooLarge
+	// TrapCodeCastFailure means a reference cast failed.
+	TrapCodeCastFailure
+
+	// TrapCodeUnknown is used for traps without a wasmtime trap code, such as
+	// those raised by a host function returning an error.
+	TrapCodeUnknown TrapCode = 255
+)
+
+var trapCodeNames = [...]string{
+	TrapCodeStackOverflow:             "stack overflow",
+	TrapCodeMemoryOutOfBounds:         "out of bounds memory access",
+	TrapCodeHeapMisaligned:            "misaligned memory access",
+	TrapCodeTableOutOfBounds:          "undefined element: out of bounds table access",
+	TrapCodeIndirectCallToNull:        "uninitialized element",
+	TrapCodeBadSignature:              "indirect call type mismatch",
+	TrapCodeIntegerOverflow:           "integer overflow",
+	TrapCodeIntegerDivisionByZero:     "integer divide by zero",
+	TrapCodeBadConversionToInteger:    "invalid conversion to integer",
+	TrapCodeUnreachable:               "unreachable",
+	TrapCodeInterrupt:                 "interrupt",
+	TrapCodeOutOfFuel:                 "all fuel consumed",
+	TrapCodeAtomicWaitNonSharedMemory: "atomic wait on non-shared memory",
+	TrapCodeNullReference:             "null reference",
+	TrapCodeArrayOutOfBounds:          "out of bounds array access",
+	TrapCodeAllocationTooLarge:        "allocation size too large",
+	TrapCodeCastFailure:               "cast failure",
+}
+
+func (c TrapCode) String() string {
+	if int(c) < len(trapCodeNames) {
+		return trapCodeNames[c]
+	}
+	if c == TrapCodeUnknown {
+		return "unknown"
+	}
+	return fmt.Sprintf("trap code %d", uint8(c))
+}
+
+// IsResourceExhaustion reports whether the trap was caused by running out of
+// a resource rather than by a bug in the guest: the stack, fuel, an epoch
+// deadline, or memory for an allocation.
+func (c TrapCode) IsResourceExhaustion() bool {
+	switch c {
+	case TrapCodeStackOverflow, TrapCodeInterrupt, TrapCodeOutOfFuel, TrapCodeAllocationTooLarge:
+		return true
+	default:
+		return false
+	}
+}
+
+// Frame is one entry of the WebAssembly backtrace of a trap, innermost first.
+type Frame struct {
+	// ModuleName is the name of the module, or empty if it has none.
+	ModuleName string
+	// FuncIndex is the index of the function within its module.
+	FuncIndex uint32
+	// FuncName is the name of the function from the name section, or empty.
+	FuncName string
+	// FuncOffset is the offset of the trapping instruction within the function.
+	FuncOffset uintptr
+	// ModuleOffset is the offset of the trapping instruction within the module.
+	ModuleOffset uintptr
+}
+
+func (f Frame) String() string {
+	name := f.FuncName
+	if name == "" {
+		name = fmt.Sprintf("<wasm function %d>", f.FuncIndex)
+	}
+	if f.ModuleName != "" {
+		name = f.ModuleName + "!" + name
+	}
+	return fmt.Sprintf("%s+0x%x", name, f.FuncOffset)
+}
+
+// TrapError is returned when WebAssembly execution traps.
+//
+// A trap is either raised by the guest, in which case Code tells why, or by
+// a host function returning an error, in which case Code is TrapCodeUnknown
+// and Unwrap returns the host error.
+type TrapError struct {
+	// Code is why execution trapped.
+	Code TrapCode
+	// Message is wasmtime's description of the trap.
+	Message string
+	// Frames is the WebAssembly backtrace at the trap, innermost first.
+	Frames []Frame
+
+	hostErr error
+}
+
+func (e *TrapError) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the error of the host function that raised the trap, if any.
+func (e *TrapError) Unwrap() error {
+	return e.hostErr
+}
+
+// newTrapError converts trap into a TrapError, taking ownership of trap.
+func (b *bindings) newTrapError(trap wasm_trap_t) *TrapError {
+	defer b.wasm_trap_delete(trap)
+
+	var msg wasm_byte_vec_t
+	b.wasm_trap_message(trap, &msg)
+	e := &TrapError{
+		Code:    TrapCodeUnknown,
+		Message: string(msg.toGoBytes()),
+	}
+	b.wasm_byte_vec_delete(&msg)
+
+	var code uint8
+	if b.wasmtime_trap_code(trap, &code) {
+		e.Code = TrapCode(code)
+	}
+
+	var trace wasm_frame_vec_t
+	b.wasm_trap_trace(trap, &trace)
+	if trace.size > 0 {
+		frames := unsafe.Slice(trace.data, trace.size)
+		e.Frames = make([]Frame, len(frames))
+		for i, frame := range frames {
+			e.Frames[i] = Frame{
+				ModuleName:   b.frameName(b.wasmtime_frame_module_name(frame)),
+				FuncIndex:    b.wasm_frame_func_index(frame),
+				FuncName:     b.frameName(b.wasmtime_frame_func_name(frame)),
+				FuncOffset:   b.wasm_frame_func_offset(frame),
+				ModuleOffset: b.wasm_frame_module_offset(frame),
+			}
+		}
+	}
+	b.wasm_frame_vec_delete(&trace)
+
+	return e
+}
+
+// frameName copies a name owned by a frame, which may be absent.
+func (b *bindings) frameName(name *wasm_byte_vec_t) string {
+	if name == nil {
+		return ""
+	}
+	return string(name.toGoBytes())
+}
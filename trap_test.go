@@ -0,0 +1,104 @@
+package wasmtime
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrapCode(t *testing.T) {
+	assert.Equal(t, "unreachable", TrapCodeUnreachable.String())
+	assert.Equal(t, "integer divide by zero", TrapCodeIntegerDivisionByZero.String())
+	assert.Equal(t, "unknown", TrapCodeUnknown.String())
+	assert.Equal(t, "trap code 100", TrapCode(100).String())
+
+	assert.True(t, TrapCodeOutOfFuel.IsResourceExhaustion())
+	assert.True(t, TrapCodeStackOverflow.IsResourceExhaustion())
+	assert.False(t, TrapCodeMemoryOutOfBounds.IsResourceExhaustion())
+	assert.False(t, TrapCodeUnknown.IsResourceExhaustion())
+}
+
+func TestTrapError(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntime(ctx)
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	hostErr := errors.New("host failure")
+	host := r.NewHostModuleBuilder("env")
+	host.NewFunctionBuilder("fail", nil, nil).
+		WithFunc(func(ctx context.Context) error { return hostErr }).
+		Export("fail")
+	require.NoError(t, host.Instantiate(ctx))
+	defer host.Close(ctx)
+
+	wat := `
+		(module $traps
+			(import "env" "fail" (func $fail))
+			(memory 1)
+			(func $inner unreachable)
+			(func (export "unreachable") call $inner)
+			(func (export "div") (param i32 i32) (result i32)
+				(i32.div_s (local.get 0) (local.get 1)))
+			(func (export "oob") (result i32)
+				(i32.load (i32.const 0x10000)))
+			(func $recurse (export "recurse") call $recurse)
+			(func (export "host") call $fail)
+		)
+	`
+
+	compiled, err := r.CompileModule(ctx, []byte(wat))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.Instantiate(ctx, compiled)
+	require.NoError(t, err)
+	defer mod.Close(ctx)
+
+	trapOf := func(t *testing.T, name string, params ...uint64) *TrapError {
+		_, err := mod.ExportedFunction(name).Call(ctx, params...)
+		var trapErr *TrapError
+		require.ErrorAs(t, err, &trapErr)
+		return trapErr
+	}
+
+	t.Run("guest traps", func(t *testing.T) {
+		assert.Equal(t, TrapCodeUnreachable, trapOf(t, "unreachable").Code)
+		assert.Equal(t, TrapCodeIntegerDivisionByZero, trapOf(t, "div", EncodeI32(1), EncodeI32(0)).Code)
+		assert.Equal(t, TrapCodeMemoryOutOfBounds, trapOf(t, "oob").Code)
+
+		overflow := trapOf(t, "recurse")
+		assert.Equal(t, TrapCodeStackOverflow, overflow.Code)
+		assert.True(t, overflow.Code.IsResourceExhaustion())
+	})
+
+	t.Run("backtrace", func(t *testing.T) {
+		frames := trapOf(t, "unreachable").Frames
+		require.Len(t, frames, 2)
+		assert.Equal(t, "traps", frames[0].ModuleName)
+		assert.Equal(t, "inner", frames[0].FuncName)
+		assert.Equal(t, uint32(1), frames[0].FuncIndex)
+		assert.Equal(t, uint32(2), frames[1].FuncIndex)
+	})
+
+	t.Run("host errors", func(t *testing.T) {
+		trapErr := trapOf(t, "host")
+		assert.Equal(t, TrapCodeUnknown, trapErr.Code)
+		assert.ErrorIs(t, trapErr, hostErr)
+
+		// Guest traps after a host error do not inherit it
+		assert.NoError(t, errors.Unwrap(trapOf(t, "unreachable")))
+	})
+
+	t.Run("typed functions", func(t *testing.T) {
+		div, err := Func2[int32, int32, int32](mod, "div")
+		require.NoError(t, err)
+		_, err = div(ctx, 1, 0)
+		var trapErr *TrapError
+		require.ErrorAs(t, err, &trapErr)
+		assert.Equal(t, TrapCodeIntegerDivisionByZero, trapErr.Code)
+	})
+}
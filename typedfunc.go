@@ -0,0 +1,214 @@
+package wasmtime
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"unsafe"
+
+	"github.com/rvigee/purego-wasmtime/api"
+)
+
+// Value is the set of Go types that map directly to Wasm numeric values:
+// int32 and uint32 map to i32, int64 and uint64 to i64, float32 to f32 and
+// float64 to f64.
+type Value interface {
+	~int32 | ~uint32 | ~int64 | ~uint64 | ~float32 | ~float64
+}
+
+// Tuple2 holds the results of a function returning two values.
+type Tuple2[A, B Value] struct {
+	V0 A
+	V1 B
+}
+
+// Tuple3 holds the results of a function returning three values.
+type Tuple3[A, B, C Value] struct {
+	V0 A
+	V1 B
+	V2 C
+}
+
+// Tuple4 holds the results of a function returning four values.
+type Tuple4[A, B, C, D Value] struct {
+	V0 A
+	V1 B
+	V2 C
+	V3 D
+}
+
+// The FuncN helpers look up an exported function and return a strongly typed
+// Go func calling it. The signature is checked once, at lookup, against the
+// function's definition.
+//
+// The result type R is either a Value for a single result, a struct whose
+// fields are all Values (such as Tuple2) for multiple results, or struct{}
+// for none.
+//
+// The returned func reuses a single value stack rather than allocating
+// param and result slices per call. Like the module it was obtained from,
+// it must not be called concurrently.
+
+// Func0 returns a typed func calling the export name, which takes no params.
+func Func0[R any](mod api.Module, name string) (func(context.Context) (R, error), error) {
+	tf, err := newTypedFunc[R](mod, name)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) (R, error) {
+		return tf.invoke(ctx)
+	}, nil
+}
+
+// Func1 returns a typed func calling the export name, which takes one param.
+func Func1[P1 Value, R any](mod api.Module, name string) (func(context.Context, P1) (R, error), error) {
+	tf, err := newTypedFunc[R](mod, name, valueTypeOf[P1]())
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, p1 P1) (R, error) {
+		tf.stack[0] = encodeValue(p1)
+		return tf.invoke(ctx)
+	}, nil
+}
+
+// Func2 returns a typed func calling the export name, which takes two params.
+func Func2[P1, P2 Value, R any](mod api.Module, name string) (func(context.Context, P1, P2) (R, error), error) {
+	tf, err := newTypedFunc[R](mod, name, valueTypeOf[P1](), valueTypeOf[P2]())
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, p1 P1, p2 P2) (R, error) {
+		tf.stack[0] = encodeValue(p1)
+		tf.stack[1] = encodeValue(p2)
+		return tf.invoke(ctx)
+	}, nil
+}
+
+// Func3 returns a typed func calling the export name, which takes three params.
+func Func3[P1, P2, P3 Value, R any](mod api.Module, name string) (func(context.Context, P1, P2, P3) (R, error), error) {
+	tf, err := newTypedFunc[R](mod, name, valueTypeOf[P1](), valueTypeOf[P2](), valueTypeOf[P3]())
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, p1 P1, p2 P2, p3 P3) (R, error) {
+		tf.stack[0] = encodeValue(p1)
+		tf.stack[1] = encodeValue(p2)
+		tf.stack[2] = encodeValue(p3)
+		return tf.invoke(ctx)
+	}, nil
+}
+
+// Func4 returns a typed func calling the export name, which takes four params.
+func Func4[P1, P2, P3, P4 Value, R any](mod api.Module, name string) (func(context.Context, P1, P2, P3, P4) (R, error), error) {
+	tf, err := newTypedFunc[R](mod, name, valueTypeOf[P1](), valueTypeOf[P2](), valueTypeOf[P3](), valueTypeOf[P4]())
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, p1 P1, p2 P2, p3 P3, p4 P4) (R, error) {
+		tf.stack[0] = encodeValue(p1)
+		tf.stack[1] = encodeValue(p2)
+		tf.stack[2] = encodeValue(p3)
+		tf.stack[3] = encodeValue(p4)
+		return tf.invoke(ctx)
+	}, nil
+}
+
+// typedFunc is the shared state behind the FuncN helpers.
+type typedFunc[R any] struct {
+	fn     api.Function
+	stack  []uint64
+	layout []resultField // Where each result is stored within R
+}
+
+// resultField locates one result within the memory of a result value.
+type resultField struct {
+	offset uintptr
+	size   uintptr
+}
+
+func newTypedFunc[R any](mod api.Module, name string, paramTypes ...api.ValueType) (*typedFunc[R], error) {
+	fn := mod.ExportedFunction(name)
+	if fn == nil {
+		return nil, fmt.Errorf("function %q not found", name)
+	}
+
+	resultTypes, layout, err := resultLayout(reflect.TypeFor[R]())
+	if err != nil {
+		return nil, fmt.Errorf("function %q: %w", name, err)
+	}
+
+	def := fn.Definition()
+	if !equalValueTypes(def.ParamTypes(), paramTypes) {
+		return nil, fmt.Errorf("function %q: params are %v, not %v", name, def.ParamTypes(), paramTypes)
+	}
+	if !equalValueTypes(def.ResultTypes(), resultTypes) {
+		return nil, fmt.Errorf("function %q: results are %v, not %v", name, def.ResultTypes(), resultTypes)
+	}
+
+	return &typedFunc[R]{
+		fn:     fn,
+		stack:  make([]uint64, max(len(paramTypes), len(resultTypes))),
+		layout: layout,
+	}, nil
+}
+
+// invoke calls the function with the params already on the stack and
+// decodes the results into an R.
+func (tf *typedFunc[R]) invoke(ctx context.Context) (R, error) {
+	var r R
+	if err := tf.fn.CallWithStack(ctx, tf.stack); err != nil {
+		return r, err
+	}
+	base := unsafe.Pointer(&r)
+	for i, field := range tf.layout {
+		if field.size == 4 {
+			*(*uint32)(unsafe.Add(base, field.offset)) = uint32(tf.stack[i])
+		} else {
+			*(*uint64)(unsafe.Add(base, field.offset)) = tf.stack[i]
+		}
+	}
+	return r, nil
+}
+
+// resultLayout returns the Wasm result types represented by t and where
+// each of them lives within a value of type t.
+func resultLayout(t reflect.Type) ([]api.ValueType, []resultField, error) {
+	if t.Kind() != reflect.Struct {
+		vt, err := goTypeToValueType(t)
+		if err != nil {
+			return nil, nil, fmt.Errorf("result: %w", err)
+		}
+		return []api.ValueType{vt}, []resultField{{offset: 0, size: t.Size()}}, nil
+	}
+
+	types := make([]api.ValueType, 0, t.NumField())
+	layout := make([]resultField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		vt, err := goTypeToValueType(field.Type)
+		if err != nil {
+			return nil, nil, fmt.Errorf("result field %s: %w", field.Name, err)
+		}
+		types = append(types, vt)
+		layout = append(layout, resultField{offset: field.Offset, size: field.Type.Size()})
+	}
+	return types, layout, nil
+}
+
+// valueTypeOf returns the Wasm value type of T.
+func valueTypeOf[T Value]() api.ValueType {
+	// Value only admits types goTypeToValueType accepts
+	vt, _ := goTypeToValueType(reflect.TypeFor[T]())
+	return vt
+}
+
+// encodeValue encodes v into its uint64 stack representation. The bit
+// patterns of 32-bit ints and floats are zero-extended, matching EncodeI32,
+// EncodeU32 and EncodeF32.
+func encodeValue[T Value](v T) uint64 {
+	if unsafe.Sizeof(v) == 4 {
+		return uint64(*(*uint32)(unsafe.Pointer(&v)))
+	}
+	return *(*uint64)(unsafe.Pointer(&v))
+}
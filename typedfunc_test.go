@@ -0,0 +1,134 @@
+package wasmtime_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	wasmtime "github.com/rvigee/purego-wasmtime"
+)
+
+// TestTypedFunc tests the generic FuncN wrappers
+func TestTypedFunc(t *testing.T) {
+	ctx := context.Background()
+	r, err := wasmtime.NewRuntime(ctx)
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	wat := `
+		(module
+			(global $calls (mut i32) (i32.const 0))
+			(func (export "bump")
+				global.get $calls
+				i32.const 1
+				i32.add
+				global.set $calls
+			)
+			(func (export "calls") (result i32)
+				global.get $calls
+			)
+			(func (export "add") (param i32 i32) (result i32)
+				local.get 0
+				local.get 1
+				i32.add
+			)
+			(func (export "divmod") (param i64 i64) (result i64 i64)
+				local.get 0
+				local.get 1
+				i64.div_u
+				local.get 0
+				local.get 1
+				i64.rem_u
+			)
+			(func (export "mix") (param i32 i64 f32 f64) (result f64)
+				local.get 0
+				f64.convert_i32_s
+				local.get 1
+				f64.convert_i64_s
+				f64.add
+				local.get 2
+				f64.promote_f32
+				f64.add
+				local.get 3
+				f64.add
+			)
+		)
+	`
+
+	compiled, err := r.CompileModule(ctx, []byte(wat))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.Instantiate(ctx, compiled)
+	require.NoError(t, err)
+	defer mod.Close(ctx)
+
+	t.Run("no params, no results", func(t *testing.T) {
+		bump, err := wasmtime.Func0[struct{}](mod, "bump")
+		require.NoError(t, err)
+		calls, err := wasmtime.Func0[uint32](mod, "calls")
+		require.NoError(t, err)
+
+		_, err = bump(ctx)
+		require.NoError(t, err)
+		_, err = bump(ctx)
+		require.NoError(t, err)
+
+		n, err := calls(ctx)
+		require.NoError(t, err)
+		require.Equal(t, uint32(2), n)
+	})
+
+	t.Run("single result", func(t *testing.T) {
+		add, err := wasmtime.Func2[int32, int32, int32](mod, "add")
+		require.NoError(t, err)
+
+		sum, err := add(ctx, 5, 7)
+		require.NoError(t, err)
+		require.Equal(t, int32(12), sum)
+
+		sum, err = add(ctx, -10, 3)
+		require.NoError(t, err)
+		require.Equal(t, int32(-7), sum)
+	})
+
+	t.Run("multiple results", func(t *testing.T) {
+		divmod, err := wasmtime.Func2[uint64, uint64, wasmtime.Tuple2[uint64, uint64]](mod, "divmod")
+		require.NoError(t, err)
+
+		res, err := divmod(ctx, 17, 5)
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), res.V0)
+		require.Equal(t, uint64(2), res.V1)
+	})
+
+	t.Run("mixed types", func(t *testing.T) {
+		mix, err := wasmtime.Func4[int32, int64, float32, float64, float64](mod, "mix")
+		require.NoError(t, err)
+
+		res, err := mix(ctx, -1, 2, 0.5, 0.25)
+		require.NoError(t, err)
+		require.Equal(t, 1.75, res)
+	})
+
+	t.Run("signature mismatch", func(t *testing.T) {
+		_, err := wasmtime.Func2[int64, int32, int32](mod, "add")
+		require.Error(t, err)
+
+		_, err = wasmtime.Func2[int32, int32, int64](mod, "add")
+		require.Error(t, err)
+
+		_, err = wasmtime.Func1[int32, int32](mod, "add")
+		require.Error(t, err)
+
+		_, err = wasmtime.Func2[uint64, uint64, uint64](mod, "divmod")
+		require.Error(t, err)
+
+		_, err = wasmtime.Func0[string](mod, "calls")
+		require.Error(t, err)
+
+		_, err = wasmtime.Func0[int32](mod, "missing")
+		require.Error(t, err)
+	})
+}
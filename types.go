@@ -7,15 +7,37 @@ import (
 // Opaque C types - these are pointers to C structs we don't need to know the internals of
 type (
 	wasm_engine_t      uintptr
+	wasm_config_t      uintptr
 	wasmtime_store_t   uintptr
 	wasmtime_context_t uintptr
 	wasmtime_module_t  uintptr
 	wasmtime_error_t   uintptr
 	wasm_trap_t        uintptr
+	wasm_frame_t       uintptr
 	wasm_functype_t    uintptr
 	wasi_config_t      uintptr
 	wasmtime_linker_t  uintptr
 	wasm_valtype_t     uintptr // Pointer to value type
+	wasm_globaltype_t  uintptr
+	wasm_memorytype_t  uintptr
+	wasm_tabletype_t   uintptr
+
+	wasmtime_sharedmemory_t uintptr
+)
+
+// wasm_limits_t - From C header: min and max sizes of memories and tables
+type wasm_limits_t struct {
+	min uint32
+	max uint32
+}
+
+// wasm_limits_max_default is the max value meaning "no maximum"
+const wasm_limits_max_default = 0xffffffff
+
+// wasm_mutability_t enum values
+const (
+	WASM_CONST = 0
+	WASM_VAR   = 1
 )
 
 // wasm_valkind_t represents the kind of a WebAssembly value type
@@ -28,6 +50,15 @@ type wasmtime_func_t struct {
 	__padding uint64 // Padding to match memory layout
 }
 
+// wasmtime_externref_t - From C header
+// A rooted reference to a host value. A zero store_id is the null externref.
+type wasmtime_externref_t struct {
+	store_id   uint64
+	__private1 uint32
+	__private2 uint32
+	__private3 uintptr
+}
+
 // wasmtime_table_t - From C header
 type wasmtime_table_t struct {
 	store_id   uint64
@@ -71,6 +102,12 @@ type wasm_byte_vec_t struct {
 	data *byte
 }
 
+// wasm_frame_vec_t represents a vector of trap frames in C
+type wasm_frame_vec_t struct {
+	size uintptr
+	data *wasm_frame_t
+}
+
 // wasm_valtype_vec_t represents a vector of value types in C
 type wasm_valtype_vec_t struct {
 	size uintptr
@@ -84,6 +121,13 @@ type wasmtime_val_raw struct {
 	data [24]byte // Union size must match C: sizeof(wasmtime_valunion_t) = 24
 }
 
+// wasmtime_val_raw_t is the untagged value representation used by
+// wasmtime_func_call_unchecked. Unlike wasmtime_val_raw above, this union is
+// 16 bytes wide (its largest member is v128).
+type wasmtime_val_raw_t struct {
+	data [16]byte
+}
+
 // wasmtime_val_t represents a WebAssembly value with its type
 // The C struct has padding for alignment
 // Total size: 1 + 7 + 24 = 32 bytes
@@ -104,6 +148,13 @@ const (
 	WASM_EXTERNREF = 6
 )
 
+// wasm_valkind_t values of the reference types, as used by wasm_valtype_t.
+// They differ from the kinds tagging a wasmtime_val_t above.
+const (
+	WASM_VALKIND_EXTERNREF = 128
+	WASM_VALKIND_FUNCREF   = 129
+)
+
 // Helper to get i32 from wasmtime_val_t
 func (v *wasmtime_val_t) GetI32() int32 {
 	return *(*int32)(unsafe.Pointer(&v.of.data[0]))
@@ -171,14 +222,8 @@ func (v *wasmtime_val_t) SetFuncRef(val wasmtime_func_t) {
 }
 
 // Helper to get externref from wasmtime_val_t
-func (v *wasmtime_val_t) GetExternRef() uintptr {
-	return *(*uintptr)(unsafe.Pointer(&v.of.data[0]))
-}
-
-// Helper to set externref in wasmtime_val_t
-func (v *wasmtime_val_t) SetExternRef(val uintptr) {
-	v.kind = 6
-	*(*uintptr)(unsafe.Pointer(&v.of.data[0])) = val
+func (v *wasmtime_val_t) AsExternRef() *wasmtime_externref_t {
+	return (*wasmtime_externref_t)(unsafe.Pointer(&v.of.data[0]))
 }
 
 // wasmtime_extern_kind_t enum values
@@ -187,6 +232,8 @@ const (
 	WASMTIME_EXTERN_GLOBAL = 1
 	WASMTIME_EXTERN_TABLE  = 2
 	WASMTIME_EXTERN_MEMORY = 3
+
+	WASMTIME_EXTERN_SHAREDMEMORY = 4
 )
 
 // wasmtime_extern_union is a union type for external items
@@ -225,6 +272,12 @@ func (e *wasmtime_extern_t) AsMemory() *wasmtime_memory_t {
 	return (*wasmtime_memory_t)(unsafe.Pointer(&e.of.data[0]))
 }
 
+// Helper to get a shared memory from extern. Unlike the other kinds, the
+// union holds a pointer, owned by whoever received the extern.
+func (e *wasmtime_extern_t) AsSharedMemory() *wasmtime_sharedmemory_t {
+	return (*wasmtime_sharedmemory_t)(unsafe.Pointer(&e.of.data[0]))
+}
+
 // Helper to get global from extern
 func (e *wasmtime_extern_t) AsGlobal() *wasmtime_global_t {
 	return (*wasmtime_global_t)(unsafe.Pointer(&e.of.data[0]))
@@ -233,7 +286,7 @@ func (e *wasmtime_extern_t) AsGlobal() *wasmtime_global_t {
 // wasmtime_extern_kind_t is an alias for uint8
 type wasmtime_extern_kind_t = uint8
 
-// WASI permission flags
+// WASI permission flags, for WASIConfig.WithPreopenDirPerms
 const (
 	WASI_DIR_PERMS_READ   = 1
 	WASI_DIR_PERMS_WRITE  = 2
@@ -0,0 +1,117 @@
+package wasmtime
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rvigee/purego-wasmtime/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecodeV128(t *testing.T) {
+	v := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	lo, hi := EncodeV128(v)
+	require.Equal(t, uint64(0x0807060504030201), lo)
+	require.Equal(t, uint64(0x100f0e0d0c0b0a09), hi)
+	require.Equal(t, v, DecodeV128(lo, hi))
+}
+
+// TestV128 tests that v128 values keep all 128 bits across calls, globals
+// and host functions
+func TestV128(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntime(ctx)
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	// Swaps the halves of a v128, so both must survive the round trip
+	host := r.NewHostModuleBuilder("env")
+	host.NewFunctionBuilder("swap_halves",
+		[]api.ValueType{api.ValueTypeI32, api.ValueTypeV128},
+		[]api.ValueType{api.ValueTypeV128, api.ValueTypeI32},
+	).WithGoFunc(func(ctx context.Context, stack []uint64) {
+		tag, lo, hi := stack[0], stack[1], stack[2]
+		stack[3], stack[4] = hi, lo
+		stack[5] = tag
+	}).Export("swap_halves")
+	require.NoError(t, host.Instantiate(ctx))
+	defer host.Close(ctx)
+
+	wat := `
+		(module
+			(import "env" "swap_halves" (func $swap_halves (param i32 v128) (result v128 i32)))
+			(global (export "vec") (mut v128) (v128.const i64x2 1 2))
+			(global (export "const_vec") v128 (v128.const i64x2 3 4))
+			(func (export "add") (param v128 v128) (result v128)
+				local.get 0
+				local.get 1
+				i64x2.add
+			)
+			(func (export "split") (param f64 v128 i32) (result i32 v128 f64)
+				local.get 2
+				local.get 1
+				local.get 0
+			)
+			(func (export "call_host") (param v128) (result v128 i32)
+				i32.const 42
+				local.get 0
+				call $swap_halves
+			)
+		)
+	`
+
+	compiled, err := r.CompileModule(ctx, []byte(wat))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.Instantiate(ctx, compiled)
+	require.NoError(t, err)
+	defer mod.Close(ctx)
+
+	t.Run("call", func(t *testing.T) {
+		add := mod.ExportedFunction("add")
+		results, err := add.Call(ctx, 1, 1<<40, 2, 3<<40)
+		require.NoError(t, err)
+		require.Equal(t, []uint64{3, 4 << 40}, results)
+
+		// Two slots per v128 param
+		_, err = add.Call(ctx, 1, 2)
+		require.Error(t, err)
+	})
+
+	t.Run("mixed stack layout", func(t *testing.T) {
+		split := mod.ExportedFunction("split").(*function)
+		for _, unchecked := range []bool{true, false} {
+			split.unchecked = unchecked
+			stack := []uint64{EncodeF64(1.5), 10, 20, EncodeI32(-1)}
+			require.NoError(t, split.CallWithStack(ctx, stack))
+			require.Equal(t, []uint64{EncodeI32(-1), 10, 20, EncodeF64(1.5)}, stack)
+		}
+	})
+
+	t.Run("host function", func(t *testing.T) {
+		results, err := mod.ExportedFunction("call_host").Call(ctx, 5, 6)
+		require.NoError(t, err)
+		require.Equal(t, []uint64{6, 5, 42}, results)
+	})
+
+	t.Run("globals", func(t *testing.T) {
+		vec := mod.ExportedGlobal("vec")
+		require.Equal(t, api.ValueTypeV128, vec.Type())
+		v128, ok := vec.(api.V128Global)
+		require.True(t, ok)
+
+		lo, hi := v128.GetV128(ctx)
+		require.Equal(t, uint64(1), lo)
+		require.Equal(t, uint64(2), hi)
+
+		require.NoError(t, v128.SetV128(ctx, 7, 8))
+		lo, hi = v128.GetV128(ctx)
+		require.Equal(t, uint64(7), lo)
+		require.Equal(t, uint64(8), hi)
+		require.Equal(t, uint64(7), vec.Get(ctx))
+
+		constVec := mod.ExportedGlobal("const_vec").(api.V128Global)
+		require.Error(t, constVec.SetV128(ctx, 0, 0))
+	})
+}
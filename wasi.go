@@ -1,7 +1,11 @@
 package wasmtime
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"os"
+	"unsafe"
 )
 
 // WASIConfig represents WASI configuration.
@@ -15,9 +19,22 @@ type WASIConfig interface {
 	// WithEnvs sets multiple environment variables.
 	WithEnvs(env map[string]string) WASIConfig
 
-	// WithPreopenDir grants WASI access to a directory.
+	// WithPreopenDir grants WASI read and write access to a directory.
 	WithPreopenDir(hostPath, guestPath string) WASIConfig
 
+	// WithPreopenDirPerms grants WASI access to a directory with the given
+	// permissions. dirPerms combines WASI_DIR_PERMS_READ, to list the
+	// directory and look up its entries, and WASI_DIR_PERMS_WRITE, to create,
+	// rename and remove them. filePerms combines WASI_FILE_PERMS_READ and
+	// WASI_FILE_PERMS_WRITE, and caps how the files within can be opened.
+	// Preopening a guest path again replaces the previous preopen.
+	WithPreopenDirPerms(hostPath, guestPath string, dirPerms, filePerms uint) WASIConfig
+
+	// WithReadOnlyPreopen grants WASI access to a directory that the guest
+	// can read but not modify, such as configuration mounted into an
+	// untrusted guest.
+	WithReadOnlyPreopen(hostPath, guestPath string) WASIConfig
+
 	// WithInheritArgs inherits arguments from the host process.
 	WithInheritArgs() WASIConfig
 
@@ -36,29 +53,58 @@ type WASIConfig interface {
 	// WithInheritStdio inherits all stdio from the host process.
 	WithInheritStdio() WASIConfig
 
-	// apply is an internal method to apply configuration to a store context
-	apply(storeCtx wasmtime_context_t, bindings *bindings) error
+	// WithStdin feeds the guest's stdin from r, copied by a goroutine. The
+	// guest reads EOF once r does, or once the context given to
+	// instantiation is done. A reader still blocked when the store is closed
+	// is left to return on its own.
+	WithStdin(r io.Reader) WASIConfig
+
+	// WithStdinBytes feeds the guest's stdin from b, without a goroutine.
+	WithStdinBytes(b []byte) WASIConfig
+
+	// WithStdout writes the guest's stdout to w. Output is copied by a
+	// goroutine, and is only guaranteed to have reached w once the store
+	// of the WASI context is closed, such as when the module is closed.
+	WithStdout(w io.Writer) WASIConfig
+
+	// WithStderr writes the guest's stderr to w, like WithStdout.
+	WithStderr(w io.Writer) WASIConfig
+
+	// apply is an internal method to apply configuration to a store context.
+	// The returned pipes must be waited for once the store is deleted.
+	apply(ctx context.Context, storeCtx wasmtime_context_t, bindings *bindings) (*stdioPipes, error)
 }
 
 type wasiConfig struct {
 	args          []string
 	env           map[string]string
-	preopenDirs   map[string]string // host -> guest
+	preopenDirs   []wasiPreopen
 	inheritArgs   bool
 	inheritEnv    bool
 	inheritStdin  bool
 	inheritStdout bool
 	inheritStderr bool
+	stdin         io.Reader
+	stdinBytes    []byte
+	stdout        io.Writer
+	stderr        io.Writer
 }
 
 // NewWASIConfig creates a new WASI configuration.
 func NewWASIConfig() WASIConfig {
 	return &wasiConfig{
-		env:         make(map[string]string),
-		preopenDirs: make(map[string]string),
+		env: make(map[string]string),
 	}
 }
 
+// wasiPreopen is a host directory made available to the guest.
+type wasiPreopen struct {
+	hostPath  string
+	guestPath string
+	dirPerms  uint
+	filePerms uint
+}
+
 // WithArgs sets command-line arguments (variadic for easier use).
 func (w *wasiConfig) WithArgs(args ...string) WASIConfig {
 	w.args = args
@@ -79,12 +125,31 @@ func (w *wasiConfig) WithEnvs(env map[string]string) WASIConfig {
 	return w
 }
 
-// WithPreopenDir grants WASI access to a directory.
+// WithPreopenDir grants WASI read and write access to a directory.
 func (w *wasiConfig) WithPreopenDir(hostPath, guestPath string) WASIConfig {
-	w.preopenDirs[hostPath] = guestPath
+	return w.WithPreopenDirPerms(hostPath, guestPath,
+		WASI_DIR_PERMS_READ|WASI_DIR_PERMS_WRITE, WASI_FILE_PERMS_READ|WASI_FILE_PERMS_WRITE)
+}
+
+// WithPreopenDirPerms grants WASI access to a directory with the given
+// permissions.
+func (w *wasiConfig) WithPreopenDirPerms(hostPath, guestPath string, dirPerms, filePerms uint) WASIConfig {
+	preopen := wasiPreopen{hostPath: hostPath, guestPath: guestPath, dirPerms: dirPerms, filePerms: filePerms}
+	for i, p := range w.preopenDirs {
+		if p.guestPath == guestPath {
+			w.preopenDirs[i] = preopen
+			return w
+		}
+	}
+	w.preopenDirs = append(w.preopenDirs, preopen)
 	return w
 }
 
+// WithReadOnlyPreopen grants WASI read-only access to a directory.
+func (w *wasiConfig) WithReadOnlyPreopen(hostPath, guestPath string) WASIConfig {
+	return w.WithPreopenDirPerms(hostPath, guestPath, WASI_DIR_PERMS_READ, WASI_FILE_PERMS_READ)
+}
+
 // WithInheritArgs inherits arguments from the host process.
 func (w *wasiConfig) WithInheritArgs() WASIConfig {
 	w.inheritArgs = true
@@ -120,12 +185,36 @@ func (w *wasiConfig) WithInheritStdio() WASIConfig {
 	return w.WithInheritStdin().WithInheritStdout().WithInheritStderr()
 }
 
+// WithStdin feeds the guest's stdin from r.
+func (w *wasiConfig) WithStdin(r io.Reader) WASIConfig {
+	w.stdin, w.stdinBytes = r, nil
+	return w
+}
+
+// WithStdinBytes feeds the guest's stdin from b.
+func (w *wasiConfig) WithStdinBytes(b []byte) WASIConfig {
+	w.stdin, w.stdinBytes = nil, b
+	return w
+}
+
+// WithStdout writes the guest's stdout to w.
+func (w *wasiConfig) WithStdout(out io.Writer) WASIConfig {
+	w.stdout = out
+	return w
+}
+
+// WithStderr writes the guest's stderr to w.
+func (w *wasiConfig) WithStderr(out io.Writer) WASIConfig {
+	w.stderr = out
+	return w
+}
+
 // apply applies the WASI configuration to a store context (internal method).
-func (w *wasiConfig) apply(storeCtx wasmtime_context_t, bindings *bindings) error {
+func (w *wasiConfig) apply(ctx context.Context, storeCtx wasmtime_context_t, bindings *bindings) (*stdioPipes, error) {
 	// Create WASI config
 	ptr := bindings.wasi_config_new()
 	if ptr == 0 {
-		return fmt.Errorf("failed to create WASI config")
+		return nil, fmt.Errorf("failed to create WASI config")
 	}
 
 	// Apply arguments
@@ -152,8 +241,11 @@ func (w *wasiConfig) apply(storeCtx wasmtime_context_t, bindings *bindings) erro
 	}
 
 	// Apply preopen directories
-	for hostPath, guestPath := range w.preopenDirs {
-		bindings.wasi_config_preopen_dir(ptr, cString(hostPath), cString(guestPath))
+	for _, p := range w.preopenDirs {
+		if !bindings.wasi_config_preopen_dir(ptr, cString(p.hostPath), cString(p.guestPath), uintptr(p.dirPerms), uintptr(p.filePerms)) {
+			bindings.wasi_config_delete(ptr)
+			return nil, fmt.Errorf("failed to preopen %s as %s", p.hostPath, p.guestPath)
+		}
 	}
 
 	// Apply stdio inheritance
@@ -167,13 +259,62 @@ func (w *wasiConfig) apply(storeCtx wasmtime_context_t, bindings *bindings) erro
 		bindings.wasi_config_inherit_stderr(ptr)
 	}
 
+	// Route stdio to Go, the process's own streams being inherited directly
+	pipes := &stdioPipes{}
+	switch {
+	case w.stdinBytes != nil:
+		// The config takes ownership of a copy in a vector of its own
+		var vec wasm_byte_vec_t
+		bindings.wasm_byte_vec_new_uninitialized(&vec, uintptr(len(w.stdinBytes)))
+		if len(w.stdinBytes) > 0 {
+			copy(unsafe.Slice(vec.data, vec.size), w.stdinBytes)
+		}
+		bindings.wasi_config_set_stdin_bytes(ptr, &vec)
+	case w.stdin == nil:
+	case w.stdin == os.Stdin:
+		bindings.wasi_config_inherit_stdin(ptr)
+	default:
+		err := pipes.input(ctx, w.stdin, func(path *byte) bool { return bindings.wasi_config_set_stdin_file(ptr, path) })
+		if err != nil {
+			bindings.wasi_config_delete(ptr)
+			return nil, fmt.Errorf("failed to redirect stdin: %w", err)
+		}
+	}
+	outputs := []struct {
+		name    string
+		w       io.Writer
+		std     *os.File
+		inherit func(wasi_config_t)
+		open    func(wasi_config_t, *byte) bool
+	}{
+		{"stdout", w.stdout, os.Stdout, bindings.wasi_config_inherit_stdout, bindings.wasi_config_set_stdout_file},
+		{"stderr", w.stderr, os.Stderr, bindings.wasi_config_inherit_stderr, bindings.wasi_config_set_stderr_file},
+	}
+	for _, o := range outputs {
+		switch o.w {
+		case nil:
+		case o.std:
+			o.inherit(ptr)
+		default:
+			err := pipes.output(o.w, func(path *byte) bool { return o.open(ptr, path) })
+			if err != nil {
+				// Deleting the config closes the pipes opened so far
+				bindings.wasi_config_delete(ptr)
+				pipes.wait()
+				return nil, fmt.Errorf("failed to redirect %s: %w", o.name, err)
+			}
+		}
+	}
+
 	// Set WASI context
 	err := bindings.wasmtime_context_set_wasi(storeCtx, ptr)
 	if err != 0 {
-		return fmt.Errorf("failed to set WASI: %w", bindings.getErrorMessage(err, 0))
+		// The config, and with it the pipes, is dropped even on failure
+		pipes.wait()
+		return nil, fmt.Errorf("failed to set WASI: %w", bindings.getErrorMessage(err, 0))
 	}
 	// Note: wasmtime takes ownership of the config, so we don't delete it
-	return nil
+	return pipes, nil
 }
 
 // WASIExitError represents a WASI program exit.
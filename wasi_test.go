@@ -0,0 +1,202 @@
+package wasmtime
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rvigee/purego-wasmtime/api"
+)
+
+// preopenWAT opens files of the first preopen, returning the errno: "read"
+// opens config.txt for reading and "create" creates new.txt.
+const preopenWAT = `
+(module
+	(import "wasi_snapshot_preview1" "path_open"
+		(func $path_open (param i32 i32 i32 i32 i32 i64 i64 i32 i32) (result i32)))
+	(memory (export "memory") 1)
+	(data (i32.const 0) "config.txt")
+	(data (i32.const 16) "new.txt")
+	(func (export "read") (result i32)
+		(call $path_open (i32.const 3) (i32.const 0) (i32.const 0) (i32.const 10)
+			(i32.const 0) (i64.const 2) (i64.const 0) (i32.const 0) (i32.const 100)))
+	(func (export "create") (result i32)
+		(call $path_open (i32.const 3) (i32.const 0) (i32.const 16) (i32.const 7)
+			(i32.const 1) (i64.const 64) (i64.const 0) (i32.const 0) (i32.const 100)))
+)`
+
+func TestWASIPreopenPerms(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.txt"), []byte("key=value"), 0o644))
+
+	tests := []struct {
+		name      string
+		config    WASIConfig
+		canCreate bool
+	}{
+		{"read only", NewWASIConfig().WithReadOnlyPreopen(dir, "/config"), false},
+		{"read write", NewWASIConfig().WithPreopenDir(dir, "/config"), true},
+		{"explicit perms", NewWASIConfig().WithPreopenDirPerms(dir, "/config", WASI_DIR_PERMS_READ, WASI_FILE_PERMS_READ|WASI_FILE_PERMS_WRITE), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := t.Context()
+			os.Remove(filepath.Join(dir, "new.txt"))
+
+			r, err := NewRuntimeWithConfig(ctx, NewRuntimeConfig().WithWASI(tt.config))
+			require.NoError(t, err)
+			defer r.Close(ctx)
+
+			compiled, err := r.CompileModule(ctx, []byte(preopenWAT))
+			require.NoError(t, err)
+			defer compiled.Close()
+
+			mod, err := r.InstantiateWithWASI(ctx, compiled)
+			require.NoError(t, err)
+
+			results, err := mod.ExportedFunction("read").Call(ctx)
+			require.NoError(t, err)
+			assert.Zero(t, results[0], "reading is allowed")
+
+			results, err = mod.ExportedFunction("create").Call(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tt.canCreate, results[0] == 0, "errno %d", results[0])
+			_, err = os.Stat(filepath.Join(dir, "new.txt"))
+			assert.Equal(t, tt.canCreate, err == nil)
+		})
+	}
+}
+
+// argsWAT prints its NUL-separated args to stdout with "print".
+const argsWAT = `
+(module
+	(import "wasi_snapshot_preview1" "args_sizes_get"
+		(func $args_sizes_get (param i32 i32) (result i32)))
+	(import "wasi_snapshot_preview1" "args_get"
+		(func $args_get (param i32 i32) (result i32)))
+	(import "wasi_snapshot_preview1" "fd_write"
+		(func $fd_write (param i32 i32 i32 i32) (result i32)))
+	(memory (export "memory") 1)
+	(func (export "print")
+		(drop (call $args_sizes_get (i32.const 0) (i32.const 4)))
+		(drop (call $args_get (i32.const 100) (i32.const 1024)))
+		(i32.store (i32.const 8) (i32.const 1024))
+		(i32.store (i32.const 12) (i32.load (i32.const 4)))
+		(drop (call $fd_write (i32.const 1) (i32.const 8) (i32.const 1) (i32.const 16))))
+)`
+
+func TestInstantiateWithWASIConfig(t *testing.T) {
+	ctx := t.Context()
+	var runtimeOut strings.Builder
+	r, err := NewRuntimeWithConfig(ctx, NewRuntimeConfig().WithWASI(NewWASIConfig().
+		WithArgs("default").
+		WithStdout(&runtimeOut)))
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	compiled, err := r.CompileModule(ctx, []byte(argsWAT))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	var outA, outB strings.Builder
+	modA, err := r.InstantiateWithWASIConfig(ctx, compiled, NewWASIConfig().WithArgs("a").WithStdout(&outA))
+	require.NoError(t, err)
+	modB, err := r.InstantiateWithWASIConfig(ctx, compiled, NewWASIConfig().WithArgs("b", "-v").WithStdout(&outB))
+	require.NoError(t, err)
+	modDefault, err := r.InstantiateWithWASI(ctx, compiled)
+	require.NoError(t, err)
+
+	// Later instances leave the contexts of earlier ones alone
+	for _, mod := range []api.Module{modA, modB, modDefault} {
+		_, err := mod.ExportedFunction("print").Call(ctx)
+		require.NoError(t, err)
+		require.NoError(t, mod.Close(ctx))
+	}
+	assert.Equal(t, "a\x00", outA.String())
+	assert.Equal(t, "b\x00-v\x00", outB.String())
+	assert.Equal(t, "default\x00", runtimeOut.String())
+
+	_, err = r.InstantiateWithWASIConfig(ctx, compiled, nil)
+	assert.Error(t, err)
+}
+
+func TestInstantiateWithWASIHostExterns(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntime(ctx)
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	// env is what Emscripten and clang builds import
+	env := r.NewHostModuleBuilder("env")
+	env.NewMemory(1).Export("memory")
+	env.NewGlobal(api.ValueTypeI32, EncodeI32(7)).WithMutable().Export("__stack_pointer")
+	env.NewTable(api.ValueTypeFuncref, 1).Export("__indirect_function_table")
+	require.NoError(t, env.Instantiate(ctx))
+
+	compiled, err := r.CompileModule(ctx, []byte(`(module
+		(import "env" "memory" (memory 1))
+		(import "env" "__stack_pointer" (global $sp (mut i32)))
+		(import "env" "__indirect_function_table" (table 1 funcref))
+		(func (export "bump") (result i32)
+			(global.set $sp (i32.add (global.get $sp) (i32.const 1)))
+			(i32.store (i32.const 0) (global.get $sp))
+			(global.get $sp)))`))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	// Each instance with a store of its own gets its own copy
+	bump := func(mod api.Module) uint64 {
+		results, err := mod.ExportedFunction("bump").Call(ctx)
+		require.NoError(t, err)
+		return results[0]
+	}
+	modA, err := r.InstantiateWithWASI(ctx, compiled)
+	require.NoError(t, err)
+	defer modA.Close(ctx)
+	modB, err := r.InstantiateModule(ctx, compiled, NewModuleConfig())
+	require.NoError(t, err)
+	defer modB.Close(ctx)
+	assert.Equal(t, uint64(8), bump(modA))
+	assert.Equal(t, uint64(9), bump(modA))
+	assert.Equal(t, uint64(8), bump(modB))
+
+	// Instances of the runtime's store share its copy, also after linking
+	// other stores
+	shared1, err := r.Instantiate(ctx, compiled)
+	require.NoError(t, err)
+	shared2, err := r.Instantiate(ctx, compiled)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(8), bump(shared1))
+	assert.Equal(t, uint64(9), bump(shared2))
+}
+
+func TestWASIPreopenMissingDir(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntimeWithConfig(ctx, NewRuntimeConfig().WithWASI(
+		NewWASIConfig().WithReadOnlyPreopen(filepath.Join(t.TempDir(), "missing"), "/missing")))
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	compiled, err := r.CompileModule(ctx, []byte(preopenWAT))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	_, err = r.InstantiateWithWASI(ctx, compiled)
+	assert.ErrorContains(t, err, "failed to preopen")
+}
+
+func TestWASIConfigPreopens(t *testing.T) {
+	w := NewWASIConfig().
+		WithPreopenDir("/host/a", "/a").
+		WithReadOnlyPreopen("/host/b", "/b").
+		WithPreopenDirPerms("/host/c", "/a", WASI_DIR_PERMS_READ, 0).(*wasiConfig)
+
+	assert.Equal(t, []wasiPreopen{
+		{hostPath: "/host/c", guestPath: "/a", dirPerms: WASI_DIR_PERMS_READ, filePerms: 0},
+		{hostPath: "/host/b", guestPath: "/b", dirPerms: WASI_DIR_PERMS_READ, filePerms: WASI_FILE_PERMS_READ},
+	}, w.preopenDirs, "preopening /a again replaces it")
+}
@@ -0,0 +1,108 @@
+package wasmtime
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// stdioPipes connects the stdio of a WASI context to Go readers and writers.
+// The C API can only give WASI files to open, so each stream is a pipe whose
+// guest end wasmtime opens through /dev/fd, and whose host end a goroutine
+// pumps. The pumps end once the WASI context, and with it wasmtime's end of
+// the pipes, is dropped along with its store.
+type stdioPipes struct {
+	wg sync.WaitGroup
+
+	// closers release the host end of input pipes
+	closers []func()
+}
+
+// fdPath returns a path opening f again, for the C API.
+func fdPath(f *os.File) string {
+	return fmt.Sprintf("/dev/fd/%d", f.Fd())
+}
+
+// input feeds r to a guest input stream, such as with
+// wasi_config_set_stdin_file. The guest reads EOF once r does, or once ctx is
+// done.
+func (p *stdioPipes) input(ctx context.Context, r io.Reader, open func(path *byte) bool) error {
+	if runtime.GOOS == "windows" {
+		return fmt.Errorf("redirecting WASI stdio to Go is not supported on %s", runtime.GOOS)
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	// Wasmtime opens a descriptor of its own, so ours is closed either way
+	ok := open(cString(fdPath(pr)))
+	pr.Close()
+	if !ok {
+		pw.Close()
+		return fmt.Errorf("failed to open pipe")
+	}
+
+	// Closing the write end is what the guest sees as EOF. It also unblocks
+	// the pump if the guest stops reading.
+	var once sync.Once
+	closeWriter := func() {
+		once.Do(func() { pw.Close() })
+	}
+	p.closers = append(p.closers, closeWriter)
+	stop := context.AfterFunc(ctx, closeWriter)
+	go func() {
+		defer stop()
+		defer closeWriter()
+		io.Copy(pw, r)
+	}()
+	return nil
+}
+
+// output routes a guest output stream to w. open hands a path to the C API,
+// such as with wasi_config_set_stdout_file, which opens it right away.
+func (p *stdioPipes) output(w io.Writer, open func(path *byte) bool) error {
+	if runtime.GOOS == "windows" {
+		return fmt.Errorf("redirecting WASI stdio to Go is not supported on %s", runtime.GOOS)
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	// Wasmtime opens a descriptor of its own, so ours is closed either way
+	ok := open(cString(fdPath(pw)))
+	pw.Close()
+	if !ok {
+		pr.Close()
+		return fmt.Errorf("failed to open pipe")
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer pr.Close()
+		if _, err := io.Copy(w, pr); err != nil {
+			// Keep draining so that the guest never blocks on a full pipe
+			io.Copy(io.Discard, pr)
+		}
+	}()
+	return nil
+}
+
+// wait blocks until the pumps have delivered everything the guest wrote. It
+// must only be called after the WASI context has been dropped.
+//
+// Input pumps are not waited for, since they may be blocked reading from a
+// reader that never returns: their pipes are closed, and they end once the
+// reader returns.
+func (p *stdioPipes) wait() {
+	if p == nil {
+		return
+	}
+	for _, closeWriter := range p.closers {
+		closeWriter()
+	}
+	p.wg.Wait()
+}
@@ -0,0 +1,237 @@
+package wasmtime
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stdioWAT writes its data segment n times to the fd given to "write", and
+// echoes up to 64KiB of stdin to stdout with "echo".
+const stdioWAT = `
+(module
+	(import "wasi_snapshot_preview1" "fd_write"
+		(func $fd_write (param i32 i32 i32 i32) (result i32)))
+	(import "wasi_snapshot_preview1" "fd_read"
+		(func $fd_read (param i32 i32 i32 i32) (result i32)))
+	(memory (export "memory") 2)
+	(data (i32.const 16) "hello\n")
+	(func (export "write") (param $fd i32) (param $n i32)
+		(i32.store (i32.const 0) (i32.const 16))
+		(i32.store (i32.const 4) (i32.const 6))
+		(block $done
+			(loop $next
+				(br_if $done (i32.eqz (local.get $n)))
+				(drop (call $fd_write (local.get $fd) (i32.const 0) (i32.const 1) (i32.const 8)))
+				(local.set $n (i32.sub (local.get $n) (i32.const 1)))
+				(br $next))))
+	(func (export "echo")
+		(local $total i32)
+		(block $eof
+			(loop $next
+				(i32.store (i32.const 0) (i32.add (i32.const 1024) (local.get $total)))
+				(i32.store (i32.const 4) (i32.sub (i32.const 65536) (local.get $total)))
+				(drop (call $fd_read (i32.const 0) (i32.const 0) (i32.const 1) (i32.const 8)))
+				(br_if $eof (i32.eqz (i32.load (i32.const 8))))
+				(local.set $total (i32.add (local.get $total) (i32.load (i32.const 8))))
+				(br $next)))
+		(i32.store (i32.const 0) (i32.const 1024))
+		(i32.store (i32.const 4) (local.get $total))
+		(drop (call $fd_write (i32.const 1) (i32.const 0) (i32.const 1) (i32.const 8))))
+)`
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestWASIStdout(t *testing.T) {
+	ctx := t.Context()
+	r, err := NewRuntime(ctx)
+	require.NoError(t, err)
+	defer r.Close(ctx)
+
+	compiled, err := r.CompileModule(ctx, []byte(stdioWAT))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	t.Run("stdout and stderr", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		mod, err := r.InstantiateModule(ctx, compiled, NewModuleConfig().WithStdout(&stdout).WithStderr(&stderr))
+		require.NoError(t, err)
+
+		_, err = mod.ExportedFunction("write").Call(ctx, 1, 2)
+		require.NoError(t, err)
+		_, err = mod.ExportedFunction("write").Call(ctx, 2, 1)
+		require.NoError(t, err)
+
+		// Closing the module waits for the output to be copied
+		require.NoError(t, mod.Close(ctx))
+		assert.Equal(t, "hello\nhello\n", stdout.String())
+		assert.Equal(t, "hello\n", stderr.String())
+	})
+
+	t.Run("run command", func(t *testing.T) {
+		var stdout bytes.Buffer
+		code, err := r.RunCommand(ctx, compiled, NewModuleConfig().
+			WithStdout(&stdout).
+			WithStartFunctions("echo"))
+		require.NoError(t, err)
+		assert.Equal(t, 0, code)
+		assert.Empty(t, stdout.String(), "stdin is empty by default")
+	})
+
+	t.Run("stdin reader", func(t *testing.T) {
+		var stdout bytes.Buffer
+		code, err := r.RunCommand(ctx, compiled, NewModuleConfig().
+			WithStdin(strings.NewReader("request body")).
+			WithStdout(&stdout).
+			WithStartFunctions("echo"))
+		require.NoError(t, err)
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "request body", stdout.String())
+	})
+
+	t.Run("stdin cancelled", func(t *testing.T) {
+		// The reader never returns, so only cancelling ends the stream
+		blocked, w := io.Pipe()
+		defer w.Close()
+		instCtx, cancel := context.WithCancel(ctx)
+
+		var stdout bytes.Buffer
+		mod, err := r.InstantiateModule(instCtx, compiled, NewModuleConfig().
+			WithStdin(blocked).
+			WithStdout(&stdout))
+		require.NoError(t, err)
+
+		cancel()
+		_, err = mod.ExportedFunction("echo").Call(ctx)
+		require.NoError(t, err)
+		require.NoError(t, mod.Close(ctx))
+		assert.Empty(t, stdout.String())
+	})
+
+	t.Run("failing writer", func(t *testing.T) {
+		mod, err := r.InstantiateModule(ctx, compiled, NewModuleConfig().WithStdout(failingWriter{}))
+		require.NoError(t, err)
+		defer mod.Close(ctx)
+
+		// More than a pipe buffer: the guest must not block once w fails
+		_, err = mod.ExportedFunction("write").Call(ctx, 1, 50000)
+		require.NoError(t, err)
+	})
+}
+
+func TestWASIConfigStdout(t *testing.T) {
+	ctx := t.Context()
+	var stdout strings.Builder
+	r, err := NewRuntimeWithConfig(ctx, NewRuntimeConfig().WithWASI(NewWASIConfig().WithStdout(&stdout)))
+	require.NoError(t, err)
+
+	compiled, err := r.CompileModule(ctx, []byte(stdioWAT))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.InstantiateWithWASI(ctx, compiled)
+	require.NoError(t, err)
+	_, err = mod.ExportedFunction("write").Call(ctx, 1, 1)
+	require.NoError(t, err)
+
+	require.NoError(t, r.Close(ctx))
+	assert.Equal(t, "hello\n", stdout.String())
+}
+
+func TestWASIConfigStdinBytes(t *testing.T) {
+	ctx := t.Context()
+	var stdout strings.Builder
+	r, err := NewRuntimeWithConfig(ctx, NewRuntimeConfig().WithWASI(NewWASIConfig().
+		WithStdinBytes([]byte("from bytes")).
+		WithStdout(&stdout)))
+	require.NoError(t, err)
+
+	compiled, err := r.CompileModule(ctx, []byte(stdioWAT))
+	require.NoError(t, err)
+	defer compiled.Close()
+
+	mod, err := r.InstantiateWithWASI(ctx, compiled)
+	require.NoError(t, err)
+	_, err = mod.ExportedFunction("echo").Call(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, r.Close(ctx))
+	assert.Equal(t, "from bytes", stdout.String())
+}
+
+func TestStdioPipesOutput(t *testing.T) {
+	var out bytes.Buffer
+	pipes := &stdioPipes{}
+
+	// Open the path the way wasmtime does, then write and close as the
+	// dropped WASI context would
+	var guest *os.File
+	err := pipes.output(&out, func(path *byte) bool {
+		f, err := os.OpenFile(fromCString(path), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+		guest = f
+		return err == nil
+	})
+	require.NoError(t, err)
+	_, err = guest.WriteString("captured")
+	require.NoError(t, err)
+	require.NoError(t, guest.Close())
+
+	pipes.wait()
+	assert.Equal(t, "captured", out.String())
+}
+
+func TestStdioPipesInput(t *testing.T) {
+	// openGuest opens the path the way wasmtime does
+	var guest *os.File
+	openGuest := func(path *byte) bool {
+		f, err := os.Open(fromCString(path))
+		guest = f
+		return err == nil
+	}
+
+	t.Run("reader", func(t *testing.T) {
+		pipes := &stdioPipes{}
+		require.NoError(t, pipes.input(t.Context(), strings.NewReader("data"), openGuest))
+		b, err := io.ReadAll(guest)
+		require.NoError(t, err)
+		assert.Equal(t, "data", string(b))
+		guest.Close()
+		pipes.wait()
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		blocked, w := io.Pipe()
+		defer w.Close()
+		ctx, cancel := context.WithCancel(t.Context())
+
+		pipes := &stdioPipes{}
+		require.NoError(t, pipes.input(ctx, blocked, openGuest))
+		cancel()
+		b, err := io.ReadAll(guest)
+		require.NoError(t, err)
+		assert.Empty(t, b, "cancelling ends the stream with EOF")
+		guest.Close()
+		pipes.wait()
+	})
+}
+
+// fromCString copies a NUL-terminated C string.
+func fromCString(p *byte) string {
+	var b []byte
+	for ; *p != 0; p = (*byte)(unsafe.Add(unsafe.Pointer(p), 1)) {
+		b = append(b, *p)
+	}
+	return string(b)
+}